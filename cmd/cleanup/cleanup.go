@@ -0,0 +1,42 @@
+package cleanup
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"slack-reconcile-deployments/cmd/flags"
+	"slack-reconcile-deployments/internal/logging"
+	dockerbackend "slack-reconcile-deployments/internal/reconcile/backend/docker"
+)
+
+// New returns the cleanup command
+func New() *cli.Command {
+	return &cli.Command{
+		Name: "cleanup",
+		Usage: `removes docker containers leaked by earlier runs of the docker provider, like runs ` +
+			`killed before cleaning up. Containers of runs still running are kept. ` +
+			`go run main.go cleanup`,
+		Flags: []cli.Flag{
+			flags.FlagQuiet,
+			&cli.BoolFlag{
+				Name:  flags.FlagNameAll,
+				Usage: "remove all containers created by the docker provider, including those of running runs",
+				Value: false,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			log := logging.New(c.App.Name, c.Bool(flags.FlagNameQuiet))
+			removed, err := dockerbackend.Cleanup(log, c.Bool(flags.FlagNameAll))
+			for _, id := range removed {
+				fmt.Printf("removed %s\n", id)
+			}
+			if err != nil {
+				log.Errorf("error cleaning up containers: %+v", err)
+				return err
+			}
+			fmt.Printf("removed %d containers\n", len(removed))
+			return nil
+		},
+	}
+}
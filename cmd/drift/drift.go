@@ -0,0 +1,121 @@
+package drift
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"slack-reconcile-deployments/cmd/flags"
+	"slack-reconcile-deployments/internal/logging"
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile"
+	"slack-reconcile-deployments/internal/reconcile/backend"
+	"slack-reconcile-deployments/internal/rollout"
+)
+
+// ExitCodeDrift is the exit code when drift is detected on any target.
+// Errors exit with 1, so drift can be distinguished from failures.
+const ExitCodeDrift = 2
+
+// New returns the drift command
+func New() *cli.Command {
+	const maxDriftManifests = 10
+
+	return &cli.Command{
+		Name: "drift",
+		Usage: `detects drift between the state described in manifest files and remote hosts. ` +
+			`Read-only, does not change remote hosts. Exits with 2 when drift is detected.`,
+		Flags: []cli.Flag{
+			flags.FlagConcurrency,
+			flags.FlagManifest,
+			flags.FlagPackages,
+			flags.FlagTimeout,
+			flags.FlagPassword,
+			flags.FlagReport,
+			flags.FlagBatchSize,
+			flags.FlagMaxFailures,
+			flags.FlagPause,
+			flags.FlagPipeline,
+			flags.FlagOnError,
+			flags.FlagEscalation,
+			flags.FlagEscalationPassword,
+		},
+		Action: func(c *cli.Context) error {
+			log := logging.New(c.App.Name, c.Bool(flags.FlagNameQuiet))
+			manifestPaths := c.StringSlice(flags.FlagNameManifest)
+			if len(manifestPaths) > maxDriftManifests {
+				return errors.Errorf("manifest argument is over the limtit of %d", maxDriftManifests)
+			}
+			packagesPath := c.String(flags.FlagNamePackages)
+			// files only readable by root are read with the escalation
+			runCtx := reconcile.WithEscalation(c.Context, flags.Escalation(c))
+
+			// read all manifests first, so a bad manifest fails before any host is checked
+			manifests := make([]*manifest.Manifest, len(manifestPaths))
+			for i := range manifestPaths {
+				m, err := manifest.NewFromFile(manifestPaths[i], packagesPath)
+				if err != nil {
+					log.Errorf("error reading manifest file: %+v", err)
+					return err
+				}
+				manifests[i] = m
+			}
+			stages, err := flags.RolloutStages(c, manifests)
+			if err != nil {
+				return err
+			}
+			timeout, err := time.ParseDuration(c.String(flags.FlagNameTimeout))
+			if err != nil {
+				return err
+			}
+
+			// reports are indexed by manifest path, each go routine writes its own index
+			reports := make([]*reconcile.Report, len(manifestPaths))
+			result, err := rollout.RunStages(runCtx, log, stages, func(ctx context.Context, i int) error {
+				m := manifests[i]
+				var options []func(reconciler backend.ProviderBackendReconciler)
+				if m.Provider == manifest.ProviderBackendSlack {
+					options = append(options, func(reconciler backend.ProviderBackendReconciler) {
+						reconciler.WithOption("password", c.String(flags.FlagNamePassword))
+					})
+				}
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				report, err := reconcile.Run(ctx, log, os.Stdout, m, reconcile.DetectDrift, options...)
+				reports[i] = report
+				if err != nil {
+					log.Errorf("error running drift for %s path: %s: %+v", m.ID, manifestPaths[i], err)
+					return err
+				}
+				return nil
+			})
+			for _, i := range result.Skipped {
+				reports[i] = reconcile.NewSkippedReport(manifests[i], reconcile.DetectDrift, "rollout stopped")
+			}
+			// a summary of all hosts, so failed hosts are not only in interleaved logs
+			if err := reconcile.WriteSummary(os.Stdout, reports); err != nil {
+				log.Warnf("unable to write summary: %v", err)
+			}
+			err = reconcile.AggregateError(err, reports)
+			if reportPath := c.String(flags.FlagNameReport); reportPath != "" {
+				if err := reconcile.WriteReportsFile(reportPath, reports); err != nil {
+					log.Errorf("error writing report %s: %+v", reportPath, err)
+					return err
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, report := range reports {
+				if report != nil && report.Drifted() {
+					return cli.Exit("drift detected", ExitCodeDrift)
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -4,8 +4,14 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/rollout"
+	"slack-reconcile-deployments/internal/ssh"
 )
 
 // maxConcurrency is the maximum number go routines for reconciling
@@ -21,9 +27,42 @@ const (
 	FlagNameQuiet          = "quiet"
 	FlagNameRemove         = "remove"
 	FlagNamePurge          = "purge"
+	FlagNameDryRun         = "dry-run"
+	FlagNameReport         = "report"
 	FlagNameUniqueIDFormat = "unique-id-format"
+	FlagNameRunID          = "run-id"
+	FlagNameTransactional  = "transactional"
+	FlagNameBatchSize      = "batch-size"
+	FlagNameMaxFailures    = "max-failures"
+	FlagNamePause          = "pause"
+	FlagNamePipeline       = "pipeline"
+	FlagNameOnError        = "on-error"
+	FlagNameLockStaleAfter = "lock-stale-after"
+	FlagNameAll            = "all"
+	// FlagNameEscalation is the privilege escalation method
+	FlagNameEscalation = "escalation"
+	// FlagNameEscalationPassword is the password of the privilege escalation
+	FlagNameEscalationPassword = "escalation-password"
 )
 
+// LimitConcurrency returns concurrency limited to max concurrency, prevents
+// overwhelming targets
+func LimitConcurrency(concurrency int) int {
+	if concurrency > maxConcurrency {
+		return maxConcurrency
+	}
+	return concurrency
+}
+
+// Escalation returns the privilege escalation of the escalation flags, the
+// method is not set when the flag is not set, so the manifest decides
+func Escalation(c *cli.Context) ssh.Escalation {
+	return ssh.Escalation{
+		Method:   ssh.EscalationMethod(c.String(FlagNameEscalation)),
+		Password: c.String(FlagNameEscalationPassword),
+	}
+}
+
 // shared/common flags
 var (
 	FlagQuiet = &cli.BoolFlag{
@@ -87,8 +126,155 @@ var (
 	}
 
 	FlagPurge = &cli.BoolFlag{
-		Name:  FlagNamePurge,
-		Usage: "purge operation, will cause reconcile to purge packages and file and stop services",
+		Name: FlagNamePurge,
+		Usage: "purge operation, will cause reconcile to stop and disable services, purge packages, " +
+			"remove managed files and config dirs, remove dependencies no longer needed and verify nothing is left",
+		Value: false,
+	}
+
+	FlagDryRun = &cli.BoolFlag{
+		Name: FlagNameDryRun,
+		Usage: "dry run, prints the packages, files, permissions and services reconcile " +
+			"would change without changing the target",
 		Value: false,
 	}
+
+	FlagReport = &cli.StringFlag{
+		Name:  FlagNameReport,
+		Usage: "path to write a json report of the outcome of each manifest",
+	}
+
+	FlagRunID = &cli.StringFlag{
+		Name:     FlagNameRunID,
+		Usage:    "id of the run to roll back, reconcile logs the run id and writes it to the report",
+		Required: true,
+	}
+
+	FlagTransactional = &cli.BoolFlag{
+		Name: FlagNameTransactional,
+		Usage: "revert file changes and restore services when reconcile fails, " +
+			"reverted changes are in the report",
+		Value: false,
+	}
+
+	FlagBatchSize = &cli.StringFlag{
+		Name: FlagNameBatchSize,
+		Usage: "number of hosts, like 2, or percentage of hosts, like 25%, reconciled in each batch of a " +
+			"rolling rollout, all hosts are one batch when not set",
+	}
+
+	FlagMaxFailures = &cli.IntFlag{
+		Name:  FlagNameMaxFailures,
+		Usage: "number of failed or unhealthy hosts tolerated, the rollout stops after a batch exceeding it",
+		Value: 0,
+	}
+
+	FlagPause = &cli.StringFlag{
+		Name:  FlagNamePause,
+		Usage: "pause between batches of a rolling rollout, will be parsed by time.ParseDuration",
+		Value: "0s",
+	}
+
+	FlagPipeline = &cli.StringFlag{
+		Name: FlagNamePipeline,
+		Usage: "path to a pipeline file ordering the groups of manifests into stages, a stage starts after " +
+			"the previous stage succeeded, all manifests are one stage when not set",
+	}
+
+	FlagOnError = &cli.StringFlag{
+		Name: FlagNameOnError,
+		Usage: "policy when a host fails, continue runs hosts in flight to completion, fail-fast cancels " +
+			"hosts in flight once failures exceed max failures",
+		Value: "continue",
+	}
+
+	FlagLockStaleAfter = &cli.StringFlag{
+		Name: FlagNameLockStaleAfter,
+		Usage: "age after which the lock of another run on a host is stale and broken, will be parsed by " +
+			"time.ParseDuration",
+		Value: "1h",
+	}
+
+	FlagEscalation = &cli.StringFlag{
+		Name: FlagNameEscalation,
+		Usage: "privilege escalation of commands on hosts, none, sudo, doas or su, overrides the escalation " +
+			"of manifests. When not set, the manifest escalation is used, or none for root and sudo otherwise",
+		Action: func(c *cli.Context, s string) error {
+			method, err := ssh.ParseEscalationMethod(s)
+			if err != nil {
+				return err
+			}
+			// doas and su read a password only from a terminal
+			return ssh.Escalation{Method: method, Password: c.String(FlagNameEscalationPassword)}.Validate()
+		},
+	}
+
+	FlagEscalationPassword = &cli.StringFlag{
+		Name: FlagNameEscalationPassword,
+		Usage: "password of the privilege escalation, written to stdin of sudo on hosts, doas and su cannot use " +
+			"a password. Prefer the environment variable, flags are visible to other users of the machine",
+		EnvVars: []string{"ESCALATION_PASSWORD"},
+	}
 )
+
+// RolloutStages returns the stages of the rollout of manifests from the
+// rollout flags. Manifests are assigned to stages by group when a pipeline is
+// set, otherwise all manifests are one stage.
+func RolloutStages(c *cli.Context, manifests []*manifest.Manifest) ([]rollout.Stage, error) {
+	pipelinePath := c.String(FlagNamePipeline)
+	if pipelinePath == "" {
+		hosts := make([]int, len(manifests))
+		for i := range hosts {
+			hosts[i] = i
+		}
+		opts, err := rolloutOptions(c, len(hosts))
+		if err != nil {
+			return nil, err
+		}
+		return []rollout.Stage{{Name: "all", Hosts: hosts, Options: opts}}, nil
+	}
+
+	pipeline, err := manifest.NewPipelineFromFile(pipelinePath)
+	if err != nil {
+		return nil, err
+	}
+	assigned, err := pipeline.Assign(manifests)
+	if err != nil {
+		return nil, err
+	}
+	stages := make([]rollout.Stage, 0, len(pipeline.Stages))
+	for i, s := range pipeline.Stages {
+		opts, err := rolloutOptions(c, len(assigned[i]))
+		if err != nil {
+			return nil, err
+		}
+		if s.Concurrency > 0 {
+			opts.Concurrency = LimitConcurrency(s.Concurrency)
+		}
+		stages = append(stages, rollout.Stage{Name: s.Group, Hosts: assigned[i], Options: opts})
+	}
+	return stages, nil
+}
+
+// rolloutOptions returns the rollout options from the flags for total hosts
+func rolloutOptions(c *cli.Context, total int) (rollout.Options, error) {
+	batchSize, err := rollout.ParseBatchSize(c.String(FlagNameBatchSize), total)
+	if err != nil {
+		return rollout.Options{}, err
+	}
+	pause, err := time.ParseDuration(c.String(FlagNamePause))
+	if err != nil {
+		return rollout.Options{}, errors.Wrapf(err, "invalid %s", FlagNamePause)
+	}
+	onError, err := rollout.ParseOnError(c.String(FlagNameOnError))
+	if err != nil {
+		return rollout.Options{}, err
+	}
+	return rollout.Options{
+		BatchSize:   batchSize,
+		Concurrency: c.Int(FlagNameConcurrency),
+		MaxFailures: c.Int(FlagNameMaxFailures),
+		Pause:       pause,
+		OnError:     onError,
+	}, nil
+}
@@ -2,17 +2,18 @@ package reconcile
 
 import (
 	"context"
+	"os"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
-	"golang.org/x/sync/errgroup"
 
 	"slack-reconcile-deployments/cmd/flags"
 	"slack-reconcile-deployments/internal/logging"
 	"slack-reconcile-deployments/internal/manifest"
 	"slack-reconcile-deployments/internal/reconcile"
 	"slack-reconcile-deployments/internal/reconcile/backend"
+	"slack-reconcile-deployments/internal/rollout"
 )
 
 // New returns the reconcile command
@@ -32,6 +33,17 @@ func New() *cli.Command {
 			flags.FlagPassword,
 			flags.FlagRemove,
 			flags.FlagPurge,
+			flags.FlagDryRun,
+			flags.FlagReport,
+			flags.FlagTransactional,
+			flags.FlagBatchSize,
+			flags.FlagMaxFailures,
+			flags.FlagPause,
+			flags.FlagPipeline,
+			flags.FlagOnError,
+			flags.FlagLockStaleAfter,
+			flags.FlagEscalation,
+			flags.FlagEscalationPassword,
 		},
 		Action: func(c *cli.Context) error {
 			log := logging.New(c.App.Name, c.Bool(flags.FlagNameQuiet))
@@ -44,17 +56,63 @@ func New() *cli.Command {
 			}
 			packagesPath := c.String("packages")
 
-			errgrp := errgroup.Group{}
-			errgrp.SetLimit(c.Int(flags.FlagNameConcurrency))
+			// all manifests share one run id, files overwritten by the run are
+			// backed up under it on each host for rollback
+			runID := reconcile.NewRunID()
+			log.Infof("run id %s", runID)
+			staleAfter, err := time.ParseDuration(c.String(flags.FlagNameLockStaleAfter))
+			if err != nil {
+				return errors.Wrapf(err, "invalid %s", flags.FlagNameLockStaleAfter)
+			}
+			runCtx := reconcile.WithTransactional(reconcile.WithRunID(c.Context, runID),
+				c.Bool(flags.FlagNameTransactional))
+			runCtx = reconcile.WithLockStaleAfter(runCtx, staleAfter)
+			runCtx = reconcile.WithEscalation(runCtx, flags.Escalation(c))
+
+			// choose the reconcile operation, either reconcile or remove.
+			// Remove is destructive and will delete files, remove packages
+			reconcileOP := reconcile.Reconcile
+			if c.Bool(flags.FlagNameRemove) {
+				reconcileOP = reconcile.Remove
+			}
+			if c.Bool(flags.FlagNamePurge) {
+				reconcileOP = reconcile.Purge
+			}
+			if c.Bool(flags.FlagNameDryRun) {
+				// a plan is only computed for reconcile, remove and purge are not planned
+				if reconcileOP != reconcile.Reconcile {
+					return errors.Errorf("%s cannot be combined with %s", flags.FlagNameDryRun, reconcileOP)
+				}
+				reconcileOP = reconcile.DryRun
+			}
+
+			// read all manifests first, so a bad manifest fails before any host is changed
+			manifests := make([]*manifest.Manifest, len(manifestPaths))
 			for i := range manifestPaths {
-				// capture/copy loop variable for go routine
-				i := i
 				m, err := manifest.NewFromFile(manifestPaths[i], packagesPath)
 				if err != nil {
 					log.Errorf("error reading manifest file: %+v", err)
 					return err
 				}
+				manifests[i] = m
+			}
+
+			stages, err := flags.RolloutStages(c, manifests)
+			if err != nil {
+				return err
+			}
+			timeout, err := time.ParseDuration(c.String(flags.FlagNameTimeout))
+			if err != nil {
+				return err
+			}
 
+			// reports are indexed by manifest path, each go routine writes its own index
+			reports := make([]*reconcile.Report, len(manifestPaths))
+			// hosts are reconciled in stages of batches, concurrency within a
+			// batch is limited to prevent ddos the targets. A batch is healthy
+			// when reconcile, including the health checks of the hosts, succeeds.
+			result, err := rollout.RunStages(runCtx, log, stages, func(ctx context.Context, i int) error {
+				m := manifests[i]
 				// uses functional options to set password on provider backend
 				// not all providers user plain usernames and password, so
 				// these options are dynamically set based on the provider.
@@ -64,36 +122,32 @@ func New() *cli.Command {
 						reconciler.WithOption("password", c.String("password"))
 					})
 				}
-
-				// choose the reconcile operation, either reconcile or remove.
-				// Remove is destructive and will delete files, remove packages
-				reconcileOP := reconcile.Reconcile
-				if c.Bool(flags.FlagNameRemove) {
-					reconcileOP = reconcile.Remove
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				report, err := reconcile.Run(ctx, log, os.Stdout, m, reconcileOP, options...)
+				reports[i] = report
+				if err != nil {
+					log.Errorf("error running reconcile for %s path: %s: %+v", m.ID, manifestPaths[i], err)
+					return err
 				}
-				if c.Bool(flags.FlagNamePurge) {
-					reconcileOP = reconcile.Purge
+				return nil
+			})
+			for _, i := range result.Skipped {
+				reports[i] = reconcile.NewSkippedReport(manifests[i], reconcileOP, "rollout stopped")
+			}
+			// a summary of all hosts, so failed hosts are not only in interleaved logs
+			if err := reconcile.WriteSummary(os.Stdout, reports); err != nil {
+				log.Warnf("unable to write summary: %v", err)
+			}
+			err = reconcile.AggregateError(err, reports)
+			// write the report even when reconcile fails, failures are in the report
+			if reportPath := c.String(flags.FlagNameReport); reportPath != "" {
+				if err := reconcile.WriteReportsFile(reportPath, reports); err != nil {
+					log.Errorf("error writing report %s: %+v", reportPath, err)
+					return err
 				}
-
-				// start go routines one per manifest path, concurrency is limited to prevent
-				// ddos the targets some future improvements could be to add some jitter, add
-				// deployment groups, respond the quantity of reconciles etc. For now this is
-				// approach is simple.
-				errgrp.Go(func() error {
-					timeout, err := time.ParseDuration(c.String(flags.FlagNameTimeout))
-					if err != nil {
-						return err
-					}
-					ctx, cancel := context.WithTimeout(c.Context, timeout)
-					defer cancel()
-					if err := reconcile.Run(ctx, log, m, reconcileOP, options...); err != nil {
-						log.Errorf("error running reconcile for %s path: %s: %+v", m.ID, manifestPaths[i], err)
-						return err
-					}
-					return nil
-				})
 			}
-			return errgrp.Wait()
+			return err
 		},
 	}
 }
@@ -0,0 +1,125 @@
+package rollback
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"slack-reconcile-deployments/cmd/flags"
+	"slack-reconcile-deployments/internal/logging"
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile"
+	"slack-reconcile-deployments/internal/reconcile/backend"
+	"slack-reconcile-deployments/internal/rollout"
+)
+
+// New returns the rollback command
+func New() *cli.Command {
+	const maxRollbackManifests = 10
+
+	return &cli.Command{
+		Name: "rollback",
+		Usage: `restores files on remote hosts from the backups of a reconcile run, then restarts or ` +
+			`reloads notified services. go run main.go rollback --run-id 20231016T193725Z-a1b2c3 ` +
+			`--manifest manifests/manifest1.yaml --packages packages.yaml`,
+		Flags: []cli.Flag{
+			flags.FlagConcurrency,
+			flags.FlagManifest,
+			flags.FlagPackages,
+			flags.FlagTimeout,
+			flags.FlagPassword,
+			flags.FlagReport,
+			flags.FlagRunID,
+			flags.FlagLockStaleAfter,
+			flags.FlagBatchSize,
+			flags.FlagMaxFailures,
+			flags.FlagPause,
+			flags.FlagPipeline,
+			flags.FlagOnError,
+			flags.FlagEscalation,
+			flags.FlagEscalationPassword,
+		},
+		Action: func(c *cli.Context) error {
+			log := logging.New(c.App.Name, c.Bool(flags.FlagNameQuiet))
+			manifestPaths := c.StringSlice(flags.FlagNameManifest)
+			if len(manifestPaths) > maxRollbackManifests {
+				return errors.Errorf("manifest argument is over the limtit of %d", maxRollbackManifests)
+			}
+			packagesPath := c.String(flags.FlagNamePackages)
+			if err := reconcile.ValidateRunID(c.String(flags.FlagNameRunID)); err != nil {
+				return err
+			}
+
+			// the rollback is a run too, files it overwrites are backed up
+			// under its own run id
+			runID := reconcile.NewRunID()
+			log.Infof("run id %s, rolling back run %s", runID, c.String(flags.FlagNameRunID))
+			staleAfter, err := time.ParseDuration(c.String(flags.FlagNameLockStaleAfter))
+			if err != nil {
+				return errors.Wrapf(err, "invalid %s", flags.FlagNameLockStaleAfter)
+			}
+			runCtx := reconcile.WithRollback(reconcile.WithRunID(c.Context, runID), c.String(flags.FlagNameRunID))
+			runCtx = reconcile.WithLockStaleAfter(runCtx, staleAfter)
+			runCtx = reconcile.WithEscalation(runCtx, flags.Escalation(c))
+
+			// read all manifests first, so a bad manifest fails before any host is changed
+			manifests := make([]*manifest.Manifest, len(manifestPaths))
+			for i := range manifestPaths {
+				m, err := manifest.NewFromFile(manifestPaths[i], packagesPath)
+				if err != nil {
+					log.Errorf("error reading manifest file: %+v", err)
+					return err
+				}
+				manifests[i] = m
+			}
+			stages, err := flags.RolloutStages(c, manifests)
+			if err != nil {
+				return err
+			}
+			timeout, err := time.ParseDuration(c.String(flags.FlagNameTimeout))
+			if err != nil {
+				return err
+			}
+
+			// reports are indexed by manifest path, each go routine writes its own index
+			reports := make([]*reconcile.Report, len(manifestPaths))
+			result, err := rollout.RunStages(runCtx, log, stages, func(ctx context.Context, i int) error {
+				m := manifests[i]
+				var options []func(reconciler backend.ProviderBackendReconciler)
+				if m.Provider == manifest.ProviderBackendSlack {
+					options = append(options, func(reconciler backend.ProviderBackendReconciler) {
+						reconciler.WithOption("password", c.String(flags.FlagNamePassword))
+					})
+				}
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				report, err := reconcile.Run(ctx, log, os.Stdout, m, reconcile.Rollback, options...)
+				reports[i] = report
+				if err != nil {
+					log.Errorf("error running rollback for %s path: %s: %+v", m.ID, manifestPaths[i], err)
+					return err
+				}
+				return nil
+			})
+			for _, i := range result.Skipped {
+				reports[i] = reconcile.NewSkippedReport(manifests[i], reconcile.Rollback, "rollout stopped")
+			}
+			// a summary of all hosts, so failed hosts are not only in interleaved logs
+			if err := reconcile.WriteSummary(os.Stdout, reports); err != nil {
+				log.Warnf("unable to write summary: %v", err)
+			}
+			err = reconcile.AggregateError(err, reports)
+			// write the report even when rollback fails, failures are in the report
+			if reportPath := c.String(flags.FlagNameReport); reportPath != "" {
+				if err := reconcile.WriteReportsFile(reportPath, reports); err != nil {
+					log.Errorf("error writing report %s: %+v", reportPath, err)
+					return err
+				}
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,147 @@
+package debversion
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Version is a parsed debian package version: [epoch:]upstream_version[-debian_revision]
+//
+// See https://www.debian.org/doc/debian-policy/ch-controlfields.html#version
+type Version struct {
+	// Epoch is the epoch, 0 when not provided
+	Epoch int
+	// Upstream is the upstream version
+	Upstream string
+	// Revision is the debian revision, empty when not provided
+	Revision string
+}
+
+// Parse parses a debian package version
+func Parse(s string) (*Version, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("version is empty")
+	}
+	v := &Version{Upstream: s}
+	if i := strings.Index(s, ":"); i != -1 {
+		epoch, err := strconv.Atoi(s[:i])
+		if err != nil || epoch < 0 {
+			return nil, errors.Errorf("invalid epoch in version %s", s)
+		}
+		v.Epoch = epoch
+		v.Upstream = s[i+1:]
+	}
+	if i := strings.LastIndex(v.Upstream, "-"); i != -1 {
+		v.Revision = v.Upstream[i+1:]
+		v.Upstream = v.Upstream[:i]
+		if v.Revision == "" {
+			return nil, errors.Errorf("empty revision in version %s", s)
+		}
+	}
+	if v.Upstream == "" || v.Upstream[0] < '0' || v.Upstream[0] > '9' {
+		return nil, errors.Errorf("upstream version must start with a digit in version %s", s)
+	}
+	for _, c := range v.Upstream + v.Revision {
+		if !isAlnum(c) && !strings.ContainsRune(".+~-:", c) {
+			return nil, errors.Errorf("invalid character %q in version %s", c, s)
+		}
+	}
+	return v, nil
+}
+
+// Compare compares debian versions a and b the same way as dpkg --compare-versions.
+// The result is negative when a < b, 0 when a == b, positive when a > b.
+// Versions that are not valid are compared as strings after valid versions.
+func Compare(a, b string) int {
+	va, errA := Parse(a)
+	vb, errB := Parse(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	}
+	return va.Compare(vb)
+}
+
+// Compare compares v with o, see Compare
+func (v *Version) Compare(o *Version) int {
+	if v.Epoch != o.Epoch {
+		if v.Epoch < o.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if c := compareFragment(v.Upstream, o.Upstream); c != 0 {
+		return c
+	}
+	return compareFragment(v.Revision, o.Revision)
+}
+
+// compareFragment compares an upstream version or revision with the dpkg
+// algorithm. Non digit parts are compared with letters sorting before non
+// letters and ~ sorting before everything, even the end of the part. Digit
+// parts are compared numerically.
+func compareFragment(a, b string) int {
+	for a != "" || b != "" {
+		// compare the non digit prefix
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			oa, ob := order(a), order(b)
+			if oa != ob {
+				return oa - ob
+			}
+			a, b = a[1:], b[1:]
+		}
+		// compare the digit prefix numerically, ignoring leading zeros
+		da, db := digits(a), digits(b)
+		a, b = a[len(da):], b[len(db):]
+		da, db = strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+		if len(da) != len(db) {
+			return len(da) - len(db)
+		}
+		if c := strings.Compare(da, db); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// order returns the sort weight of the first character of s
+func order(s string) int {
+	switch {
+	case s == "", isDigit(s[0]):
+		return 0
+	case s[0] == '~':
+		return -1
+	case isAlpha(rune(s[0])):
+		return int(s[0])
+	default:
+		return int(s[0]) + 256
+	}
+}
+
+// digits returns the leading digits of s
+func digits(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c rune) bool {
+	return isAlpha(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,148 @@
+package debversion
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Operator is a relation between versions in a constraint
+type Operator string
+
+const (
+	// OperatorMatch matches an equal version, or a version in the release
+	// series of the version when it has no debian revision. Used when a
+	// constraint has no operator.
+	OperatorMatch = Operator("")
+	// OperatorEqual matches an equal version
+	OperatorEqual = Operator("=")
+	// OperatorGreaterEqual matches a later or equal version
+	OperatorGreaterEqual = Operator(">=")
+	// OperatorLessEqual matches an earlier or equal version
+	OperatorLessEqual = Operator("<=")
+	// OperatorGreater matches a strictly later version
+	OperatorGreater = Operator(">>")
+	// OperatorLess matches a strictly earlier version
+	OperatorLess = Operator("<<")
+	// OperatorCompatible matches versions in the release series of the
+	// version, for example ~ 8.2 matches 8.2 and 8.2.7-1~deb12u1 but not 8.21 or 8.3
+	OperatorCompatible = Operator("~")
+)
+
+// operators are the constraint operators, longest first so >= is parsed before =
+var operators = []Operator{OperatorGreaterEqual, OperatorLessEqual, OperatorGreater, OperatorLess,
+	OperatorEqual, OperatorCompatible}
+
+// term is one relation in a constraint, like >= 1.22
+type term struct {
+	op      Operator
+	version *Version
+	raw     string
+}
+
+// Constraint is a version constraint: one or more terms separated by commas
+// that must all match, for example ">= 1.22, << 2.0".
+type Constraint struct {
+	raw   string
+	terms []term
+}
+
+// ParseConstraint parses a version constraint. Operators are the dpkg
+// relations =, >=, <=, >> and <<, and ~ for a release series. A version
+// without an operator matches like ~, unless it has a debian revision and
+// must be equal.
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{raw: strings.TrimSpace(s)}
+	if c.raw == "" {
+		return nil, errors.New("version constraint is empty")
+	}
+	for _, part := range strings.Split(c.raw, ",") {
+		t, err := parseTerm(strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid version constraint %q", c.raw)
+		}
+		c.terms = append(c.terms, t)
+	}
+	return c, nil
+}
+
+// parseTerm parses one term of a constraint
+func parseTerm(s string) (term, error) {
+	if s == "" {
+		return term{}, errors.New("empty term")
+	}
+	t := term{op: OperatorMatch, raw: s}
+	rest := s
+	for _, op := range operators {
+		if strings.HasPrefix(s, string(op)) {
+			t.op = op
+			rest = strings.TrimSpace(strings.TrimPrefix(s, string(op)))
+			break
+		}
+	}
+	if t.op == OperatorMatch && (strings.HasPrefix(s, "<") || strings.HasPrefix(s, ">")) {
+		return term{}, errors.Errorf("unknown operator in %q, use >=, <=, >> or <<", s)
+	}
+	v, err := Parse(rest)
+	if err != nil {
+		return term{}, err
+	}
+	t.version = v
+	if t.op == OperatorMatch && v.Revision != "" {
+		t.op = OperatorEqual
+	}
+	return t, nil
+}
+
+// String returns the constraint as written
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+// Match returns true when version satisfies every term of the constraint.
+// Versions that are not valid never match.
+func (c *Constraint) Match(version string) bool {
+	v, err := Parse(version)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.terms {
+		if !t.match(version, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns true when v satisfies the term
+func (t *term) match(version string, v *Version) bool {
+	cmp := v.Compare(t.version)
+	switch t.op {
+	case OperatorEqual:
+		return cmp == 0
+	case OperatorGreaterEqual:
+		return cmp >= 0
+	case OperatorLessEqual:
+		return cmp <= 0
+	case OperatorGreater:
+		return cmp > 0
+	case OperatorLess:
+		return cmp < 0
+	}
+	// OperatorMatch and OperatorCompatible
+	if cmp == 0 {
+		return true
+	}
+	prefix := strings.TrimPrefix(t.raw, string(t.op))
+	prefix = strings.TrimSpace(prefix)
+	if !strings.Contains(prefix, ":") {
+		// without an explicit epoch the series is matched against the version without epoch
+		if i := strings.Index(version, ":"); i != -1 {
+			version = version[i+1:]
+		}
+	}
+	if !strings.HasPrefix(version, prefix) || len(version) == len(prefix) {
+		return false
+	}
+	return strings.ContainsRune(".-+:", rune(version[len(prefix)]))
+}
@@ -0,0 +1,97 @@
+package debversion
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestCompare tests comparing versions like dpkg --compare-versions
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.0", b: "1.0", want: 0},
+		{a: "1.0", b: "1.00", want: 0},
+		{a: "1.0", b: "1.1", want: -1},
+		{a: "1.10", b: "1.9", want: 1},
+		{a: "1.0-1", b: "1.0-2", want: -1},
+		{a: "1.0-10", b: "1.0-9", want: 1},
+		{a: "1.0", b: "1.0-1", want: -1},
+		{a: "1:1.0", b: "2.0", want: 1},
+		{a: "0:2.0", b: "2.0", want: 0},
+		{a: "1.0~rc1", b: "1.0", want: -1},
+		{a: "1.0~~", b: "1.0~", want: -1},
+		{a: "1.0a", b: "1.0", want: 1},
+		{a: "1.0a", b: "1.0+", want: -1},
+		{a: "8.2.7-1~deb12u1", b: "8.2.7-1", want: -1},
+		{a: "1.22.1-9+deb12u1", b: "1.22.1-9", want: 1},
+	}
+	for _, tt := range tests {
+		got := Compare(tt.a, tt.b)
+		switch {
+		case got < 0:
+			got = -1
+		case got > 0:
+			got = 1
+		}
+		assert.Equal(t, got, tt.want, "%s %s", tt.a, tt.b)
+	}
+}
+
+// TestParse tests parsing the parts of a version and rejecting bad versions
+func TestParse(t *testing.T) {
+	v, err := Parse("1:9.18.19-1~deb12u1")
+	assert.NilError(t, err)
+	assert.Equal(t, v.Epoch, 1)
+	assert.Equal(t, v.Upstream, "9.18.19")
+	assert.Equal(t, v.Revision, "1~deb12u1")
+
+	for _, bad := range []string{"", "a1.0", "x:1.0", "1.0-", "1.0 2", "1.0_1"} {
+		_, err := Parse(bad)
+		assert.Check(t, err != nil, "expected error for %q", bad)
+	}
+}
+
+// TestParseConstraint tests parsing and rejecting malformed constraints
+func TestParseConstraint(t *testing.T) {
+	for _, good := range []string{"8.2", "= 1.22.1-9", ">= 1.22", "<<2.0", "~ 8.2", ">= 1.22, << 2.0"} {
+		_, err := ParseConstraint(good)
+		assert.NilError(t, err, good)
+	}
+	for _, bad := range []string{"", ">=", "> 1.0", "< 1.0", ">= 1.22,", "=> 1.0", "!= 1.0"} {
+		_, err := ParseConstraint(bad)
+		assert.Check(t, err != nil, "expected error for %q", bad)
+	}
+}
+
+// TestConstraintMatch tests matching versions with constraints
+func TestConstraintMatch(t *testing.T) {
+	tests := []struct {
+		constraint, version string
+		want                bool
+	}{
+		{constraint: "8.2", version: "8.2.7-1~deb12u1", want: true},
+		{constraint: "8.2", version: "8.21", want: false},
+		// pre-releases sort below the series, 8.2~rc1 << 8.2
+		{constraint: "8.2", version: "8.2~rc1", want: false},
+		{constraint: "~ 8.2", version: "8.2~rc1", want: false},
+		{constraint: "1.22.1-9", version: "1.22.1-9+deb12u1", want: false},
+		{constraint: "= 1.22.1-9", version: "1.22.1-9", want: true},
+		{constraint: "~ 9.18", version: "1:9.18.19-1~deb12u1", want: true},
+		{constraint: "~ 1:9.18", version: "9.18.19-1", want: false},
+		{constraint: ">= 1.22", version: "1.22~rc1", want: false},
+		{constraint: ">= 1.22", version: "1:1.0", want: true},
+		{constraint: ">> 1.22", version: "1.22", want: false},
+		{constraint: "<= 1.22", version: "1.22", want: true},
+		{constraint: ">= 1.22, << 2.0", version: "1.99.9", want: true},
+		{constraint: ">= 1.22, << 2.0", version: "2.0", want: false},
+		{constraint: ">= 1.22", version: "not-a-version", want: false},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		assert.NilError(t, err, tt.constraint)
+		assert.Equal(t, c.Match(tt.version), tt.want, "%s %s", tt.constraint, tt.version)
+	}
+}
@@ -0,0 +1,232 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// contextLines is the number of unchanged lines shown around changes,
+	// same as the default for diff -u
+	contextLines = 3
+	// binarySniffLen is the number of bytes checked for a NUL byte when
+	// detecting binary content, same as git
+	binarySniffLen = 8000
+	// maxLines is the maximum number of lines, old and new combined, that
+	// will be diffed. The edit script uses memory proportional to the number
+	// of lines times the number of differences.
+	maxLines = 10000
+)
+
+// opKind is the kind of operation in an edit script
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// op is one line operation in an edit script
+type op struct {
+	kind opKind
+	// line is the line including the trailing newline, when present
+	line string
+	// a and b are the zero based line indexes in the old and new content
+	a, b int
+}
+
+// IsBinary returns true when b looks like binary content.
+// Content is binary when there is a NUL byte in the first 8000 bytes.
+func IsBinary(b []byte) bool {
+	if len(b) > binarySniffLen {
+		b = b[:binarySniffLen]
+	}
+	return bytes.IndexByte(b, 0) != -1
+}
+
+// Unified returns a unified diff, like diff -u, of old and new content.
+// The name is used in the --- and +++ header lines. An empty string is returned
+// when there are no differences.
+func Unified(name string, old, new []byte) string {
+	if bytes.Equal(old, new) {
+		return ""
+	}
+	if IsBinary(old) || IsBinary(new) {
+		return fmt.Sprintf("Binary files a%s and b%s differ\n", name, name)
+	}
+
+	a, b := splitLines(old), splitLines(new)
+	if len(a)+len(b) > maxLines {
+		return fmt.Sprintf("Files a%s and b%s differ, diff omitted for more than %d lines\n",
+			name, name, maxLines)
+	}
+	ops := editScript(a, b)
+	buf := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(buf, "--- a%s\n+++ b%s\n", name, name)
+	for _, h := range hunks(ops) {
+		writeHunk(buf, ops[h[0]:h[1]])
+	}
+	return buf.String()
+}
+
+// splitLines splits content into lines, keeping line terminators so that a
+// missing newline at end of file is detected as a difference.
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript computes the shortest edit script from a to b using the Myers
+// diff algorithm.
+func editScript(a, b []string) []op {
+	n, m := len(a), len(b)
+	limit := n + m
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	trace := make([][]int, 0)
+
+	for d := 0; d <= limit; d++ {
+		// keep a copy of the furthest reaching paths for backtracking
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the trace of the Myers algorithm from the end to the start
+// and returns the edit script in order.
+func backtrack(a, b []string, trace [][]int, offset int) []op {
+	x, y := len(a), len(b)
+	ops := make([]op, 0, len(a)+len(b))
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, op{kind: opEqual, line: a[x], a: x, b: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, op{kind: opInsert, line: b[prevY], a: x, b: prevY})
+			} else {
+				ops = append(ops, op{kind: opDelete, line: a[prevX], a: prevX, b: y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	// reverse, ops were collected from the end
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// hunks groups changes with their surrounding context lines. Each hunk is
+// a start and end index into ops.
+func hunks(ops []op) [][2]int {
+	result := make([][2]int, 0)
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == opEqual {
+			continue
+		}
+		start := i - contextLines
+		if start < 0 {
+			start = 0
+		}
+		// extend the hunk while changes are close enough to share context
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != opEqual {
+				end = j
+				continue
+			}
+			if j-end > 2*contextLines {
+				break
+			}
+		}
+		end += contextLines + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+		// merge with the previous hunk when they overlap
+		if len(result) > 0 && start <= result[len(result)-1][1] {
+			result[len(result)-1][1] = end
+		} else {
+			result = append(result, [2]int{start, end})
+		}
+		i = end - 1
+	}
+	return result
+}
+
+// writeHunk writes one hunk with its @@ header
+func writeHunk(buf *bytes.Buffer, ops []op) {
+	oldStart, newStart := ops[0].a, ops[0].b
+	oldLen, newLen := 0, 0
+	for _, o := range ops {
+		switch o.kind {
+		case opEqual:
+			oldLen++
+			newLen++
+		case opDelete:
+			oldLen++
+		case opInsert:
+			newLen++
+		}
+	}
+	// line numbers are one based, an empty range refers to the line before it
+	if oldLen > 0 {
+		oldStart++
+	}
+	if newLen > 0 {
+		newStart++
+	}
+	fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLen, newStart, newLen)
+	for _, o := range ops {
+		prefix := " "
+		switch o.kind {
+		case opDelete:
+			prefix = "-"
+		case opInsert:
+			prefix = "+"
+		}
+		buf.WriteString(prefix)
+		buf.WriteString(o.line)
+		if !strings.HasSuffix(o.line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
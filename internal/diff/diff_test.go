@@ -0,0 +1,59 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestUnified tests unified diffs of small files
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{name: "equal", old: "a\nb\n", new: "a\nb\n", want: ""},
+		{name: "create", old: "", new: "a\nb\n",
+			want: "--- a/f\n+++ b/f\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{name: "change one line", old: "a\nb\nc\n", new: "a\nx\nc\n",
+			want: "--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{name: "no newline at end of file", old: "a\n", new: "a",
+			want: "--- a/f\n+++ b/f\n@@ -1,1 +1,1 @@\n-a\n+a\n\\ No newline at end of file\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("/f", []byte(tt.old), []byte(tt.new))
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+// TestUnifiedHunks tests changes far apart are split into separate hunks
+func TestUnifiedHunks(t *testing.T) {
+	lines := make([]string, 0, 20)
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	old := strings.Join(lines, "\n") + "\n"
+	lines[1] = "changed 2"
+	lines[18] = "changed 19"
+	new := strings.Join(lines, "\n") + "\n"
+
+	got := Unified("/f", []byte(old), []byte(new))
+	assert.Equal(t, strings.Count(got, "@@ -"), 2, got)
+	assert.Check(t, strings.Contains(got, "@@ -1,5 +1,5 @@\n"), got)
+	assert.Check(t, strings.Contains(got, "@@ -16,5 +16,5 @@\n"), got)
+	assert.Check(t, strings.Contains(got, "-line 19\n+changed 19\n"), got)
+}
+
+// TestUnifiedBinary tests binary content is not diffed
+func TestUnifiedBinary(t *testing.T) {
+	got := Unified("/f", []byte("a\x00b"), []byte("a\x00c"))
+	assert.Equal(t, got, "Binary files a/f and b/f differ\n")
+	assert.Check(t, IsBinary([]byte{0x7f, 'E', 'L', 'F', 0x00}))
+	assert.Check(t, !IsBinary([]byte("server {\n}\n")))
+}
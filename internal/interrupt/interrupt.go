@@ -0,0 +1,60 @@
+package interrupt
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// DefaultGracePeriod is the time to finish after the first signal, longer
+// than the cleanup of a run so backends are closed and locks released
+const DefaultGracePeriod = 3 * time.Minute
+
+// exitCode is the exit code when exiting without waiting for cleanup
+const exitCode = 130
+
+// exit exits the process, replaced in tests
+var exit = os.Exit
+
+// NotifyContext returns a context cancelled on the first of signals, so runs
+// in flight stop and clean up, like closing backends. The process exits
+// when it has not finished within grace after the first signal, or on a
+// second signal. stop stops handling signals and cancels the context.
+func NotifyContext(parent context.Context, grace time.Duration,
+	signals ...os.Signal) (ctx context.Context, stop context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, signals...)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-ch:
+			log.Printf("received %s, cancelling runs and cleaning up for up to %v, send again to exit now",
+				sig, grace)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-ch:
+			log.Printf("received %s again, exiting without cleanup, use the cleanup command to remove "+
+				"leaked docker containers", sig)
+		case <-time.After(grace):
+			log.Printf("cleanup did not finish within %v, exiting", grace)
+		case <-done:
+			return
+		}
+		exit(exitCode)
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+			cancel()
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package interrupt
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestNotifyContext tests the first signal cancels the context, and the
+// second signal exits without waiting for the grace period
+func TestNotifyContext(t *testing.T) {
+	exited := make(chan int, 1)
+	exit = func(code int) {
+		exited <- code
+	}
+	defer func() {
+		exit = os.Exit
+	}()
+
+	ctx, stop := NotifyContext(context.Background(), time.Hour, syscall.SIGUSR1)
+	defer stop()
+	assert.NilError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled on first signal")
+	}
+	assert.Equal(t, len(exited), 0)
+
+	assert.NilError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	select {
+	case code := <-exited:
+		assert.Equal(t, code, exitCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no exit on second signal")
+	}
+}
+
+// TestNotifyContextGracePeriod tests the process exits when it does not
+// finish within the grace period
+func TestNotifyContextGracePeriod(t *testing.T) {
+	exited := make(chan int, 1)
+	exit = func(code int) {
+		exited <- code
+	}
+	defer func() {
+		exit = os.Exit
+	}()
+
+	_, stop := NotifyContext(context.Background(), 10*time.Millisecond, syscall.SIGUSR2)
+	defer stop()
+	assert.NilError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+	select {
+	case code := <-exited:
+		assert.Equal(t, code, exitCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no exit after grace period")
+	}
+}
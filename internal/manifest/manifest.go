@@ -3,12 +3,17 @@ package manifest
 import (
 	"io"
 	"os"
+	"path"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
 	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
+
+	"slack-reconcile-deployments/internal/debversion"
 )
 
 // ProviderBackend is a backend where ssh will be running.
@@ -25,6 +30,51 @@ const (
 	ProviderBackendLinode = ProviderBackend("linode")
 )
 
+// PackageManager is a package manager used to manage packages on a host
+type PackageManager string
+
+const (
+	// PackageManagerApt is apt and dpkg, used by debian and ubuntu
+	PackageManagerApt = PackageManager("apt")
+	// PackageManagerDnf is dnf and rpm, used by fedora, rocky and rhel 8 and later
+	PackageManagerDnf = PackageManager("dnf")
+	// PackageManagerYum is yum and rpm, used by centos and rhel 7
+	PackageManagerYum = PackageManager("yum")
+	// PackageManagerApk is apk, used by alpine
+	PackageManagerApk = PackageManager("apk")
+	// PackageManagerPacman is pacman, used by arch
+	PackageManagerPacman = PackageManager("pacman")
+)
+
+// ServiceManager is an init system used to manage services on a host
+type ServiceManager string
+
+const (
+	// ServiceManagerSystemd is systemd, managed with systemctl
+	ServiceManagerSystemd = ServiceManager("systemd")
+	// ServiceManagerSysvinit is sysvinit, managed with init scripts and the service command
+	ServiceManagerSysvinit = ServiceManager("sysvinit")
+	// ServiceManagerOpenRC is OpenRC, managed with rc-service and rc-update
+	ServiceManagerOpenRC = ServiceManager("openrc")
+)
+
+// EscalationMethod is how commands are run as root on a host, see ssh.EscalationMethod
+type EscalationMethod string
+
+const (
+	// EscalationNone runs commands as the ssh user, for root
+	EscalationNone = EscalationMethod("none")
+	// EscalationSudo runs commands with sudo
+	EscalationSudo = EscalationMethod("sudo")
+	// EscalationDoas runs commands with doas
+	EscalationDoas = EscalationMethod("doas")
+	// EscalationSu runs commands with su
+	EscalationSu = EscalationMethod("su")
+)
+
+// DefaultBackupRetention is the number of runs with backups kept on a host
+const DefaultBackupRetention = 5
+
 // Manifest is a manifest describing desired state of deployment A manifest can
 // be compared to a providers inventory to perform a reconcile operation.
 //
@@ -35,6 +85,22 @@ type Manifest struct {
 	ID string `yaml:"id"`
 	// Provider is a backend to use. The docker provider backend is used for testing.
 	Provider ProviderBackend `yaml:"provider"`
+	// Group is the deployment group of the host, like db, app or lb. A
+	// pipeline orders groups into stages.
+	Group string `yaml:"group,omitempty"`
+	// PackageManager overrides the package manager detected from /etc/os-release
+	PackageManager PackageManager `yaml:"package_manager,omitempty"`
+	// ServiceManager overrides the service manager detected on the host
+	ServiceManager ServiceManager `yaml:"service_manager,omitempty"`
+	// Escalation is how commands are run as root, none for root and sudo
+	// otherwise when not set. The password is never in the manifest.
+	Escalation EscalationMethod `yaml:"escalation,omitempty"`
+	// HealthChecks are commands run on the host after reconcile, a host is
+	// healthy when all of them exit 0
+	HealthChecks []HealthCheck `yaml:"health_checks,omitempty"`
+	// BackupRetention is the number of runs with backups of overwritten files
+	// kept on the host, DefaultBackupRetention when not set
+	BackupRetention int `yaml:"backup_retention,omitempty"`
 	// Packages are the desired packages to be on the target
 	Packages []Package `yaml:"-"`
 	// Parameters is a map of parameters to be used when creating this host.
@@ -55,22 +121,103 @@ const (
 	PackageKindBinary = "binary"
 )
 
+// PackageState is the desired state of a package on a host
+type PackageState string
+
+const (
+	// PackageStatePresent the package is installed, any version satisfying the
+	// version constraint is kept
+	PackageStatePresent = PackageState("present")
+	// PackageStateLatest the package is installed and upgraded to the candidate version
+	PackageStateLatest = PackageState("latest")
+	// PackageStateAbsent the package is removed, configuration is kept
+	PackageStateAbsent = PackageState("absent")
+	// PackageStatePurged the package and its configuration are removed
+	PackageStatePurged = PackageState("purged")
+	// PackageStateHeld the package is installed and held with apt-mark hold,
+	// so it is not upgraded outside of reconcile
+	PackageStateHeld = PackageState("held")
+)
+
+// VersionLatest is the package version to install the candidate version, the
+// version apt-get install would choose.
+const VersionLatest = "latest"
+
 // Package is a package to be installed on a host during reconcile
 type Package struct {
 	// Name is the name of the package
 	Name string `yaml:"name"`
-	// Version is the package version, very simple, no version >= support etc.
+	// Version is latest, or a debian version constraint like 8.2, >= 1.22,
+	// ~ 8.2 or a range like >= 1.22, << 2.0. Versions are compared like dpkg.
 	Version string `yaml:"version"`
 	// Kind is either binary or service
 	Kind PackageKind `yaml:"kind"`
+	// State is the desired state, see DesiredState for the default
+	State PackageState `yaml:"state,omitempty"`
 	// Status is "installed" or "Not-installed" to be used only at runtime
 	Status string `yaml:"-"`
 	// Files are files to transfer to the target  host
 	Files []File `yaml:"files"`
+	// Services are the services of the package, see ServiceDefinitions for the default
+	Services []Service `yaml:"services,omitempty"`
+	// Handlers are commands files of any package can notify
+	Handlers []Handler `yaml:"handlers,omitempty"`
+	// ConfigDirs are directories of configuration removed when the package is purged
+	ConfigDirs []string `yaml:"config_dirs,omitempty"`
 	// Parameters is a map of parameters to be used when rendering this package.
 	Parameters map[string]string `yaml:"parameters,omitempty"`
 }
 
+// ServiceState is the desired state of a service
+type ServiceState string
+
+const (
+	// ServiceStateRunning the service is running, the default
+	ServiceStateRunning = ServiceState("running")
+	// ServiceStateStopped the service is stopped
+	ServiceStateStopped = ServiceState("stopped")
+)
+
+// ServiceAction is the action to run on a service when files of its package change
+type ServiceAction string
+
+const (
+	// ServiceActionRestart restarts the service, the default
+	ServiceActionRestart = ServiceAction("restart")
+	// ServiceActionReload reloads the service configuration without stopping it
+	ServiceActionReload = ServiceAction("reload")
+	// ServiceActionNone does not run an action when files change
+	ServiceActionNone = ServiceAction("none")
+)
+
+// Service is a service provided by a package
+type Service struct {
+	// Name is the name of the service, which might differ from the package name
+	Name string `yaml:"name"`
+	// State is running or stopped, running when not set
+	State ServiceState `yaml:"state,omitempty"`
+	// Enabled is whether the service starts at boot, not managed when not set
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// OnChange is the action when files of the package change, restart when not set
+	OnChange ServiceAction `yaml:"on_change,omitempty"`
+}
+
+// DesiredState returns the desired state of the service, running when not set
+func (s *Service) DesiredState() ServiceState {
+	if s.State == "" {
+		return ServiceStateRunning
+	}
+	return s.State
+}
+
+// ChangeAction returns the action when files change, restart when not set
+func (s *Service) ChangeAction() ServiceAction {
+	if s.OnChange == "" {
+		return ServiceActionRestart
+	}
+	return s.OnChange
+}
+
 // File is a template that will be rendered and copied to a target host
 type File struct {
 	// Path is the target path on the host
@@ -84,8 +231,125 @@ type File struct {
 	Owner string `yaml:"owner"`
 	// Content is the content of the file to be rendered
 	Content string `yaml:"content"`
+	// Validate is a command to validate the staged file before it replaces
+	// the file at Path, %s is replaced by the path of the staged file.
+	// Example:
+	// 	nginx -t -c %s
+	Validate string `yaml:"validate,omitempty"`
+	// Notify are services or handlers to notify when the content changes,
+	// instead of the on_change action of the services of the package
+	Notify []Notify `yaml:"notify,omitempty"`
+	// Become is the user the file is written as, like www-data, root when
+	// not set
+	Become string `yaml:"become,omitempty"`
+}
+
+// Notify is a notification of a service or a handler when a file changes.
+// Notifications are deduplicated and run once after all files are transferred.
+type Notify struct {
+	// Service is the name of the service to notify
+	Service string `yaml:"service,omitempty"`
+	// Action is restart or reload of the service, restart when not set
+	Action ServiceAction `yaml:"action,omitempty"`
+	// Handler is the name of a handler to run
+	Handler string `yaml:"handler,omitempty"`
+}
+
+// NotifyAction returns the action of the service, restart when not set
+func (n *Notify) NotifyAction() ServiceAction {
+	if n.Action == "" {
+		return ServiceActionRestart
+	}
+	return n.Action
+}
+
+// Handler is a command run on the target host when notified
+type Handler struct {
+	// Name is the name of the handler, unique in the manifest
+	Name string `yaml:"name"`
+	// Command is the shell command to run
+	Command string `yaml:"command"`
+	// Become is the user the command runs as, root when not set
+	Become string `yaml:"become,omitempty"`
+}
+
+// HealthCheck is a command run on the host to check it is healthy
+type HealthCheck struct {
+	// Name is the name of the health check
+	Name string `yaml:"name"`
+	// Command is the shell command to run, healthy when it exits 0
+	Command string `yaml:"command"`
+	// Retries is the number of times the command is retried when unhealthy
+	Retries int `yaml:"retries,omitempty"`
+	// Interval is the duration between retries, parsed by time.ParseDuration, 1s when not set
+	Interval string `yaml:"interval,omitempty"`
+}
+
+// RetryInterval returns the duration between retries of the health check
+func (h *HealthCheck) RetryInterval() time.Duration {
+	interval, err := time.ParseDuration(h.Interval)
+	if err != nil {
+		return time.Second
+	}
+	return interval
+}
+
+// Retention returns the number of runs with backups kept on the host
+func (m *Manifest) Retention() int {
+	if m.BackupRetention == 0 {
+		return DefaultBackupRetention
+	}
+	return m.BackupRetention
+}
+
+// DesiredState returns the desired state of the package. Without a state, a
+// package with version latest is upgraded to latest, otherwise it is present.
+func (p *Package) DesiredState() PackageState {
+	if p.State != "" {
+		return p.State
+	}
+	if p.Version == VersionLatest {
+		return PackageStateLatest
+	}
+	return PackageStatePresent
 }
 
+// Removed returns true when the package is desired to be absent or purged
+func (p *Package) Removed() bool {
+	state := p.DesiredState()
+	return state == PackageStateAbsent || state == PackageStatePurged
+}
+
+// ServiceDefinitions returns the services of the package. A service package
+// without services has one service with the name of the package, running
+// and restarted when files change.
+func (p *Package) ServiceDefinitions() []Service {
+	if len(p.Services) > 0 {
+		return p.Services
+	}
+	if p.Kind == PackageKindService {
+		return []Service{{Name: p.Name}}
+	}
+	return nil
+}
+
+var (
+	// packageNameRE matches names of packages of all package managers
+	packageNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9@._+-]*$`)
+	// fileModeRE matches file modes in octal
+	fileModeRE = regexp.MustCompile(`^[0-7]{3,4}$`)
+	// fileOwnerRE matches user or user:group
+	fileOwnerRE = regexp.MustCompile(`^[A-Za-z0-9_.][A-Za-z0-9_.-]*(:[A-Za-z0-9_.][A-Za-z0-9_.-]*)?$`)
+	// userRE matches names of users
+	userRE = regexp.MustCompile(`^[A-Za-z0-9_.][A-Za-z0-9_.-]*$`)
+	// configDirRE matches absolute paths without whitespace or characters
+	// special to the shell
+	configDirRE = regexp.MustCompile(`^/[A-Za-z0-9_@%+=:,./-]+$`)
+	// serviceNameRE matches names of services and systemd units, like
+	// nginx, php8.2-fpm or getty@tty1.service
+	serviceNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9@._:-]*$`)
+)
+
 // NewFromBytes creates a new manifest from bytes
 // Useful from NewFromFile or in tests with arbitrary manifest bytes.
 func NewFromBytes(host, packages []byte) (*Manifest, error) {
@@ -100,18 +364,105 @@ func NewFromBytes(host, packages []byte) (*Manifest, error) {
 		return nil, errors.Wrap(err, "error unmarshalling bytes for manifest")
 	}
 
+	switch m.PackageManager {
+	case "", PackageManagerApt, PackageManagerDnf, PackageManagerYum, PackageManagerApk, PackageManagerPacman:
+	default:
+		return nil, errors.Errorf("invalid package manager %s", m.PackageManager)
+	}
+	switch m.ServiceManager {
+	case "", ServiceManagerSystemd, ServiceManagerSysvinit, ServiceManagerOpenRC:
+	default:
+		return nil, errors.Errorf("invalid service manager %s", m.ServiceManager)
+	}
+	switch m.Escalation {
+	case "", EscalationNone, EscalationSudo, EscalationDoas, EscalationSu:
+	default:
+		return nil, errors.Errorf("invalid escalation %s", m.Escalation)
+	}
+	for _, h := range m.HealthChecks {
+		if h.Name == "" || h.Command == "" {
+			return nil, errors.Errorf("health check %s must have a name and command", h.Name)
+		}
+		if h.Retries < 0 {
+			return nil, errors.Errorf("invalid retries %d for health check %s", h.Retries, h.Name)
+		}
+		if h.Interval != "" {
+			if _, err := time.ParseDuration(h.Interval); err != nil {
+				return nil, errors.Wrapf(err, "invalid interval for health check %s", h.Name)
+			}
+		}
+	}
+	if m.BackupRetention < 0 {
+		return nil, errors.Errorf("invalid backup retention %d", m.BackupRetention)
+	}
+
 	var pkgs []Package
 	if err := yaml.Unmarshal(packages, &pkgs); err != nil {
 		return nil, errors.Wrap(err, "error unmarshalling bytes for packages")
 	}
 	m.Packages = pkgs
 
-	fileModeRE := regexp.MustCompile(`^[0-7]{3,4}$`)
+	handlers := make(map[string]bool)
+	for _, pkg := range m.Packages {
+		for _, h := range pkg.Handlers {
+			if h.Name == "" || h.Command == "" {
+				return nil, errors.Errorf("handler %s of package %s must have a name and command", h.Name, pkg.Name)
+			}
+			if handlers[h.Name] {
+				return nil, errors.Errorf("duplicate handler %s", h.Name)
+			}
+			if h.Become != "" && !userRE.MatchString(h.Become) {
+				return nil, errors.Errorf("invalid become %q of handler %s", h.Become, h.Name)
+			}
+			handlers[h.Name] = true
+		}
+	}
+
 	// validate metadata about file ownership
 	for _, pkg := range m.Packages {
+		// package names are passed to package managers as arguments, a name
+		// starting with - would be an option
+		if !packageNameRE.MatchString(pkg.Name) {
+			return nil, errors.Errorf("invalid package name %q", pkg.Name)
+		}
+		switch pkg.State {
+		case "", PackageStatePresent, PackageStateAbsent, PackageStatePurged, PackageStateHeld:
+		case PackageStateLatest:
+			if pkg.Version != "" && pkg.Version != VersionLatest {
+				return nil, errors.Errorf("package %s with state latest cannot have version %s",
+					pkg.Name, pkg.Version)
+			}
+		default:
+			return nil, errors.Errorf("invalid state %s for package %s", pkg.State, pkg.Name)
+		}
+		for _, svc := range pkg.Services {
+			if err := validateService(&svc); err != nil {
+				return nil, errors.Wrapf(err, "invalid service for package %s", pkg.Name)
+			}
+		}
+		for _, dir := range pkg.ConfigDirs {
+			// config dirs are removed recursively, a short path like /etc would be disastrous
+			if !configDirRE.MatchString(dir) || path.Clean(dir) != dir || strings.Count(dir, "/") < 2 {
+				return nil, errors.Errorf("invalid config dir %q for package %s, must be an absolute path "+
+					"at least two directories deep, without whitespace or shell metacharacters", dir, pkg.Name)
+			}
+		}
+		if pkg.Version != "" && pkg.Version != VersionLatest {
+			if hasControl(pkg.Version) {
+				return nil, errors.Errorf("invalid version %q for package %s", pkg.Version, pkg.Name)
+			}
+			if _, err := debversion.ParseConstraint(pkg.Version); err != nil {
+				return nil, errors.Wrapf(err, "invalid version for package %s", pkg.Name)
+			}
+		}
 		for _, f := range pkg.Files {
-			if !fileModeRE.MatchString(f.Mode) {
-				return nil, errors.Errorf("invalid file mode %s for file %s", f.Mode, f.Path)
+			if err := validateFile(&f); err != nil {
+				return nil, errors.Wrapf(err, "invalid file for package %s", pkg.Name)
+			}
+			for _, n := range f.Notify {
+				if err := validateNotify(&n, handlers); err != nil {
+					return nil, errors.Wrapf(err, "invalid notify for file %s", f.Path)
+				}
 			}
 		}
 	}
@@ -119,6 +470,83 @@ func NewFromBytes(host, packages []byte) (*Manifest, error) {
 	return &m, nil
 }
 
+// validateFile validates the path, mode, owner, validate command and become
+// user of a file. Paths and owners are passed to commands on the target as arguments,
+// a path must be absolute and an owner cannot start with -, so neither is
+// taken for an option.
+func validateFile(f *File) error {
+	if !path.IsAbs(f.Path) || hasControl(f.Path) {
+		return errors.Errorf("invalid path %q, must be an absolute path", f.Path)
+	}
+	if !fileModeRE.MatchString(f.Mode) {
+		return errors.Errorf("invalid file mode %s for file %s", f.Mode, f.Path)
+	}
+	if f.Owner != "" && !fileOwnerRE.MatchString(f.Owner) {
+		return errors.Errorf("invalid owner %q for file %s, must be user or user:group", f.Owner, f.Path)
+	}
+	if f.Validate != "" && !strings.Contains(f.Validate, "%s") {
+		return errors.Errorf("validate for file %s must contain %%s for the staged file", f.Path)
+	}
+	if f.Become != "" && !userRE.MatchString(f.Become) {
+		return errors.Errorf("invalid become %q for file %s", f.Become, f.Path)
+	}
+	return nil
+}
+
+// hasControl returns true when s has control characters, like a newline or
+// a NUL byte, which cannot be represented in a command on the target
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
+
+// validateService validates the name, state and change action of a service
+func validateService(svc *Service) error {
+	if svc.Name == "" {
+		return errors.New("service name is empty")
+	}
+	if !serviceNameRE.MatchString(svc.Name) {
+		return errors.Errorf("invalid service name %q", svc.Name)
+	}
+	switch svc.State {
+	case "", ServiceStateRunning, ServiceStateStopped:
+	default:
+		return errors.Errorf("invalid state %s for service %s", svc.State, svc.Name)
+	}
+	switch svc.OnChange {
+	case "", ServiceActionRestart, ServiceActionReload, ServiceActionNone:
+	default:
+		return errors.Errorf("invalid on_change %s for service %s", svc.OnChange, svc.Name)
+	}
+	return nil
+}
+
+// validateNotify validates a notify has a service or a known handler
+func validateNotify(n *Notify, handlers map[string]bool) error {
+	switch {
+	case n.Service != "" && n.Handler != "":
+		return errors.Errorf("notify cannot have both service %s and handler %s", n.Service, n.Handler)
+	case n.Service != "":
+		if !serviceNameRE.MatchString(n.Service) {
+			return errors.Errorf("invalid service name %q", n.Service)
+		}
+		switch n.Action {
+		case "", ServiceActionRestart, ServiceActionReload:
+		default:
+			return errors.Errorf("invalid action %s for service %s", n.Action, n.Service)
+		}
+	case n.Handler != "":
+		if n.Action != "" {
+			return errors.Errorf("handler %s cannot have an action", n.Handler)
+		}
+		if !handlers[n.Handler] {
+			return errors.Errorf("unknown handler %s", n.Handler)
+		}
+	default:
+		return errors.New("notify must have a service or handler")
+	}
+	return nil
+}
+
 // NewFromFile reads file then calls NewFromBytes() with bytes from file
 // Convenience function when you know where a host manifest is located.
 func NewFromFile(hosts, packages string) (*Manifest, error) {
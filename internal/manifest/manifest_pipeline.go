@@ -0,0 +1,84 @@
+package manifest
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Pipeline orders the groups of manifests into stages. The hosts of a stage
+// are reconciled only after all stages before it succeeded, like backends
+// converged before the load balancers pointing at them.
+type Pipeline struct {
+	// Stages are the stages in the order they are reconciled
+	Stages []Stage `yaml:"stages"`
+}
+
+// Stage is a stage of a pipeline, the hosts of the manifests in a group
+type Stage struct {
+	// Group is the group of the manifests reconciled in the stage
+	Group string `yaml:"group"`
+	// Concurrency is the number of hosts of the stage reconciled
+	// concurrently, the concurrency flag when not set
+	Concurrency int `yaml:"concurrency,omitempty"`
+}
+
+// NewPipelineFromBytes creates a new pipeline from bytes
+func NewPipelineFromBytes(b []byte) (*Pipeline, error) {
+	var p Pipeline
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling bytes for pipeline")
+	}
+	if len(p.Stages) == 0 {
+		return nil, errors.New("pipeline must have at least one stage")
+	}
+	groups := make(map[string]bool)
+	for _, s := range p.Stages {
+		if s.Group == "" {
+			return nil, errors.New("stage must have a group")
+		}
+		if groups[s.Group] {
+			return nil, errors.Errorf("duplicate stage for group %s", s.Group)
+		}
+		groups[s.Group] = true
+		if s.Concurrency < 0 {
+			return nil, errors.Errorf("invalid concurrency %d for stage %s", s.Concurrency, s.Group)
+		}
+	}
+	return &p, nil
+}
+
+// NewPipelineFromFile reads file then calls NewPipelineFromBytes() with bytes from file
+func NewPipelineFromFile(path string) (*Pipeline, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading pipeline %s", path)
+	}
+	return NewPipelineFromBytes(b)
+}
+
+// Assign assigns manifests to the stages of the pipeline by group, the
+// indexes of the manifests of each stage are returned in stage order. Every
+// manifest must be in a group of the pipeline, so no host is left out.
+func (p *Pipeline) Assign(manifests []*Manifest) ([][]int, error) {
+	stages := make(map[string]int)
+	for i, s := range p.Stages {
+		stages[s.Group] = i
+	}
+	assigned := make([][]int, len(p.Stages))
+	for i := range assigned {
+		assigned[i] = []int{}
+	}
+	for i, m := range manifests {
+		if m.Group == "" {
+			return nil, errors.Errorf("manifest %s has no group, a pipeline requires a group", m.ID)
+		}
+		s, ok := stages[m.Group]
+		if !ok {
+			return nil, errors.Errorf("group %s of manifest %s is not a stage of the pipeline", m.Group, m.ID)
+		}
+		assigned[s] = append(assigned[s], i)
+	}
+	return assigned, nil
+}
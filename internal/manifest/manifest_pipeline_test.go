@@ -0,0 +1,44 @@
+package manifest
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestBadPipeline(t *testing.T) {
+	tests := []struct {
+		pipeline string
+		want     string
+	}{
+		{pipeline: "stages: []\n", want: "pipeline must have at least one stage"},
+		{pipeline: "stages:\n  - concurrency: 1\n", want: "stage must have a group"},
+		{pipeline: "stages:\n  - group: app\n  - group: app\n", want: "duplicate stage for group app"},
+		{pipeline: "stages:\n  - group: app\n    concurrency: -1\n", want: "invalid concurrency -1 for stage app"},
+	}
+	for _, tt := range tests {
+		_, err := NewPipelineFromBytes([]byte(tt.pipeline))
+		assert.ErrorContains(t, err, tt.want)
+	}
+}
+
+func TestPipelineAssign(t *testing.T) {
+	p, err := NewPipelineFromBytes([]byte("stages:\n  - group: php\n    concurrency: 2\n  - group: nginx\n" +
+		"  - group: db\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, p.Stages[0].Concurrency, 2)
+
+	manifests := []*Manifest{
+		{ID: "lb1", Group: "nginx"},
+		{ID: "php1", Group: "php"},
+		{ID: "php2", Group: "php"},
+	}
+	assigned, err := p.Assign(manifests)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, assigned, [][]int{{1, 2}, {0}, {}})
+
+	_, err = p.Assign([]*Manifest{{ID: "cache1", Group: "cache"}})
+	assert.ErrorContains(t, err, "group cache of manifest cache1 is not a stage of the pipeline")
+	_, err = p.Assign([]*Manifest{{ID: "php3"}})
+	assert.ErrorContains(t, err, "manifest php3 has no group")
+}
@@ -3,6 +3,7 @@ package manifest
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/exp/slices"
 
@@ -84,3 +85,216 @@ func TestBadFileMode(t *testing.T) {
 	assert.ErrorContains(t, err, "invalid file mode")
 
 }
+
+func TestBadVersion(t *testing.T) {
+	_, err := NewFromFile("testdata/manifest_docker.yaml",
+		"testdata/packages_bad_versions.yaml")
+	assert.ErrorContains(t, err, "invalid version for package nginx")
+}
+
+func TestBadState(t *testing.T) {
+	_, err := NewFromBytes([]byte("id: test\nprovider: docker\n"),
+		[]byte("- name: nginx\n  state: installed\n"))
+	assert.ErrorContains(t, err, "invalid state installed for package nginx")
+
+	_, err = NewFromBytes([]byte("id: test\nprovider: docker\n"),
+		[]byte("- name: nginx\n  state: latest\n  version: \"1.22\"\n"))
+	assert.ErrorContains(t, err, "cannot have version")
+}
+
+// TestDesiredState tests the default desired state of packages
+func TestDesiredState(t *testing.T) {
+	tests := []struct {
+		pkg     Package
+		want    PackageState
+		removed bool
+	}{
+		{pkg: Package{Name: "nginx"}, want: PackageStatePresent},
+		{pkg: Package{Name: "nginx", Version: "latest"}, want: PackageStateLatest},
+		{pkg: Package{Name: "nginx", Version: "1.22"}, want: PackageStatePresent},
+		{pkg: Package{Name: "nginx", Version: "1.22", State: PackageStateHeld}, want: PackageStateHeld},
+		{pkg: Package{Name: "nginx", State: PackageStateAbsent}, want: PackageStateAbsent, removed: true},
+		{pkg: Package{Name: "nginx", State: PackageStatePurged}, want: PackageStatePurged, removed: true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.pkg.DesiredState(), tt.want)
+		assert.Equal(t, tt.pkg.Removed(), tt.removed)
+	}
+}
+
+func TestBadPackageManager(t *testing.T) {
+	_, err := NewFromBytes([]byte("id: test\nprovider: docker\npackage_manager: zypper\n"),
+		[]byte("- name: nginx\n"))
+	assert.ErrorContains(t, err, "invalid package manager zypper")
+}
+
+func TestBadServiceManager(t *testing.T) {
+	_, err := NewFromBytes([]byte("id: test\nprovider: docker\nservice_manager: upstart\n"),
+		[]byte("- name: nginx\n"))
+	assert.ErrorContains(t, err, "invalid service manager upstart")
+}
+
+func TestBadService(t *testing.T) {
+	tests := []struct {
+		packages string
+		want     string
+	}{
+		{packages: "- name: nginx\n  services:\n    - state: running\n", want: "service name is empty"},
+		{packages: "- name: nginx\n  services:\n    - name: nginx\n      state: up\n", want: "invalid state up"},
+		{packages: "- name: nginx\n  services:\n    - name: nginx\n      on_change: kill\n", want: "invalid on_change kill"},
+		{packages: "- name: nginx\n  services:\n    - name: 'nginx; touch /pwned'\n",
+			want: `invalid service name "nginx; touch /pwned"`},
+		{packages: "- name: nginx\n  services:\n    - name: x$(id)\n", want: `invalid service name "x$(id)"`},
+		{packages: "- name: nginx\n  services:\n    - name: -nginx\n", want: `invalid service name "-nginx"`},
+	}
+	for _, tt := range tests {
+		_, err := NewFromBytes([]byte("id: test\nprovider: docker\n"), []byte(tt.packages))
+		assert.ErrorContains(t, err, tt.want)
+	}
+}
+
+// TestServiceDefinitions tests the default services of packages
+func TestServiceDefinitions(t *testing.T) {
+	m, err := NewFromBytes([]byte("id: test\nprovider: docker\n"), []byte(`
+- name: nginx
+  kind: service
+- name: curl
+- name: postgresql-15
+  kind: service
+  services:
+    - name: postgresql
+      state: stopped
+      enabled: false
+      on_change: reload
+`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, m.Packages[0].ServiceDefinitions(), []Service{{Name: "nginx"}})
+	assert.Equal(t, len(m.Packages[1].ServiceDefinitions()), 0)
+
+	svcs := m.Packages[2].ServiceDefinitions()
+	assert.Equal(t, len(svcs), 1)
+	assert.Equal(t, svcs[0].Name, "postgresql")
+	assert.Equal(t, svcs[0].DesiredState(), ServiceStateStopped)
+	assert.Equal(t, svcs[0].ChangeAction(), ServiceActionReload)
+	assert.Assert(t, svcs[0].Enabled != nil && !*svcs[0].Enabled)
+
+	svc := Service{Name: "nginx"}
+	assert.Equal(t, svc.DesiredState(), ServiceStateRunning)
+	assert.Equal(t, svc.ChangeAction(), ServiceActionRestart)
+}
+
+func TestBadNotify(t *testing.T) {
+	file := "- name: nginx\n  files:\n    - path: /a\n      mode: \"0644\"\n      notify:\n"
+	tests := []struct {
+		packages string
+		want     string
+	}{
+		{packages: file + "        - action: reload\n",
+			want: "notify must have a service or handler"},
+		{packages: file + "        - service: nginx\n          action: stop\n",
+			want: "invalid action stop for service nginx"},
+		{packages: file + "        - service: x$(id)\n",
+			want: `invalid service name "x$(id)"`},
+		{packages: file + "        - handler: test\n",
+			want: "unknown handler test"},
+		{packages: "- name: nginx\n  handlers:\n    - name: test\n      command: nginx -t\n" +
+			"- name: php\n  handlers:\n    - name: test\n      command: php -v\n", want: "duplicate handler test"},
+	}
+	for _, tt := range tests {
+		_, err := NewFromBytes([]byte("id: test\nprovider: docker\n"), []byte(tt.packages))
+		assert.ErrorContains(t, err, tt.want)
+	}
+}
+
+func TestBadValidate(t *testing.T) {
+	_, err := NewFromBytes([]byte("id: test\nprovider: docker\n"),
+		[]byte("- name: nginx\n  files:\n    - path: /a\n      mode: \"0644\"\n      validate: nginx -t\n"))
+	assert.ErrorContains(t, err, "validate for file /a must contain %s")
+}
+
+func TestBadArguments(t *testing.T) {
+	tests := []struct {
+		packages string
+		want     string
+	}{
+		{packages: "- name: -oAPT::Update::Pre-Invoke::=id\n", want: "invalid package name"},
+		{packages: "- name: nginx; id\n", want: "invalid package name"},
+		{packages: "- name: nginx\n  version: \"1.24\\n\"\n", want: "invalid version"},
+		{packages: "- name: nginx\n  files:\n    - path: etc/nginx.conf\n      mode: \"0644\"\n",
+			want: "invalid path"},
+		{packages: "- name: nginx\n  files:\n    - path: \"/etc/a\\nb\"\n      mode: \"0644\"\n",
+			want: "invalid path"},
+		{packages: "- name: nginx\n  files:\n    - path: /a\n      mode: \"0644\"\n      owner: -R\n",
+			want: "invalid owner"},
+		{packages: "- name: nginx\n  files:\n    - path: /a\n      mode: \"0644\"\n      owner: root;id\n",
+			want: "invalid owner"},
+		{packages: "- name: nginx\n  files:\n    - path: /a\n      mode: \"0644\"\n      become: -u\n",
+			want: "invalid become"},
+		{packages: "- name: nginx\n  handlers:\n    - name: test\n      command: id\n      become: a b\n",
+			want: "invalid become"},
+	}
+	for _, tt := range tests {
+		_, err := NewFromBytes([]byte("id: test\nprovider: docker\n"), []byte(tt.packages))
+		assert.ErrorContains(t, err, tt.want, tt.packages)
+	}
+
+	_, err := NewFromBytes([]byte("id: test\nprovider: docker\nescalation: pkexec\n"), []byte("- name: nginx\n"))
+	assert.ErrorContains(t, err, "invalid escalation pkexec")
+
+	m, err := NewFromBytes([]byte("id: test\nprovider: docker\nescalation: su\n"), []byte("- name: nginx\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, m.Escalation, EscalationSu)
+
+	_, err = NewFromBytes([]byte("id: test\nprovider: docker\n"),
+		[]byte("- name: libstdc++6\n  files:\n    - path: /etc/my app.conf\n      mode: \"0644\"\n"+
+			"      owner: www-data:adm\n      become: www-data\n"))
+	assert.NilError(t, err)
+}
+
+func TestBackupRetention(t *testing.T) {
+	m, err := NewFromBytes([]byte("id: test\nprovider: docker\n"), []byte("- name: nginx\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, m.Retention(), DefaultBackupRetention)
+
+	m, err = NewFromBytes([]byte("id: test\nprovider: docker\nbackup_retention: 2\n"), []byte("- name: nginx\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, m.Retention(), 2)
+
+	_, err = NewFromBytes([]byte("id: test\nprovider: docker\nbackup_retention: -1\n"), []byte("- name: nginx\n"))
+	assert.ErrorContains(t, err, "invalid backup retention -1")
+}
+
+func TestBadConfigDir(t *testing.T) {
+	for _, dir := range []string{"/etc", "/", "etc/nginx", "/etc/nginx/../", "/etc/nginx;touch /tmp/pwned",
+		"/etc/nginx /etc/ssh", "/etc/$(id)", "/etc/nginx*"} {
+		_, err := NewFromBytes([]byte("id: test\nprovider: docker\n"),
+			[]byte("- name: nginx\n  config_dirs:\n    - '"+dir+"'\n"))
+		assert.ErrorContains(t, err, "invalid config dir", dir)
+	}
+	_, err := NewFromBytes([]byte("id: test\nprovider: docker\n"),
+		[]byte("- name: nginx\n  config_dirs:\n    - /etc/nginx\n"))
+	assert.NilError(t, err)
+}
+
+func TestBadHealthCheck(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "health_checks:\n  - name: http\n", want: "health check http must have a name and command"},
+		{host: "health_checks:\n  - name: http\n    command: curl -f localhost\n    retries: -1\n",
+			want: "invalid retries -1 for health check http"},
+		{host: "health_checks:\n  - name: http\n    command: curl -f localhost\n    interval: soon\n",
+			want: "invalid interval for health check http"},
+	}
+	for _, tt := range tests {
+		_, err := NewFromBytes([]byte("id: test\nprovider: docker\n"+tt.host), []byte("- name: nginx\n"))
+		assert.ErrorContains(t, err, tt.want)
+	}
+
+	m, err := NewFromBytes([]byte("id: test\nprovider: docker\nhealth_checks:\n  - name: http\n"+
+		"    command: curl -f localhost\n    interval: 5s\n"), []byte("- name: nginx\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, m.HealthChecks[0].RetryInterval(), 5*time.Second)
+	assert.Equal(t, (&HealthCheck{}).RetryInterval(), time.Second)
+}
@@ -0,0 +1,22 @@
+package process
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Running returns true when a process with pid is running on this host
+func Running(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// signal 0 checks the process exists, permission denied means it exists
+	// and is owned by another user
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -33,6 +33,19 @@ type ProviderBackend struct {
 	privateKey []byte
 }
 
+// labels of containers created by the docker backend, used by Cleanup to
+// find containers leaked by runs that did not close the backend
+const (
+	// LabelManaged marks containers created by the docker backend
+	LabelManaged = "reconcile-deployments.managed"
+	// LabelManifestID is the id of the manifest of the container
+	LabelManifestID = "reconcile-deployments.manifest-id"
+	// LabelHostname is the host running reconcile that created the container
+	LabelHostname = "reconcile-deployments.hostname"
+	// LabelPID is the process id of reconcile that created the container
+	LabelPID = "reconcile-deployments.pid"
+)
+
 // verify backend implements interface for backends
 var _ backend.ProviderBackendReconciler = &ProviderBackend{}
 
@@ -61,7 +74,7 @@ func (p *ProviderBackend) Close() {
 }
 
 // Run runs localstack in background for provider
-func (p *ProviderBackend) Run(_ context.Context) (*ssh.Client, error) {
+func (p *ProviderBackend) Run(ctx context.Context) (*ssh.Client, error) {
 	p.log.Infof("run")
 
 	pool, err := dockertest.NewPool("")
@@ -83,11 +96,18 @@ func (p *ProviderBackend) Run(_ context.Context) (*ssh.Client, error) {
 		 openssh-client openssh-server && mkdir /run/sshd && /usr/sbin/sshd -D -e -o \
          IgnoreUserKnownHosts=yes -o PermitEmptyPasswords=yes -o PermitRootLogin=yes`}
 
+	hostname, _ := os.Hostname()
 	container, err := pool.RunWithOptions(&dockertest.RunOptions{
 		Repository:   "debian",
 		Tag:          "12.2",
 		Cmd:          cmd,
 		ExposedPorts: []string{"22/tcp"},
+		Labels: map[string]string{
+			LabelManaged:    "true",
+			LabelManifestID: p.manifest.ID,
+			LabelHostname:   hostname,
+			LabelPID:        strconv.Itoa(os.Getpid()),
+		},
 		PortBindings: map[dc.Port][]dc.PortBinding{
 			"22/tcp": {{HostPort: strconv.Itoa(sshPort)}},
 		},
@@ -101,6 +121,10 @@ func (p *ProviderBackend) Run(_ context.Context) (*ssh.Client, error) {
 
 	p.log.Infof("%s address %s", container.Container.ID, container.GetBoundIP(""))
 	p.container = container
+	// the container is removed by Close, also when the run was cancelled
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "run cancelled after starting container")
+	}
 
 	privateKey, publicKey, err := pkc.GenerateKeyPair()
 	if err != nil {
@@ -128,7 +152,7 @@ func (p *ProviderBackend) Run(_ context.Context) (*ssh.Client, error) {
 			p.container.Container.ID, exit)
 	}
 
-	client, err := ssh.New(p.log, true, privateKey,
+	client, err := ssh.New(ctx, p.log, true, privateKey,
 		p.Username(), "", fmt.Sprintf("127.0.0.1:%d", sshPort))
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating ssh client")
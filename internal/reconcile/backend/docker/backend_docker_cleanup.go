@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/ory/dockertest/v3"
+	dc "github.com/ory/dockertest/v3/docker"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"slack-reconcile-deployments/internal/process"
+)
+
+// Cleanup removes containers leaked by runs that did not close the docker
+// backend, like runs killed before cleaning up. The ids of removed containers
+// are returned. Containers of runs still running are kept unless all is set.
+func Cleanup(log *zap.SugaredLogger, all bool) ([]string, error) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return nil, errors.Wrap(err, "error on new dockertest pool")
+	}
+	containers, err := pool.Client.ListContainers(dc.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {LabelManaged + "=true"}},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing containers")
+	}
+	hostname, _ := os.Hostname()
+	removed := make([]string, 0)
+	for _, c := range containers {
+		if !all && !leaked(c.Labels, hostname) {
+			log.Infof("keeping container %s of manifest %s, its run is still running or on another host",
+				c.ID, c.Labels[LabelManifestID])
+			continue
+		}
+		if err := pool.Client.RemoveContainer(dc.RemoveContainerOptions{
+			ID:            c.ID,
+			Force:         true,
+			RemoveVolumes: true,
+		}); err != nil {
+			return removed, errors.Wrapf(err, "error removing container %s", c.ID)
+		}
+		log.Infof("removed container %s of manifest %s", c.ID, c.Labels[LabelManifestID])
+		removed = append(removed, c.ID)
+	}
+	return removed, nil
+}
+
+// leaked returns true when the container with labels was created on this
+// host by a process that is no longer running. Containers created on other
+// hosts cannot be checked, they are not leaked.
+func leaked(labels map[string]string, hostname string) bool {
+	if labels[LabelHostname] != hostname {
+		return false
+	}
+	pid, err := strconv.Atoi(labels[LabelPID])
+	if err != nil {
+		return true
+	}
+	return !process.Running(pid)
+}
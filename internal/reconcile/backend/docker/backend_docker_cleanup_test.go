@@ -0,0 +1,28 @@
+package docker
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestLeaked(t *testing.T) {
+	hostname, err := os.Hostname()
+	assert.NilError(t, err)
+	tests := []struct {
+		name   string
+		labels map[string]string
+		leaked bool
+	}{
+		{name: "running", labels: map[string]string{LabelHostname: hostname, LabelPID: strconv.Itoa(os.Getpid())}},
+		{name: "exited", labels: map[string]string{LabelHostname: hostname, LabelPID: strconv.Itoa(1 << 30)},
+			leaked: true},
+		{name: "no pid", labels: map[string]string{LabelHostname: hostname}, leaked: true},
+		{name: "other host", labels: map[string]string{LabelHostname: "ci-runner-1", LabelPID: "1"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, leaked(tt.labels, hostname), tt.leaked, tt.name)
+	}
+}
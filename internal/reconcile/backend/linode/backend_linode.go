@@ -99,7 +99,7 @@ func (p *ProviderBackend) Run(ctx context.Context) (*ssh.Client, error) {
 	p.PublicDNSName = publicDNSName
 	p.log.Infof("instance %s, %s, %s exists", instanceID, p.Manifest.ID, publicDNSName)
 
-	p.ssh, err = ssh.New(p.log, true, p.PrivateKey,
+	p.ssh, err = ssh.New(ctx, p.log, true, p.PrivateKey,
 		p.Username(), "", fmt.Sprintf("%s:22", publicDNSName))
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating ssh client")
@@ -107,7 +107,8 @@ func (p *ProviderBackend) Run(ctx context.Context) (*ssh.Client, error) {
 	return p.ssh, nil
 }
 
-// WaitForRunning waits until an instance exists and is in running state
+// WaitForRunning waits until an instance exists and is in running state,
+// polling stops when ctx is done
 func (p *ProviderBackend) WaitForRunning(ctx context.Context, instanceID int) error {
 	if err := backoff.Retry(func() error {
 		out, err := p.Client.GetInstance(ctx,
@@ -127,22 +128,21 @@ func (p *ProviderBackend) WaitForRunning(ctx context.Context, instanceID int) er
 			return nil
 		}
 		return ErrNoInstanceFound
-	}, backoff.WithMaxRetries(backoff.NewConstantBackOff(10*time.Second), 15)); err != nil {
+	}, backoff.WithContext(backoff.WithMaxRetries(backoff.NewConstantBackOff(10*time.Second), 15), ctx)); err != nil {
 		return err
 	}
 	p.log.Infof("instance is running %s, %s, %s", instanceID, p.PublicDNSName, p.Manifest.ID)
 	return nil
 }
 
-// Exists checks for an instance with given name
-// TODO: add context timeout/stop
-func (p *ProviderBackend) Exists(_ context.Context, name string) (bool, string, string, error) {
+// Exists checks for an instance with given name, retries stop when ctx is done
+func (p *ProviderBackend) Exists(ctx context.Context, name string) (bool, string, string, error) {
 	exists := false
 	instanceID := ""
 	publicDNSName := ""
 
 	if err := backoff.Retry(func() error {
-		out, err := p.Client.ListInstances(context.Background(),
+		out, err := p.Client.ListInstances(ctx,
 			linodego.NewListOptions(0, fmt.Sprintf(`{"id": %s}`, name)))
 		fmt.Printf("%v", out)
 		if err != nil {
@@ -167,7 +167,7 @@ func (p *ProviderBackend) Exists(_ context.Context, name string) (bool, string,
 
 		}
 		return ErrNoInstanceFound
-	}, backoff.WithMaxRetries(backoff.NewConstantBackOff(5*time.Second), 2)); err != nil {
+	}, backoff.WithContext(backoff.WithMaxRetries(backoff.NewConstantBackOff(5*time.Second), 2), ctx)); err != nil {
 		return false, "", "", err
 	}
 	return exists, instanceID, publicDNSName, nil
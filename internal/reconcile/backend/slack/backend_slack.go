@@ -40,10 +40,10 @@ func New(log *zap.SugaredLogger, manifest *manifest.Manifest) backend.ProviderBa
 }
 
 // Run reconciles backend state with desired state
-func (p *ProviderBackend) Run(_ context.Context) (*ssh.Client, error) {
+func (p *ProviderBackend) Run(ctx context.Context) (*ssh.Client, error) {
 	host := p.Manifest.Parameters["hostname"]
 	var err error
-	p.ssh, err = ssh.New(p.log, true, []byte{},
+	p.ssh, err = ssh.New(ctx, p.log, true, []byte{},
 		p.Username(), p.password, fmt.Sprintf("%s:22", host))
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating ssh client")
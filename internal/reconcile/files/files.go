@@ -27,6 +27,8 @@ type FileManager struct {
 	ssh *ssh.Client
 	// scp lazily created when ssh client is provided
 	scp *ssh.SecureCopyClient
+	// runID is the id of the run backups are saved under, no backups when empty
+	runID string
 }
 
 // New creates a new files object to manage files on remote systems.
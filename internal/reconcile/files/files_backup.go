@@ -0,0 +1,150 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+const (
+	// BackupDir is the directory on the target with backups of overwritten
+	// files, one directory per run id
+	BackupDir = "/var/lib/reconcile-deployments/backups"
+	// backupCreated is the file in a backup listing files created by the run,
+	// which did not exist before the run
+	backupCreated = ".created"
+)
+
+// WithBackup enables backups of files overwritten by Transfer under the run id
+func (fm *FileManager) WithBackup(runID string) *FileManager {
+	fm.runID = runID
+	return fm
+}
+
+// Backup saves the content of a file on the target before it is overwritten,
+// under the directory of the run. A file that does not exist, stat is nil, is
+// recorded as created so rollback removes it.
+func (fm *FileManager) Backup(f *manifest.File, stat *Stat) error {
+	if fm.runID == "" {
+		return nil
+	}
+	dir := path.Join(BackupDir, fm.runID)
+	if stat == nil {
+		out, err := fm.ssh.Exec(ssh.Script(`mkdir -p "$1" && echo "$2" >> "$3"`,
+			dir, f.Path, path.Join(dir, backupCreated)))
+		if err != nil {
+			return errors.Wrapf(err, "error recording created file %s in backup %s, out: %s", f.Path, dir, out)
+		}
+		return nil
+	}
+	backup := path.Join(dir, f.Path)
+	out, err := fm.ssh.Exec(ssh.Script(`mkdir -p "$1" && cp -p "$2" "$3"`, path.Dir(backup), f.Path, backup))
+	if err != nil {
+		return errors.Wrapf(err, "error backing up %s to %s, out: %s", f.Path, backup, out)
+	}
+	fm.log.Infof("backed up %s to %s", f.Path, backup)
+	return nil
+}
+
+// PruneBackups removes the backups of the oldest runs, keeping the backups
+// of retention runs
+func (fm *FileManager) PruneBackups(retention int) error {
+	out, err := fm.ssh.Exec(ssh.Script(`mkdir -p "$1" && ls -1 "$1"`, BackupDir))
+	if err != nil {
+		return errors.Wrapf(err, "error listing backups in %s", BackupDir)
+	}
+	for _, runID := range pruneRuns(parseLines(out), retention) {
+		dir := path.Join(BackupDir, runID)
+		if _, err := fm.ssh.Exec(ssh.Cmd("rm", "-rf", dir)); err != nil {
+			return errors.Wrapf(err, "error removing backup %s", dir)
+		}
+		fm.log.Infof("removed backup %s", dir)
+	}
+	return nil
+}
+
+// Rollback restores the files backed up by a run and removes the files the
+// run created. The files are backed up first under the run id of the file
+// manager, so a rollback can be rolled back. The paths of restored and
+// removed files are returned.
+func (fm *FileManager) Rollback(runID string) ([]string, error) {
+	dir := path.Join(BackupDir, runID)
+	out, err := fm.ssh.Exec(ssh.Script(`test -d "$1" && cd "$1" && find . -type f ! -name "$2"`,
+		dir, backupCreated))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing backup %s, the run has no backups", dir)
+	}
+	restored := make([]string, 0)
+	for _, rel := range parseLines(out) {
+		f := &manifest.File{Path: path.Join("/", rel)}
+		if err := fm.backupCurrent(f); err != nil {
+			return restored, err
+		}
+		backup := path.Join(dir, rel)
+		out, err := fm.ssh.Exec(ssh.Script(`mkdir -p "$1" && cp -p "$2" "$3"`, path.Dir(f.Path), backup, f.Path))
+		if err != nil {
+			return restored, errors.Wrapf(err, "error restoring %s from %s, out: %s", f.Path, backup, out)
+		}
+		fm.log.Infof("restored %s from %s", f.Path, backup)
+		restored = append(restored, f.Path)
+	}
+
+	out, err = fm.ssh.Exec(ssh.Script(`cat "$1" 2>/dev/null || true`, path.Join(dir, backupCreated)))
+	if err != nil {
+		return restored, errors.Wrapf(err, "error reading created files of backup %s", dir)
+	}
+	for _, created := range parseLines(out) {
+		f := &manifest.File{Path: created}
+		if err := fm.backupCurrent(f); err != nil {
+			return restored, err
+		}
+		if _, err := fm.ssh.Exec(ssh.Cmd("rm", "-f", f.Path)); err != nil {
+			return restored, errors.Wrapf(err, "error removing %s created by run %s", f.Path, runID)
+		}
+		fm.log.Infof("removed %s created by run %s", f.Path, runID)
+		restored = append(restored, f.Path)
+	}
+	return restored, nil
+}
+
+// backupCurrent backs up the current content of a file before rollback
+// changes it
+func (fm *FileManager) backupCurrent(f *manifest.File) error {
+	stat, err := fm.Stat(f)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "error stat %s", f.Path)
+	}
+	return fm.Backup(f, stat)
+}
+
+// pruneRuns returns the run ids to remove, the oldest runs over retention.
+// Run ids sort in the order the runs started.
+func pruneRuns(runIDs []string, retention int) []string {
+	if len(runIDs) <= retention {
+		return nil
+	}
+	sorted := append([]string{}, runIDs...)
+	sort.Strings(sorted)
+	return sorted[:len(sorted)-retention]
+}
+
+// parseLines returns the non empty lines of out, with leading ./ removed
+func parseLines(out []byte) []string {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "./")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,57 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+	"slack-reconcile-deployments/internal/testhelpers"
+)
+
+func testFileBackupRollback(t *testing.T, f *testhelpers.DockerTestFixtures) {
+	sshClient, err := ssh.New(context.Background(), f.Log, true, f.PrivateKey,
+		"root", "", fmt.Sprintf("%s:%d", "127.0.0.1", f.SSHPort))
+	assert.NilError(t, err, "ssh client")
+
+	fm := New(f.Log, &manifest.Manifest{}, sshClient).WithBackup("20231016T193725Z-a1b2c3")
+	fm.scp = ssh.NewSecureCopyClient(f.Log, sshClient)
+
+	_, err = sshClient.Execf("echo 'listen 80' > /etc/backup.conf && rm -f /etc/backup-created.conf")
+	assert.NilError(t, err, "create /etc/backup.conf")
+
+	_, err = fm.Transfer(&manifest.File{Path: "/etc/backup.conf"}, bytes.NewReader([]byte("listen 8080\n")))
+	assert.NilError(t, err, "transfer")
+	_, err = fm.Transfer(&manifest.File{Path: "/etc/backup-created.conf"}, bytes.NewReader([]byte("created\n")))
+	assert.NilError(t, err, "transfer created")
+
+	restored, err := New(f.Log, &manifest.Manifest{}, sshClient).WithBackup("20231016T193800Z-d4e5f6").
+		Rollback("20231016T193725Z-a1b2c3")
+	assert.NilError(t, err, "rollback")
+	assert.DeepEqual(t, restored, []string{"/etc/backup.conf", "/etc/backup-created.conf"})
+
+	out, err := sshClient.Execf("cat /etc/backup.conf")
+	assert.NilError(t, err, "cat /etc/backup.conf")
+	assert.Equal(t, string(out), "listen 80\n")
+	_, err = sshClient.Execf("test -f /etc/backup-created.conf")
+	assert.ErrorContains(t, err, "exited with status 1", "created file is removed")
+
+	_, err = fm.Rollback("20000101T000000Z-000000")
+	assert.ErrorContains(t, err, "the run has no backups")
+}
+
+func TestPruneRuns(t *testing.T) {
+	runs := []string{"20231016T193725Z-a1b2c3", "20231014T101010Z-000001", "20231015T101010Z-000002"}
+	assert.DeepEqual(t, pruneRuns(runs, 5), []string(nil))
+	assert.DeepEqual(t, pruneRuns(runs, 3), []string(nil))
+	assert.DeepEqual(t, pruneRuns(runs, 1), []string{"20231014T101010Z-000001", "20231015T101010Z-000002"})
+}
+
+func TestParseLines(t *testing.T) {
+	out := []byte("./etc/nginx/sites-available/default\n\n./var/www/html/info.php\n")
+	assert.DeepEqual(t, parseLines(out), []string{"etc/nginx/sites-available/default", "var/www/html/info.php"})
+}
@@ -0,0 +1,46 @@
+package files
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/diff"
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// maxDiffSize is the maximum size in bytes of the remote or rendered file
+// to diff. Larger files are reported as different without a diff.
+const maxDiffSize = 1 << 20
+
+// Diff returns a unified diff of the file content on the target and the
+// rendered content. stat is the result of Stat() for the file, use nil when
+// the file does not exist on the target. Binary files and files larger than
+// maxDiffSize are reported as different without the content.
+func (fm *FileManager) Diff(f *manifest.File, stat *Stat, rendered []byte) (string, error) {
+	if (stat != nil && stat.Size > maxDiffSize) || len(rendered) > maxDiffSize {
+		return fmt.Sprintf("Files a%s and b%s differ, diff omitted for files larger than %d bytes\n",
+			f.Path, f.Path, maxDiffSize), nil
+	}
+
+	var remote []byte
+	if stat != nil {
+		out, err := fm.Read(f)
+		if err != nil {
+			return "", err
+		}
+		remote = out
+	}
+	return diff.Unified(f.Path, remote, rendered), nil
+}
+
+// Read reads the content of a file on the target
+func (fm *FileManager) Read(f *manifest.File) ([]byte, error) {
+	// use cat instead of sftp so that files only readable with sudo can be read
+	out, err := fm.ssh.Exec(ssh.Cmd("/bin/cat", f.Path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error exec cat %s", f.Path)
+	}
+	return out, nil
+}
@@ -0,0 +1,55 @@
+package files
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+)
+
+// renderSentinel replaces template values that change on every render when
+// matching a rendered template with the content on the target
+const renderSentinel = "\x00reconcile-render-sentinel\x00"
+
+// Modified returns true when remote, the content of the file on the target,
+// does not match the rendered file. Template values that change on every
+// render, like LastModifiedDate, match any value on the same line so that
+// only edits made on the target are detected.
+func (fm *FileManager) Modified(p *manifest.Package, f *manifest.File, data map[string]string,
+	remote []byte) (bool, error) {
+	merged := make(map[string]string, len(data))
+	for k, v := range data {
+		merged[k] = v
+	}
+	merged[FileTemplateKeyLastModifiedDate] = renderSentinel
+
+	reader, err := fm.Render(p, f, merged)
+	if err != nil {
+		return false, errors.Wrapf(err, "error rendering %s", f.Path)
+	}
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return false, errors.Wrapf(err, "error reading file %s", f.Path)
+	}
+	return !MatchRendered(string(b), remote), nil
+}
+
+// MatchRendered returns true when remote matches rendered, where each
+// sentinel in rendered matches any text up to the end of the line.
+func MatchRendered(rendered string, remote []byte) bool {
+	parts := strings.Split(rendered, renderSentinel)
+	if len(parts) == 1 {
+		return rendered == string(remote)
+	}
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	re, err := regexp.Compile(`\A` + strings.Join(parts, `[^\n]*`) + `\z`)
+	if err != nil {
+		return false
+	}
+	return re.Match(remote)
+}
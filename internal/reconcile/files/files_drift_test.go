@@ -0,0 +1,27 @@
+package files
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestMatchRendered tests matching rendered templates with values that
+// change on every render
+func TestMatchRendered(t *testing.T) {
+	rendered := "# generated file " + renderSentinel + "\nserver {\n}\n"
+	tests := []struct {
+		name   string
+		remote string
+		want   bool
+	}{
+		{name: "any date", remote: "# generated file 2023-12-14T19:32:05Z\nserver {\n}\n", want: true},
+		{name: "edited", remote: "# generated file 2023-12-14T19:32:05Z\nserver {\n listen 81;\n}\n", want: false},
+		{name: "sentinel does not span lines", remote: "# generated file x\ny\nserver {\n}\n", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, MatchRendered(rendered, []byte(tt.remote)), tt.want, tt.name)
+	}
+	assert.Check(t, MatchRendered("static\n", []byte("static\n")))
+	assert.Check(t, !MatchRendered("static\n", []byte("static")))
+}
@@ -1,9 +1,9 @@
 package files
 
 import (
-	"fmt"
-
 	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/ssh"
 )
 
 // ApplyPermissions applies permissions to files on remote system over ssh
@@ -12,13 +12,22 @@ func (fm *FileManager) ApplyPermissions() error {
 		return errors.New("error: ssh client not initialized")
 	}
 	for _, pkgs := range fm.manifest.Packages {
+		if pkgs.Removed() {
+			// files of removed packages are removed, no permissions to apply
+			continue
+		}
 		for _, f := range pkgs.Files {
 			if f.Path == "" {
 				fm.log.Infof("skipping empty filepath on file: %+v", f)
 				continue
 			}
+			// files written as a user are changed as the user, who owns them
+			client := fm.ssh
+			if f.Become != "" {
+				client = fm.ssh.AsUser(f.Become)
+			}
 			if f.Mode != "" {
-				out, err := fm.ssh.Execf(fmt.Sprintf("chmod %s %s", f.Mode, f.Path))
+				out, err := client.Exec(ssh.Cmd("chmod", f.Mode, f.Path))
 				if err != nil {
 					return errors.Wrapf(err, "error exec chmod %s", f.Path)
 				}
@@ -26,13 +35,27 @@ func (fm *FileManager) ApplyPermissions() error {
 			}
 
 			if f.Owner != "" {
-				out, err := fm.ssh.Execf(fmt.Sprintf("chown %s %s", f.Owner, f.Path))
+				out, err := client.Exec(ssh.Cmd("chown", f.Owner, f.Path))
 				if err != nil {
 					return errors.Wrapf(err, "error exec chown %s", f.Path)
 				}
-				fm.log.Infof("chown %s %s, out: '%s'", f.Mode, f.Path, out)
+				fm.log.Infof("chown %s %s, out: '%s'", f.Owner, f.Path, out)
 			}
 		}
 	}
 	return nil
 }
+
+// RestorePermissions restores the mode and ownership of a file to the stat
+// of the file before it was changed
+func (fm *FileManager) RestorePermissions(path string, stat *Stat) error {
+	if stat == nil {
+		return errors.Errorf("error: no stat to restore permissions of %s", path)
+	}
+	out, err := fm.ssh.Exec(ssh.Script(`chmod "$1" "$3" && chown "$2" "$3"`, stat.Mode, stat.Owner+":"+stat.Group, path))
+	if err != nil {
+		return errors.Wrapf(err, "error restoring permissions of %s, out: %s", path, out)
+	}
+	fm.log.Infof("restored permissions of %s to %s %s:%s", path, stat.Mode, stat.Owner, stat.Group)
+	return nil
+}
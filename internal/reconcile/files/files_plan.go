@@ -0,0 +1,140 @@
+package files
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+)
+
+// FileChange describes how a file on the target differs from the desired
+// state in the manifest. A FileChange is computed without modifying the target.
+type FileChange struct {
+	// Package is the name of the package the file belongs to
+	Package string
+	// Kind is the kind of the package the file belongs to
+	Kind manifest.PackageKind
+	// File is the desired file from the manifest
+	File manifest.File
+	// Create is true when the file does not exist on the target
+	Create bool
+	// Content is true when the rendered content differs from the target
+	Content bool
+	// Mode is true when the file mode on the target differs from the manifest
+	Mode bool
+	// Owner is true when the file owner on the target differs from the manifest
+	Owner bool
+	// LocalSha256 is the sha256 of the rendered file, as a hex string
+	LocalSha256 string
+	// Stat is the stat of the file on the target, nil when the file does not exist
+	Stat *Stat
+	// Diff is a unified diff of the target and rendered content when Content is true
+	Diff string
+}
+
+// Changed returns true when the file differs from the desired state
+func (c *FileChange) Changed() bool {
+	return c.Create || c.Content || c.Mode || c.Owner
+}
+
+// Plan renders all files and compares them with the files on the target.
+// Plan does not transfer files or apply permissions, use RenderAndTransfer
+// and ApplyPermissions to make changes.
+func (fm *FileManager) Plan(data map[string]string) ([]FileChange, error) {
+	if fm.ssh == nil {
+		return nil, errors.New("error: ssh client not initialized")
+	}
+	changes := make([]FileChange, 0)
+	for _, pkg := range fm.manifest.Packages {
+		if pkg.Removed() {
+			// files of removed packages are removed, not rendered
+			continue
+		}
+		for _, f := range pkg.Files {
+			if f.Path == "" {
+				return nil, errors.New("error: file path not set")
+			}
+			change, err := fm.Compare(&pkg, &f, data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error comparing %s", f.Path)
+			}
+			changes = append(changes, *change)
+		}
+	}
+	return changes, nil
+}
+
+// Compare renders one file and compares content, mode and owner with the
+// file on the target.
+func (fm *FileManager) Compare(p *manifest.Package, f *manifest.File, data map[string]string) (*FileChange, error) {
+	reader, err := fm.Render(p, f, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error rendering %s", f.Path)
+	}
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading file %s", f.Path)
+	}
+
+	change := &FileChange{
+		Package:     p.Name,
+		Kind:        p.Kind,
+		File:        *f,
+		LocalSha256: fmt.Sprintf("%x", sha256.Sum256(b)),
+	}
+
+	stat, err := fm.Stat(f)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "error stat %s", f.Path)
+		}
+		stat = nil
+		change.Create = true
+		change.Content = true
+		change.Mode = f.Mode != ""
+		change.Owner = f.Owner != ""
+	} else {
+		change.Stat = stat
+		change.Content = change.LocalSha256 != stat.Sha256
+		change.Mode = f.Mode != "" && !ModeEqual(f.Mode, stat.Mode)
+		change.Owner = f.Owner != "" && !OwnerEqual(f.Owner, stat)
+	}
+
+	if change.Content {
+		change.Diff, err = fm.Diff(f, stat, b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error diff %s", f.Path)
+		}
+	}
+	return change, nil
+}
+
+// ModeEqual compares two octal file modes, ignoring leading zeros.
+// For example 0644 and 644 are equal.
+func ModeEqual(a, b string) bool {
+	ma, err := strconv.ParseUint(a, 8, 32)
+	if err != nil {
+		return false
+	}
+	mb, err := strconv.ParseUint(b, 8, 32)
+	if err != nil {
+		return false
+	}
+	return ma == mb
+}
+
+// OwnerEqual compares an owner in chown format, user or user:group, with
+// the owner and group of stat. The group is only compared when provided.
+func OwnerEqual(owner string, stat *Stat) bool {
+	user, group, hasGroup := strings.Cut(owner, ":")
+	if user != stat.Owner {
+		return false
+	}
+	return !hasGroup || group == stat.Group
+}
@@ -0,0 +1,42 @@
+package files
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestModeEqual tests comparing octal file modes from manifests and stat
+func TestModeEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "0644", b: "644", want: true},
+		{a: "0777", b: "777", want: true},
+		{a: "0644", b: "755", want: false},
+		{a: "0644", b: "", want: false},
+		{a: "+rw", b: "644", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, ModeEqual(tt.a, tt.b), tt.want, "%s %s", tt.a, tt.b)
+	}
+}
+
+// TestOwnerEqual tests comparing chown style owners with stat
+func TestOwnerEqual(t *testing.T) {
+	stat := &Stat{Owner: "www-data", Group: "adm"}
+	tests := []struct {
+		owner string
+		want  bool
+	}{
+		{owner: "www-data", want: true},
+		{owner: "www-data:adm", want: true},
+		{owner: "www-data:root", want: false},
+		{owner: "root:adm", want: false},
+		{owner: "root", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, OwnerEqual(tt.owner, stat), tt.want, tt.owner)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/pkg/errors"
 
 	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
 )
 
 // Remove removes files for packages
@@ -23,7 +24,7 @@ func (fm *FileManager) Remove(pkgs ...manifest.Package) error {
 
 // RemoveOne removes one file
 func (fm *FileManager) RemoveOne(f *manifest.File) error {
-	out, err := fm.ssh.Execf("rm -f %s", f.Path)
+	out, err := fm.ssh.Exec(ssh.Cmd("rm", "-f", f.Path))
 	if err != nil {
 		fm.log.Infof("warning: error stat %s: %s", f.Path, err)
 		return nil // ignore error, file may not exist
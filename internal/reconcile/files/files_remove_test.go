@@ -1,6 +1,7 @@
 package files
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -12,7 +13,7 @@ import (
 )
 
 func testFileRemove(t *testing.T, f *testhelpers.DockerTestFixtures) {
-	sshClient, err := ssh.New(f.Log, true, f.PrivateKey,
+	sshClient, err := ssh.New(context.Background(), f.Log, true, f.PrivateKey,
 		"root", "", fmt.Sprintf("%s:%d", "127.0.0.1", f.SSHPort))
 	assert.NilError(t, err, "ssh client")
 
@@ -12,14 +12,19 @@ import (
 	"slack-reconcile-deployments/internal/ssh"
 )
 
-// RenderAndTransfer renders and transfers all files
-func (fm *FileManager) RenderAndTransfer(data map[string]string) (map[string]manifest.Package, error) {
+// RenderAndTransfer renders and transfers all files. A change is returned for
+// every file, use FileChange.Content to find the files that were transferred.
+func (fm *FileManager) RenderAndTransfer(data map[string]string) ([]FileChange, error) {
 	if fm.ssh == nil {
 		return nil, errors.New("error: ssh client not initialized")
 	}
-	changedPackages := make(map[string]manifest.Package)
+	changes := make([]FileChange, 0)
 	fm.scp = ssh.NewSecureCopyClient(fm.log, fm.ssh)
 	for _, pkg := range fm.manifest.Packages {
+		if pkg.Removed() {
+			// files of removed packages are removed, not rendered
+			continue
+		}
 		for _, f := range pkg.Files {
 			if f.Path == "" {
 				return nil, errors.New("error: file path not set")
@@ -28,17 +33,19 @@ func (fm *FileManager) RenderAndTransfer(data map[string]string) (map[string]man
 			if err != nil {
 				return nil, errors.Wrapf(err, "error rendering %s", f.Path)
 			}
-			differences, err := fm.Transfer(&f, reader)
+			change, err := fm.Transfer(&f, reader)
 			if err != nil {
-				return changedPackages, errors.Wrapf(err, "error transferring %s", f.Path)
+				return changes, errors.Wrapf(err, "error transferring %s", f.Path)
 			}
-			if differences {
+			change.Package = pkg.Name
+			change.Kind = pkg.Kind
+			if change.Content {
 				fm.log.Infof("differences detected for file: %s, package: %s", f.Path, pkg)
-				changedPackages[pkg.Name] = pkg
 			}
+			changes = append(changes, *change)
 		}
 	}
-	return changedPackages, nil
+	return changes, nil
 }
 
 // Render renders one files using templates
@@ -2,6 +2,7 @@ package files
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"testing"
@@ -15,7 +16,7 @@ import (
 )
 
 func testFileRender(t *testing.T, f *testhelpers.DockerTestFixtures) {
-	sshClient, err := ssh.New(f.Log, true, f.PrivateKey,
+	sshClient, err := ssh.New(context.Background(), f.Log, true, f.PrivateKey,
 		"root", "", fmt.Sprintf("%s:%d", "127.0.0.1", f.SSHPort))
 	assert.NilError(t, err, "ssh client")
 
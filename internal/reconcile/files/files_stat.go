@@ -2,7 +2,6 @@ package files
 
 import (
 	"bytes"
-	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -11,6 +10,7 @@ import (
 	cryptossh "golang.org/x/crypto/ssh"
 
 	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
 )
 
 // Stat is the result of a stat command.
@@ -28,14 +28,16 @@ type Stat struct {
 	Group string
 	// LastModifiedTime is the timestamp the last time the file was modified
 	LastModifiedTime string
+	// Mode is the access rights of the object in octal, like 644
+	Mode string
 	// Sha256 is the sha256 hash of the file, as a hex string
 	Sha256 string
 }
 
 // Stat emulates the stat linux command
 //
-// stat -c '%n,%F,%s,%U,%G,%y' filename
-// xyz,regular empty file,0,root,root,2023-12-14 19:32:05.044939009 +0000
+// stat -c '%n,%F,%s,%U,%G,%y,%a' filename
+// xyz,regular empty file,0,root,root,2023-12-14 19:32:05.044939009 +0000,644
 //
 // Format specifiers(see man stat):
 //
@@ -45,12 +47,12 @@ type Stat struct {
 //		%U username of owner
 //		%G group name of owner
 //	 %y time of last data modification, human-readable
+//	 %a access rights in octal
 func (fm *FileManager) Stat(f *manifest.File) (*Stat, error) {
 	if f == nil {
 		return nil, errors.New("error: file cannot be nil")
 	}
-	// use %% to escape % in format string
-	out, err := fm.ssh.Execf("stat -c '%%n,%%F,%%s,%%U,%%G,%%y' %s", f.Path)
+	out, err := fm.ssh.Exec(ssh.Cmd("stat", "-c", "%n,%F,%s,%U,%G,%y,%a", f.Path))
 	if err != nil {
 		fm.log.Infof("warning: error stat %s: %s", f.Path, err)
 		var exitErr *cryptossh.ExitError
@@ -64,7 +66,7 @@ func (fm *FileManager) Stat(f *manifest.File) (*Stat, error) {
 	}
 	fm.log.Infof("stat %s: %s", f.Path, strings.TrimSpace(string(out)))
 	parts := strings.Split(strings.TrimSpace(string(out)), ",")
-	if len(parts) != 6 {
+	if len(parts) != 7 {
 		return nil, errors.Errorf("error parsing stat output: %s", out)
 	}
 	size, err := strconv.ParseInt(parts[2], 10, 64)
@@ -78,17 +80,20 @@ func (fm *FileManager) Stat(f *manifest.File) (*Stat, error) {
 		Owner:            parts[3],
 		Group:            parts[4],
 		LastModifiedTime: parts[5],
+		Mode:             parts[6],
 	}
 
 	// get a sha 256 for the file, can be used to detect differences.
 	// Example:
 	// sha256sum  /etc/os-release
 	// 98fa979a2418b2a4e8789a8dc6c8c2ce3c9b0e3c8210658bb2d16956c125dcce  /etc/os-release
-	out, err = fm.ssh.Execf(fmt.Sprintf("sha256sum %s |awk '{ print $1 }'", f.Path))
+	out, err = fm.ssh.Exec(ssh.Cmd("sha256sum", f.Path))
 	if err != nil {
 		return nil, errors.Wrapf(err, "error stat %s", f.Path)
 	}
-	out = bytes.TrimSpace(out)
+	if fields := bytes.Fields(out); len(fields) > 0 {
+		out = fields[0]
+	}
 	fm.log.Infof("stat %s: out: '%s'", f.Path, out)
 	if len(out) == 0 {
 		fm.log.Infof("warning: parsing sha256 for %s, output: %s", out, f.Path)
@@ -21,4 +21,10 @@ func TestFilesTestSuite(t *testing.T) {
 	t.Run("test files remove", func(t *testing.T) {
 		testFileRemove(t, s)
 	})
+	t.Run("test files validate", func(t *testing.T) {
+		testFileValidate(t, s)
+	})
+	t.Run("test files backup and rollback", func(t *testing.T) {
+		testFileBackupRollback(t, s)
+	})
 }
@@ -13,19 +13,20 @@ import (
 	"zappem.net/pub/debug/xxd"
 
 	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
 )
 
-// Transfer transfers one file to remote system
-func (fm *FileManager) Transfer(f *manifest.File, reader io.Reader) (bool, error) {
-	// set differences to false, if we find modified files, we'll set it to true
+// Transfer transfers one file to remote system. The returned change has
+// Content set to true when the file was transferred.
+func (fm *FileManager) Transfer(f *manifest.File, reader io.Reader) (*FileChange, error) {
 	if fm.scp == nil {
-		return false, errors.New("error: scp client not initialized")
+		return nil, errors.New("error: scp client not initialized")
 	}
 
 	stat, err := fm.Stat(f)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			return false, errors.Wrapf(err, "error stat %s", f.Path)
+			return nil, errors.Wrapf(err, "error stat %s", f.Path)
 		}
 	}
 	if stat != nil {
@@ -36,14 +37,19 @@ func (fm *FileManager) Transfer(f *manifest.File, reader io.Reader) (bool, error
 
 	b, err := io.ReadAll(reader)
 	if err != nil {
-		return false, errors.Wrapf(err, "error reading file %s", f.Path)
+		return nil, errors.Wrapf(err, "error reading file %s", f.Path)
 	}
 
+	// remote sha is already in hex
+	shalocal := fmt.Sprintf("%x", sha256.Sum256(b))
+	change := &FileChange{
+		File:        *f,
+		Create:      stat == nil,
+		LocalSha256: shalocal,
+		Stat:        stat,
+	}
 	// only check shas when the remove file exists
-	shalocal := ""
 	if stat != nil {
-		// remote sha is already in hex
-		shalocal = fmt.Sprintf("%x", sha256.Sum256(b))
 		fm.log.Infof("sha256 %s, local: %s, remote: %s", f.Path, shalocal, stat.Sha256)
 		fm.log.Infof("local file %s", b)
 	}
@@ -54,18 +60,28 @@ func (fm *FileManager) Transfer(f *manifest.File, reader io.Reader) (bool, error
 
 	if stat != nil && shalocal == stat.Sha256 {
 		fm.log.Infof("not transferring file %s, no differnces detected", f.Path)
-		return false, nil
+		return change, nil
+	}
+
+	// show what is about to change on the target, a failed diff should not
+	// prevent the transfer
+	d, err := fm.Diff(f, stat, b)
+	if err != nil {
+		fm.log.Warnf("unable to diff %s: %v", f.Path, err)
+	} else {
+		fm.log.Infof("diff %s:\n%s", f.Path, d)
+		change.Diff = d
 	}
 
 	if err := fm.scp.Copy(reader, tmpName); err != nil {
-		return false, errors.Wrap(err, "error copying file")
+		return nil, errors.Wrap(err, "error copying file")
 	}
 
 	// re-read transferred file to verify contents are there.
 	// this is useful for debugging, but overkill normally.
-	out, err := fm.ssh.Execf(fmt.Sprintf("/bin/cat %s", tmpName))
+	out, err := fm.ssh.Exec(ssh.Cmd("/bin/cat", tmpName))
 	if err != nil {
-		return false, errors.Wrapf(err, "error exec cat %s", tmpName)
+		return nil, errors.Wrapf(err, "error exec cat %s", tmpName)
 	}
 	fm.log.Infof("contents read from remote file %s: %s", tmpName, out)
 
@@ -74,15 +90,39 @@ func (fm *FileManager) Transfer(f *manifest.File, reader io.Reader) (bool, error
 	xxd.Print(w, 0x0, out)
 	fm.log.Infof("hex dump of remote file %s: %s", tmpName, w.String())
 
+	// validate the staged copy, an invalid file never replaces the live copy
+	if err := fm.Validate(f, tmpName); err != nil {
+		if _, rmErr := fm.ssh.Exec(ssh.Cmd("rm", "-f", tmpName)); rmErr != nil {
+			fm.log.Warnf("unable to remove staged file %s: %v", tmpName, rmErr)
+		}
+		return nil, err
+	}
+
+	// save the content the file is about to replace, for rollback
+	if err := fm.Backup(f, stat); err != nil {
+		return nil, err
+	}
+
 	// move file to its intended location, from tmp
 	// we use /tmp if the ssh user does not have write access to the
 	// destination directory, move using sudo.
-	out, err = fm.ssh.Execf(fmt.Sprintf("mv %s %s", tmpName, f.Path))
+	if f.Become != "" {
+		// the user cannot remove the staged file of the ssh user from /tmp,
+		// the file is copied as the user and the staged file removed
+		out, err = fm.ssh.AsUser(f.Become).Exec(ssh.Cmd("cp", tmpName, f.Path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error exec cp %s as %s, out: %s", f.Path, f.Become, out)
+		}
+		out, err = fm.ssh.Exec(ssh.Cmd("rm", "-f", tmpName))
+	} else {
+		out, err = fm.ssh.Exec(ssh.Cmd("mv", tmpName, f.Path))
+	}
 	if err != nil {
-		return false, errors.Wrapf(err, "error exec cat %s", f.Path)
+		return nil, errors.Wrapf(err, "error exec cat %s", f.Path)
 	}
 
 	// TODO: set owner and file modes
 	fm.log.Infof("moved %s to %s, out: '%s'", tmpName, f.Path, out)
-	return true, nil
+	change.Content = true
+	return change, nil
 }
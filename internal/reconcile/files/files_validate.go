@@ -0,0 +1,34 @@
+package files
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// Validate runs the validate command of a file against the staged copy of
+// the file. The output of the validate command is in the returned error when
+// validation fails.
+func (fm *FileManager) Validate(f *manifest.File, staged string) error {
+	if f.Validate == "" {
+		return nil
+	}
+	cmd := ValidateCommand(f.Validate, staged)
+	fm.log.Infof("validating %s with %s", f.Path, cmd)
+	out, err := fm.ssh.Exec(ssh.Script(cmd))
+	if err != nil {
+		return errors.Wrapf(err, "validation of %s failed, %s: %s", f.Path, cmd, strings.TrimSpace(string(out)))
+	}
+	fm.log.Infof("validated %s, out: '%s'", f.Path, out)
+	return nil
+}
+
+// ValidateCommand returns the validate command with %s replaced by the path
+// of the staged file, quoted for the shell. The validate command is a shell
+// command from the manifest, it is run by the shell as is.
+func ValidateCommand(validate, staged string) string {
+	return strings.ReplaceAll(validate, "%s", ssh.Quote(staged))
+}
@@ -0,0 +1,43 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+	"slack-reconcile-deployments/internal/testhelpers"
+)
+
+func testFileValidate(t *testing.T, f *testhelpers.DockerTestFixtures) {
+	sshClient, err := ssh.New(context.Background(), f.Log, true, f.PrivateKey,
+		"root", "", fmt.Sprintf("%s:%d", "127.0.0.1", f.SSHPort))
+	assert.NilError(t, err, "ssh client")
+
+	fm := New(f.Log, &manifest.Manifest{}, sshClient)
+	fm.scp = ssh.NewSecureCopyClient(f.Log, sshClient)
+	file := &manifest.File{Path: "/etc/validate.conf", Validate: "grep -q '^listen' %s"}
+
+	_, err = sshClient.Execf("echo 'listen 80' > /etc/validate.conf")
+	assert.NilError(t, err, "create /etc/validate.conf")
+
+	_, err = fm.Transfer(file, bytes.NewReader([]byte("invalid\n")))
+	assert.ErrorContains(t, err, "validation of /etc/validate.conf failed")
+	out, err := sshClient.Execf("cat /etc/validate.conf")
+	assert.NilError(t, err, "cat /etc/validate.conf")
+	assert.Equal(t, string(out), "listen 80\n", "live copy is not replaced")
+
+	change, err := fm.Transfer(file, bytes.NewReader([]byte("listen 8080\n")))
+	assert.NilError(t, err, "transfer")
+	assert.Check(t, change.Content, "content")
+}
+
+func TestValidateCommand(t *testing.T) {
+	assert.Equal(t, ValidateCommand("nginx -t -c %s", "/tmp/nginx.conf"), "nginx -t -c /tmp/nginx.conf")
+	assert.Equal(t, ValidateCommand("visudo -cf %s", "/tmp/sudoers"), "visudo -cf /tmp/sudoers")
+	assert.Equal(t, ValidateCommand("nginx -t -c %s", "/tmp/my app.conf"), "nginx -t -c '/tmp/my app.conf'")
+}
@@ -0,0 +1,211 @@
+package lock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"slack-reconcile-deployments/internal/process"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// Path is the path of the lock file on the target
+const Path = "/var/lib/reconcile-deployments/lock.json"
+
+// unsafeNameRE matches characters not kept in file names of the lock
+var unsafeNameRE = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// DefaultStaleAfter is the age after which a lock is stale, longer than the
+// default reconcile timeout
+const DefaultStaleAfter = time.Hour
+
+// Lock is an advisory lock on a target, held by a run while it changes the
+// target so concurrent runs do not race on packages and staged files
+type Lock struct {
+	// Owner is the user running reconcile
+	Owner string `json:"owner"`
+	// Hostname is the host reconcile runs on
+	Hostname string `json:"hostname"`
+	// PID is the process id of reconcile on Hostname
+	PID int `json:"pid"`
+	// RunID is the id of the run holding the lock
+	RunID string `json:"run_id"`
+	// ManifestID is the id of the manifest of the run
+	ManifestID string `json:"manifest_id"`
+	// Operation is the operation of the run
+	Operation string `json:"operation"`
+	// AcquiredAt is when the lock was acquired
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+// New creates a lock for the run, owned by the current user and process
+func New(manifestID, runID, operation string) *Lock {
+	owner := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		owner = u.Username
+	}
+	hostname, _ := os.Hostname()
+	return &Lock{
+		Owner:      owner,
+		Hostname:   hostname,
+		PID:        os.Getpid(),
+		RunID:      runID,
+		ManifestID: manifestID,
+		Operation:  operation,
+		AcquiredAt: time.Now().UTC(),
+	}
+}
+
+// String describes who holds the lock, for operators
+func (l *Lock) String() string {
+	return fmt.Sprintf("%s@%s (pid %d, run %s, %s of manifest %s) since %s", l.Owner, l.Hostname, l.PID,
+		l.RunID, l.Operation, l.ManifestID, l.AcquiredAt.Format(time.RFC3339))
+}
+
+// name returns a file name unique to the manifest, run and process of the
+// lock, manifests of one run on the same host do not share files
+func (l *Lock) name() string {
+	return unsafeNameRE.ReplaceAllString(fmt.Sprintf("%s.%s.%d", l.ManifestID, l.RunID, l.PID), "_")
+}
+
+// same returns true when other is the same lock, taken by the same run and
+// process at the same time
+func (l *Lock) same(other *Lock) bool {
+	return l.Owner == other.Owner && l.Hostname == other.Hostname && l.PID == other.PID &&
+		l.RunID == other.RunID && l.ManifestID == other.ManifestID && l.AcquiredAt.Equal(other.AcquiredAt)
+}
+
+// Stale returns true when the lock is older than staleAfter, or was taken
+// by a process on this host that is no longer running
+func (l *Lock) Stale(now time.Time, staleAfter time.Duration) bool {
+	if now.Sub(l.AcquiredAt) > staleAfter {
+		return true
+	}
+	hostname, _ := os.Hostname()
+	return l.Hostname == hostname && !process.Running(l.PID)
+}
+
+// Read reads the lock from the target, nil is returned when the target is
+// not locked
+func Read(sshClient *ssh.Client) (*Lock, error) {
+	out, err := sshClient.Exec(ssh.Script(`cat "$1" 2>/dev/null || true`, Path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading lock %s", Path)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+	var l Lock
+	if err := json.Unmarshal(out, &l); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshalling lock %s", Path)
+	}
+	return &l, nil
+}
+
+// Acquire acquires the lock on the target. The lock is copied to /tmp as the
+// ssh user, copied next to Path with escalation, and hard linked to Path,
+// which fails when the target is already locked. A stale lock is broken,
+// otherwise an error describing who holds the lock is returned.
+func Acquire(log *zap.SugaredLogger, sshClient *ssh.Client, l *Lock, staleAfter time.Duration) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error marshalling lock")
+	}
+	// the ssh user cannot write to the directory of Path, and a hard link
+	// cannot cross file systems, so the lock is staged in /tmp and copied
+	// next to Path
+	staged := path.Join("/", "tmp", fmt.Sprintf("reconcile-deployments-lock.%s.json", l.name()))
+	tmp := fmt.Sprintf("%s.%s", Path, l.name())
+	if err := ssh.NewSecureCopyClient(log, sshClient).Copy(bytes.NewReader(b), staged); err != nil {
+		return errors.Wrapf(err, "error copying lock to %s", staged)
+	}
+	defer func() {
+		if out, err := sshClient.Exec(ssh.Cmd("rm", "-f", staged, tmp)); err != nil {
+			log.Warnf("unable to remove %s and %s: %v, out: %s", staged, tmp, err, out)
+		}
+	}()
+	if out, err := sshClient.Exec(ssh.Script(`mkdir -p "$1" && cp "$2" "$3"`, path.Dir(Path), staged, tmp)); err != nil {
+		return errors.Wrapf(err, "error copying lock to %s, out: %s", tmp, out)
+	}
+
+	// a second attempt after a stale lock is broken, or the lock was released
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := sshClient.Exec(ssh.Cmd("ln", tmp, Path)); err == nil {
+			log.Infof("acquired lock %s on %s", Path, sshClient.Host())
+			return nil
+		}
+		held, err := Read(sshClient)
+		if err != nil {
+			return err
+		}
+		if held == nil {
+			continue
+		}
+		if !held.Stale(time.Now(), staleAfter) {
+			return errors.Errorf("%s is locked by %s, remove %s on the host if that run is no longer running",
+				sshClient.Host(), held, Path)
+		}
+		if err := breakStale(log, sshClient, l, held); err != nil {
+			return err
+		}
+	}
+	return errors.Errorf("unable to acquire lock %s on %s", Path, sshClient.Host())
+}
+
+// breakStale breaks the stale lock held. The lock is renamed to a name unique
+// to the run of l, a rename is atomic so only one of the runs breaking the
+// same lock moves it. The moved lock is put back when it is not held, like
+// when another run broke the stale lock and acquired the lock after held was
+// read, so a lock just acquired is never removed.
+func breakStale(log *zap.SugaredLogger, sshClient *ssh.Client, l, held *Lock) error {
+	stale := fmt.Sprintf("%s.stale.%s", Path, l.name())
+	if _, err := sshClient.Exec(ssh.Cmd("mv", Path, stale)); err != nil {
+		// another run moved the lock first, the next attempt links or reads
+		// the lock of that run
+		return nil
+	}
+	defer func() {
+		if out, err := sshClient.Exec(ssh.Cmd("rm", "-f", stale)); err != nil {
+			log.Warnf("unable to remove %s: %v, out: %s", stale, err, out)
+		}
+	}()
+	out, err := sshClient.Exec(ssh.Cmd("cat", stale))
+	if err != nil {
+		return errors.Wrapf(err, "error reading lock %s, out: %s", stale, out)
+	}
+	var moved Lock
+	if err := json.Unmarshal(out, &moved); err == nil && moved.same(held) {
+		log.Warnf("broke stale lock on %s held by %s", sshClient.Host(), held)
+		return nil
+	}
+	if out, err := sshClient.Exec(ssh.Cmd("ln", stale, Path)); err != nil {
+		return errors.Wrapf(err, "error restoring lock %s of another run on %s, out: %s", Path, sshClient.Host(), out)
+	}
+	return nil
+}
+
+// Release releases the lock on the target. The lock is left in place when
+// it is no longer held by the run, like when it was broken as stale.
+func Release(log *zap.SugaredLogger, sshClient *ssh.Client, l *Lock) error {
+	held, err := Read(sshClient)
+	if err != nil {
+		return err
+	}
+	if held == nil || held.RunID != l.RunID || held.ManifestID != l.ManifestID || held.PID != l.PID {
+		log.Warnf("lock on %s is no longer held by run %s, held by %v", sshClient.Host(), l.RunID, held)
+		return nil
+	}
+	if out, err := sshClient.Exec(ssh.Cmd("rm", "-f", Path)); err != nil {
+		return errors.Wrapf(err, "error removing lock %s, out: %s", Path, out)
+	}
+	log.Infof("released lock %s on %s", Path, sshClient.Host())
+	return nil
+}
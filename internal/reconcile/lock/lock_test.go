@@ -0,0 +1,70 @@
+package lock
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestStale tests a lock is stale when old, or taken by a process on this
+// host that is no longer running
+func TestStale(t *testing.T) {
+	now := time.Now()
+	hostname, err := os.Hostname()
+	assert.NilError(t, err)
+	tests := []struct {
+		name  string
+		lock  Lock
+		stale bool
+	}{
+		{name: "held", lock: Lock{Hostname: hostname, PID: os.Getpid(), AcquiredAt: now.Add(-time.Minute)}},
+		{name: "old", lock: Lock{Hostname: hostname, PID: os.Getpid(), AcquiredAt: now.Add(-2 * time.Hour)},
+			stale: true},
+		{name: "other host", lock: Lock{Hostname: "ci-runner-1", PID: 1 << 30, AcquiredAt: now}},
+		{name: "exited", lock: Lock{Hostname: hostname, PID: 1 << 30, AcquiredAt: now}, stale: true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.lock.Stale(now, DefaultStaleAfter), tt.stale, tt.name)
+	}
+}
+
+// TestName tests file names of locks are unique to the manifest, run and
+// process, and safe as file names
+func TestName(t *testing.T) {
+	l := &Lock{ManifestID: "web/b2267d6b23", RunID: "20231016T193725Z-a1b2c3", PID: 4242}
+	assert.Equal(t, l.name(), "web_b2267d6b23.20231016T193725Z-a1b2c3.4242")
+	other := *l
+	other.ManifestID = "b5a1c3d4e2"
+	assert.Assert(t, other.name() != l.name())
+}
+
+// TestSame tests a lock is only the same when taken by the same run and
+// process at the same time
+func TestSame(t *testing.T) {
+	l := New("b2267d6b23", "20231016T193725Z-a1b2c3", "reconcile")
+	b, err := json.Marshal(l)
+	assert.NilError(t, err)
+	var read Lock
+	assert.NilError(t, json.Unmarshal(b, &read))
+	assert.Assert(t, read.same(l))
+
+	other := *l
+	other.RunID = "20231016T193800Z-d4e5f6"
+	assert.Assert(t, !other.same(l))
+	other = *l
+	other.AcquiredAt = l.AcquiredAt.Add(time.Second)
+	assert.Assert(t, !other.same(l))
+}
+
+func TestString(t *testing.T) {
+	l := New("b2267d6b23", "20231016T193725Z-a1b2c3", "reconcile")
+	l.Owner = "deploy"
+	l.Hostname = "ci-runner-1"
+	l.PID = 4242
+	l.AcquiredAt = time.Date(2023, 10, 16, 19, 37, 25, 0, time.UTC)
+	assert.Equal(t, l.String(), "deploy@ci-runner-1 (pid 4242, run 20231016T193725Z-a1b2c3, reconcile of "+
+		"manifest b2267d6b23) since 2023-10-16T19:37:25Z")
+}
@@ -0,0 +1,231 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// verify apk implements interface for package managers
+var _ Manager = &Apk{}
+
+// Apk manages packages with apk, for alpine. Holds are world constraints
+// pinning a package to an exact version, name=version in /etc/apk/world.
+type Apk struct {
+	// log is the logger
+	log *zap.SugaredLogger
+	// ssh client to invoke package commands on
+	ssh *ssh.Client
+	// manifest is the manifest for the host
+	manifest *manifest.Manifest
+}
+
+// NewApk creates a new apk package manager
+func NewApk(log *zap.SugaredLogger, m *manifest.Manifest, ssh *ssh.Client) *Apk {
+	return &Apk{
+		log:      log,
+		ssh:      ssh,
+		manifest: m,
+	}
+}
+
+// Name returns apk
+func (a *Apk) Name() manifest.PackageManager {
+	return manifest.PackageManagerApk
+}
+
+// Update updates the repository indexes
+func (a *Apk) Update() error {
+	_, err := a.ssh.Stream(ssh.Cmd("apk", "update"))
+	if err != nil {
+		return errors.Wrap(err, "error on apk update")
+	}
+	return nil
+}
+
+// Query returns the installed packages from apk policy
+func (a *Apk) Query() (map[string]manifest.Package, error) {
+	policies, err := a.Policy()
+	if err != nil {
+		return nil, err
+	}
+	pkglist := make(map[string]manifest.Package)
+	for name, policy := range policies {
+		if policy.Installed != "" {
+			pkglist[name] = manifest.Package{Name: name, Version: policy.Installed, Status: "installed"}
+		}
+	}
+	return pkglist, nil
+}
+
+// Policy returns installed and available versions with apk policy. The
+// candidate is the highest available version.
+func (a *Apk) Policy() (map[string]Policy, error) {
+	if len(a.manifest.Packages) == 0 {
+		return nil, errors.New("no packages provided to query policy")
+	}
+	out, err := a.ssh.Exec(ssh.Cmd("apk", append([]string{"policy"}, packageNames(a.manifest.Packages)...)...))
+	if err != nil {
+		return nil, errors.Wrap(err, "error on apk policy")
+	}
+	return parseApkPolicy(out), nil
+}
+
+// Install installs packages, a concrete version is installed with a fuzzy
+// world constraint, name~version, so the package is not held
+func (a *Apk) Install(pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages provided to install")
+	}
+	specs := make([]string, 0, len(pkgs))
+	for i := range pkgs {
+		if HasVersionConstraint(pkgs[i].Version) {
+			specs = append(specs, fmt.Sprintf("%s~%s", pkgs[i].Name, pkgs[i].Version))
+		} else {
+			specs = append(specs, pkgs[i].Name)
+		}
+	}
+	_, err := a.ssh.Stream(ssh.Cmd("apk", append([]string{"add"}, specs...)...))
+	if err != nil {
+		return errors.Wrap(err, "error on apk add")
+	}
+	return nil
+}
+
+// Remove removes packages, purge also removes modified configuration files
+func (a *Apk) Remove(purge bool, pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages provided to remove")
+	}
+	names := packageNames(pkgs)
+	a.log.Infof("removing packages (purge? %v), %s", purge, strings.Join(names, " "))
+	args := []string{"del"}
+	if purge {
+		args = append(args, "--purge")
+	}
+	_, err := a.ssh.Stream(ssh.Cmd("apk", append(args, names...)...))
+	if err != nil {
+		return errors.Wrap(err, "error on apk del")
+	}
+	return nil
+}
+
+// Autoremove is not needed with apk, apk del removes dependencies no longer
+// needed by packages in /etc/apk/world
+func (a *Apk) Autoremove(_ bool) error {
+	a.log.Infof("apk removes dependencies with apk del, no autoremove")
+	return nil
+}
+
+// Held returns packages pinned to an exact version in /etc/apk/world
+func (a *Apk) Held() (map[string]bool, error) {
+	out, err := a.ssh.Exec(ssh.Cmd("cat", "/etc/apk/world"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading /etc/apk/world")
+	}
+	return parseApkWorld(out), nil
+}
+
+// Hold pins packages to the installed version with name=version
+func (a *Apk) Hold(pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages provided to hold")
+	}
+	pkglist, err := a.Query()
+	if err != nil {
+		return err
+	}
+	specs := make([]string, 0, len(pkgs))
+	for i := range pkgs {
+		installed, ok := pkglist[pkgs[i].Name]
+		if !ok {
+			return errors.Errorf("package %s is not installed, cannot hold", pkgs[i].Name)
+		}
+		specs = append(specs, fmt.Sprintf("%s=%s", installed.Name, installed.Version))
+	}
+	_, err = a.ssh.Stream(ssh.Cmd("apk", append([]string{"add"}, specs...)...))
+	if err != nil {
+		return errors.Wrap(err, "error on apk add hold")
+	}
+	return nil
+}
+
+// Unhold replaces the exact version constraint of packages with the name
+func (a *Apk) Unhold(pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages provided to unhold")
+	}
+	_, err := a.ssh.Stream(ssh.Cmd("apk", append([]string{"add"}, packageNames(pkgs)...)...))
+	if err != nil {
+		return errors.Wrap(err, "error on apk add unhold")
+	}
+	return nil
+}
+
+// parseApkPolicy parses the output of apk policy. The installed version is
+// the version with the lib/apk/db/installed repository.
+//
+// example:
+//
+//	nginx policy:
+//	  1.24.0-r7:
+//	    lib/apk/db/installed
+//	    https://dl-cdn.alpinelinux.org/alpine/v3.18/main
+//	  1.24.0-r6:
+//	    https://dl-cdn.alpinelinux.org/alpine/v3.18/main
+func parseApkPolicy(out []byte) map[string]Policy {
+	policies := make(map[string]Policy)
+	var current *Policy
+	version := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasSuffix(text, " policy:"):
+			if current != nil {
+				policies[current.Name] = *current
+			}
+			current = &Policy{Name: strings.TrimSuffix(text, " policy:")}
+		case current == nil:
+			continue
+		case strings.HasSuffix(trimmed, ":") && !strings.Contains(trimmed, "/"):
+			version = strings.TrimSuffix(trimmed, ":")
+			current.Versions = append(current.Versions, version)
+		case trimmed == "lib/apk/db/installed":
+			current.Installed = version
+		}
+	}
+	if current != nil {
+		policies[current.Name] = *current
+	}
+	for name, policy := range policies {
+		policy.Candidate = highest(policy.Versions)
+		policies[name] = policy
+	}
+	return policies
+}
+
+// parseApkWorld parses /etc/apk/world, packages with an exact version
+// constraint, name=version, are held
+func parseApkWorld(out []byte) map[string]bool {
+	held := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		i := strings.IndexAny(text, "=<>~")
+		if i > 0 && text[i] == '=' {
+			held[text[:i]] = true
+		}
+	}
+	return held
+}
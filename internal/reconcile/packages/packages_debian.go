@@ -15,11 +15,12 @@ import (
 	"slack-reconcile-deployments/internal/ssh"
 )
 
+// verify apt implements interface for package managers
+var _ Manager = &Packages{}
+
 // Packages manage installed packages on remote system over ssh.
 //
-// This implementation assumes debian/ubuntu, uses apt-get. An improvement
-// could be to make an abstraction for packages and package managers for different
-// systems, like rpm, yum, pacman, etc.
+// This implementation is the Manager for debian/ubuntu, uses apt-get and dpkg.
 type Packages struct {
 	// log is the logger
 	log *zap.SugaredLogger
@@ -41,23 +42,17 @@ func NewPackages(log *zap.SugaredLogger, m *manifest.Manifest, ssh *ssh.Client)
 	}
 }
 
+// Name returns apt
+func (p *Packages) Name() manifest.PackageManager {
+	return manifest.PackageManagerApt
+}
+
 // Update update package repository
 func (p *Packages) Update() error {
-	out, err := p.ssh.Execf(`DEBIAN_FRONTEND=noninteractive apt-get update`)
+	_, err := p.ssh.Stream(aptGet("update"))
 	if err != nil {
 		return errors.Wrap(err, "error on apt-get update")
 	}
-
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		text := scanner.Text()
-		if len(strings.TrimSpace(text)) > 0 {
-			p.log.Infof("ssh> %s", text)
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		p.log.Warn("reading stdout:", err)
-	}
 	return nil
 }
 
@@ -94,40 +89,66 @@ func (p *Packages) Query() (map[string]manifest.Package, error) {
 	// exit status:
 	// dpkg-query returns 1 if packages are not installed
 	// dpkg-query returns 2 if there is an error
-	out, err := p.ssh.Execf(`DEBIAN_FRONTEND=noninteractive /usr/bin/dpkg-query -W `+
-		`'-f=${binary:Package},${Version},${db:Status-Status}\n' %s`, strings.Join(names, " "))
+	result, err := p.ssh.Run(ssh.Cmd("/usr/bin/dpkg-query", append([]string{"-W",
+		`-f=${binary:Package},${Version},${db:Status-Status}\n`}, names...)...).
+		Env("DEBIAN_FRONTEND", "noninteractive"))
 	if err != nil {
 		var exitErr *cryptossh.ExitError
-		if errors.As(err, &exitErr) {
-			p.log.Infof("error on dpkg-query: exit error %v, exit status: %v", err, exitErr.ExitStatus())
-			if exitErr.ExitStatus() == 1 {
-				// dpkg-query returns 1 if packages are not installed, this is ok, we'll re-install
-				p.log.Infof("dpkg-query returned 1, packages not installed, this is ok, %v", err)
-			} else {
-				return nil, errors.Wrap(err, "error on dpkg-query")
-			}
+		if !errors.As(err, &exitErr) {
+			return nil, errors.Wrap(err, "error on dpkg-query")
+		}
+		p.log.Infof("error on dpkg-query: exit error %v, exit status: %v", err, exitErr.ExitStatus())
+		if exitErr.ExitStatus() == 1 {
+			// dpkg-query returns 1 if packages are not installed, this is ok, we'll re-install
+			p.log.Infof("dpkg-query returned 1, packages not installed, this is ok, %v", err)
+		} else {
+			return nil, errors.Wrap(err, "error on dpkg-query")
 		}
 	}
 
+	// packages that are not found are reported on stderr, only stdout has
+	// the name,version,status lines
+	return parseDpkgQuery(result.Stdout), nil
+}
+
+// parseDpkgQuery parses name,version,status lines of dpkg-query, other lines
+// are ignored
+func parseDpkgQuery(out []byte) map[string]manifest.Package {
 	pkglist := make(map[string]manifest.Package)
 	scanner := bufio.NewScanner(bytes.NewReader(out))
 	for scanner.Scan() {
-		text := scanner.Text()
-		p.log.Infof("ssh> %s", text)
-		parts := strings.Split(text, ",")
-		pkg := manifest.Package{
+		parts := strings.Split(scanner.Text(), ",")
+		if len(parts) != 3 {
+			continue
+		}
+		pkglist[parts[0]] = manifest.Package{
 			Name:    parts[0],
 			Version: parts[1],
 			Status:  parts[2],
 		}
-		pkglist[pkg.Name] = pkg
 	}
+	return pkglist
+}
 
-	if err := scanner.Err(); err != nil {
-		p.log.Warn("reading stdout:", err)
+// Policy queries the apt policy, installed, candidate and available versions,
+// of the packages in the manifest. The policy is read from the apt cache, run
+// Update first to refresh available versions.
+func (p *Packages) Policy() (map[string]Policy, error) {
+	if len(p.manifest.Packages) == 0 {
+		return nil, errors.New("no packages provided to query policy")
+	}
+	names := make([]string, 0, len(p.manifest.Packages))
+	for i := range p.manifest.Packages {
+		names = append(names, p.manifest.Packages[i].Name)
 	}
 
-	return pkglist, nil
+	// apt-cache policy exits 0 for unknown packages, and prints
+	// N: Unable to locate package, unknown packages have no policy
+	out, err := p.ssh.Exec(ssh.Cmd("apt-cache", append([]string{"policy"}, names...)...))
+	if err != nil {
+		return nil, errors.Wrap(err, "error on apt-cache policy")
+	}
+	return parsePolicy(out), nil
 }
 
 // Install installs provided packages
@@ -136,9 +157,16 @@ func (p *Packages) Install(pkgs ...manifest.Package) error {
 		return errors.New("no packages provided to install")
 	}
 
+	// pin the version when a concrete version is provided, allow downgrades
+	// so that a pinned version older than the installed version is enforced,
+	// and allow changing held packages, the manifest decides the version
 	names := make([]string, 0, len(pkgs))
 	for i := range pkgs {
-		names = append(names, pkgs[i].Name)
+		if HasVersionConstraint(pkgs[i].Version) {
+			names = append(names, fmt.Sprintf("%s=%s", pkgs[i].Name, pkgs[i].Version))
+		} else {
+			names = append(names, pkgs[i].Name)
+		}
 	}
 
 	// invoke the fixInvokeRcd to fix invoke-rc.d: could not determine current runlevel
@@ -149,20 +177,12 @@ func (p *Packages) Install(pkgs ...manifest.Package) error {
 		}
 	}
 
-	out, err := p.ssh.Execf(`DEBIAN_FRONTEND=noninteractive apt-get install -y ` + strings.Join(names, " "))
+	_, err := p.ssh.Stream(aptGet(append([]string{"install", "-y", "--allow-downgrades",
+		"--allow-change-held-packages"}, names...)...))
 	if err != nil {
 		return errors.Wrap(err, "error on apt-get install")
 	}
 
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		text := scanner.Text()
-		p.log.Infof("ssh> %s", text)
-	}
-	if err := scanner.Err(); err != nil {
-		p.log.Warn("reading stdout:", err)
-	}
-
 	return nil
 }
 
@@ -191,21 +211,25 @@ func (p *Packages) Remove(purge bool, pkgs ...manifest.Package) error {
 	if purge {
 		purgeOrRemove = "purge"
 	}
-	out, err := p.ssh.Execf(`DEBIAN_FRONTEND=noninteractive apt-get %s -y `+strings.Join(names, " "),
-		purgeOrRemove)
+	_, err := p.ssh.Stream(aptGet(append([]string{purgeOrRemove, "-y"}, names...)...))
 	if err != nil {
 		return errors.Wrap(err, "error on apt-get remove")
 	}
 
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		text := scanner.Text()
-		p.log.Infof("ssh> %s", text)
+	return nil
+}
+
+// Autoremove removes packages installed as dependencies that are no longer
+// needed with apt-get autoremove
+func (p *Packages) Autoremove(purge bool) error {
+	args := []string{"autoremove", "-y"}
+	if purge {
+		args = append(args, "--purge")
 	}
-	if err := scanner.Err(); err != nil {
-		p.log.Warn("reading stdout:", err)
+	_, err := p.ssh.Stream(aptGet(args...))
+	if err != nil {
+		return errors.Wrap(err, "error on apt-get autoremove")
 	}
-
 	return nil
 }
 
@@ -219,39 +243,15 @@ func (p *Packages) Remove(purge bool, pkgs ...manifest.Package) error {
 // the services will be started as part install, and they generate
 // errors.
 func (p *Packages) fixInvokeRcd() error {
-	out, err := p.ssh.Execf(`printf '#!/bin/sh\nexit 0\n' > /usr/sbin/policy-rc.d`)
+	_, err := p.ssh.Exec(ssh.Script(`printf '#!/bin/sh\nexit 0\n' > "$1"`, "/usr/sbin/policy-rc.d"))
 	if err != nil {
 		return errors.Wrap(err, "error on service start")
 	}
 
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		text := scanner.Text()
-		p.log.Infof("ssh> %s", text)
-	}
-	if err := scanner.Err(); err != nil {
-		p.log.Warn("reading stdout:", err)
-	}
-
 	return nil
 }
 
-// RestartService starts a service that was installed.
-func (p *Packages) RestartService(name string) error {
-	//  Use --full-restart to stop the service and restart it
-	out, err := p.ssh.Execf(fmt.Sprintf(`DEBIAN_FRONTEND=noninteractive service %s restart`, name))
-	if err != nil {
-		return errors.Wrap(err, "error on service start")
-	}
-
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		text := scanner.Text()
-		p.log.Infof("ssh> %s", text)
-	}
-	if err := scanner.Err(); err != nil {
-		p.log.Warn("reading stdout:", err)
-	}
-
-	return nil
+// aptGet returns the apt-get command with args, without prompts
+func aptGet(args ...string) *ssh.Command {
+	return ssh.Cmd("apt-get", args...).Env("DEBIAN_FRONTEND", "noninteractive")
 }
@@ -0,0 +1,289 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+
+	cryptossh "golang.org/x/crypto/ssh"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// verify dnf implements interface for package managers
+var _ Manager = &Dnf{}
+
+// Dnf manages packages with dnf or yum and rpm, for fedora, rocky, rhel and
+// centos. Holds use the versionlock plugin, python3-dnf-plugin-versionlock or
+// yum-plugin-versionlock must be installed to hold packages.
+type Dnf struct {
+	// log is the logger
+	log *zap.SugaredLogger
+	// ssh client to invoke package commands on
+	ssh *ssh.Client
+	// manifest is the manifest for the host
+	manifest *manifest.Manifest
+	// cmd is dnf or yum
+	cmd manifest.PackageManager
+}
+
+// NewDnf creates a new dnf package manager, cmd is dnf or yum
+func NewDnf(log *zap.SugaredLogger, m *manifest.Manifest, ssh *ssh.Client, cmd manifest.PackageManager) *Dnf {
+	return &Dnf{
+		log:      log,
+		ssh:      ssh,
+		manifest: m,
+		cmd:      cmd,
+	}
+}
+
+// Name returns dnf or yum
+func (d *Dnf) Name() manifest.PackageManager {
+	return d.cmd
+}
+
+// Update refreshes the repository metadata cache
+func (d *Dnf) Update() error {
+	_, err := d.ssh.Stream(ssh.Cmd(string(d.cmd), "makecache", "-y"))
+	if err != nil {
+		return errors.Wrapf(err, "error on %s makecache", d.cmd)
+	}
+	return nil
+}
+
+// Query queries installed packages with rpm. Versions are formatted like
+// dnf, [epoch:]version-release, the epoch is omitted when not set.
+func (d *Dnf) Query() (map[string]manifest.Package, error) {
+	if len(d.manifest.Packages) == 0 {
+		return nil, errors.New("no packages provided to query")
+	}
+	// rpm exits with the number of packages not installed, and prints
+	// package <name> is not installed for each
+	out, err := d.ssh.Exec(ssh.Cmd("rpm", append([]string{"-q", "--queryformat",
+		`%{NAME},%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE},installed\n`},
+		packageNames(d.manifest.Packages)...)...))
+	if err != nil {
+		var exitErr *cryptossh.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, errors.Wrap(err, "error on rpm -q")
+		}
+		d.log.Infof("rpm -q returned %d, packages not installed, this is ok", exitErr.ExitStatus())
+	}
+	return parseQuery(out), nil
+}
+
+// Policy lists installed and available versions with dnf list --showduplicates.
+// The candidate is the highest available version.
+func (d *Dnf) Policy() (map[string]Policy, error) {
+	if len(d.manifest.Packages) == 0 {
+		return nil, errors.New("no packages provided to query policy")
+	}
+	// dnf list exits 1 when none of the packages are found
+	out, err := d.ssh.Exec(ssh.Cmd(string(d.cmd), append([]string{"list", "--showduplicates"},
+		packageNames(d.manifest.Packages)...)...))
+	if err != nil {
+		var exitErr *cryptossh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+			return nil, errors.Wrapf(err, "error on %s list", d.cmd)
+		}
+	}
+	return parseDnfList(out), nil
+}
+
+// Install installs packages, a concrete version is installed as name-version.
+// dnf downgrades when the version is older than the installed version, yum
+// does not.
+func (d *Dnf) Install(pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages provided to install")
+	}
+	specs := make([]string, 0, len(pkgs))
+	for i := range pkgs {
+		if HasVersionConstraint(pkgs[i].Version) {
+			specs = append(specs, fmt.Sprintf("%s-%s", pkgs[i].Name, pkgs[i].Version))
+		} else {
+			specs = append(specs, pkgs[i].Name)
+		}
+	}
+	_, err := d.ssh.Stream(ssh.Cmd(string(d.cmd), append([]string{"install", "-y"}, specs...)...))
+	if err != nil {
+		return errors.Wrapf(err, "error on %s install", d.cmd)
+	}
+	return nil
+}
+
+// Remove removes packages. rpm has no purge, configuration files that were
+// modified are saved as .rpmsave when removed.
+func (d *Dnf) Remove(purge bool, pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages provided to remove")
+	}
+	names := packageNames(pkgs)
+	d.log.Infof("removing packages (purge? %v, not supported by %s), %s", purge, d.cmd, strings.Join(names, " "))
+	_, err := d.ssh.Stream(ssh.Cmd(string(d.cmd), append([]string{"remove", "-y"}, names...)...))
+	if err != nil {
+		return errors.Wrapf(err, "error on %s remove", d.cmd)
+	}
+	return nil
+}
+
+// Autoremove removes packages installed as dependencies that are no longer
+// needed, rpm has no configuration to purge
+func (d *Dnf) Autoremove(_ bool) error {
+	_, err := d.ssh.Stream(ssh.Cmd(string(d.cmd), "autoremove", "-y"))
+	if err != nil {
+		return errors.Wrapf(err, "error on %s autoremove", d.cmd)
+	}
+	return nil
+}
+
+// Held returns packages locked with the versionlock plugin. No package is
+// held when the plugin is not installed, it is only needed to hold packages.
+func (d *Dnf) Held() (map[string]bool, error) {
+	out, err := d.ssh.Exec(ssh.Cmd(string(d.cmd), "versionlock", "list"))
+	if err != nil {
+		if versionlockMissing(out) {
+			d.log.Infof("%s versionlock plugin is not installed, no package is held", d.cmd)
+			return make(map[string]bool), nil
+		}
+		return nil, errors.Wrapf(err, "error on %s versionlock list", d.cmd)
+	}
+	return parseVersionlock(out), nil
+}
+
+// Hold locks packages at the installed version with the versionlock plugin
+func (d *Dnf) Hold(pkgs ...manifest.Package) error {
+	return d.versionlock("add", pkgs...)
+}
+
+// Unhold removes version locks of packages
+func (d *Dnf) Unhold(pkgs ...manifest.Package) error {
+	return d.versionlock("delete", pkgs...)
+}
+
+// versionlock runs versionlock add or delete for pkgs
+func (d *Dnf) versionlock(action string, pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.Errorf("no packages provided to versionlock %s", action)
+	}
+	_, err := d.ssh.Stream(ssh.Cmd(string(d.cmd), append([]string{"versionlock", action},
+		packageNames(pkgs)...)...))
+	if err != nil {
+		return errors.Wrapf(err, "error on %s versionlock %s", d.cmd, action)
+	}
+	return nil
+}
+
+// parseQuery parses name,version,status lines, other lines are ignored
+func parseQuery(out []byte) map[string]manifest.Package {
+	pkglist := make(map[string]manifest.Package)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+		if len(parts) != 3 {
+			continue
+		}
+		pkglist[parts[0]] = manifest.Package{Name: parts[0], Version: parts[1], Status: parts[2]}
+	}
+	return pkglist
+}
+
+// parseDnfList parses the output of dnf list --showduplicates.
+//
+// example:
+//
+//	Installed Packages
+//	nginx.x86_64                 1:1.20.1-14.el9_2.1               @appstream
+//	Available Packages
+//	nginx.x86_64                 1:1.20.1-14.el9                   appstream
+//	nginx.x86_64                 1:1.20.1-14.el9_2.1               appstream
+func parseDnfList(out []byte) map[string]Policy {
+	policies := make(map[string]Policy)
+	installed := false
+	carry := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		switch text {
+		case "Installed Packages":
+			installed = true
+			continue
+		case "Available Packages":
+			installed = false
+			continue
+		}
+		fields := strings.Fields(carry + " " + text)
+		carry = ""
+		if len(fields) == 1 && strings.Contains(fields[0], ".") {
+			// long package names are printed on their own line
+			carry = fields[0]
+			continue
+		}
+		if len(fields) != 3 {
+			continue
+		}
+		i := strings.LastIndex(fields[0], ".")
+		if i == -1 {
+			continue
+		}
+		name, version := fields[0][:i], fields[1]
+		policy := policies[name]
+		policy.Name = name
+		if installed {
+			policy.Installed = version
+		} else if !slices.Contains(policy.Versions, version) {
+			policy.Versions = append(policy.Versions, version)
+		}
+		policies[name] = policy
+	}
+	for name, policy := range policies {
+		policy.Candidate = highest(policy.Versions)
+		policies[name] = policy
+	}
+	return policies
+}
+
+// versionlockMissing returns true when the output of versionlock list is the
+// error of dnf or yum without the versionlock plugin.
+//
+// example:
+//
+//	No such command: versionlock. Please use /usr/bin/dnf --help
+//	Unknown argument "versionlock" for command "dnf5".
+func versionlockMissing(out []byte) bool {
+	return bytes.Contains(out, []byte("No such command: versionlock")) ||
+		bytes.Contains(out, []byte(`Unknown argument "versionlock"`))
+}
+
+// parseVersionlock parses the output of versionlock list, dnf prints
+// name-[epoch:]version-release.*, yum prints epoch:name-version-release.*
+func parseVersionlock(out []byte) map[string]bool {
+	held := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		text := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ".*")
+		if text == "" || strings.Contains(text, " ") {
+			continue
+		}
+		i := strings.LastIndex(text, "-")
+		if i == -1 {
+			continue
+		}
+		j := strings.LastIndex(text[:i], "-")
+		if j == -1 {
+			continue
+		}
+		name := text[:j]
+		if k := strings.Index(name, ":"); k != -1 {
+			name = name[k+1:]
+		}
+		held[name] = true
+	}
+	return held
+}
@@ -0,0 +1,61 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// Held returns the names of packages held with apt-mark hold
+func (p *Packages) Held() (map[string]bool, error) {
+	out, err := p.ssh.Exec(ssh.Cmd("apt-mark", "showhold"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error on apt-mark showhold")
+	}
+	return parseHeld(out), nil
+}
+
+// Hold holds packages with apt-mark hold, held packages are not upgraded
+// by apt-get upgrade
+func (p *Packages) Hold(pkgs ...manifest.Package) error {
+	return p.mark("hold", pkgs...)
+}
+
+// Unhold removes the hold of packages with apt-mark unhold
+func (p *Packages) Unhold(pkgs ...manifest.Package) error {
+	return p.mark("unhold", pkgs...)
+}
+
+// mark runs apt-mark with hold or unhold for pkgs
+func (p *Packages) mark(action string, pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.Errorf("no packages provided to %s", action)
+	}
+	names := make([]string, 0, len(pkgs))
+	for i := range pkgs {
+		names = append(names, pkgs[i].Name)
+	}
+	out, err := p.ssh.Exec(ssh.Cmd("apt-mark", append([]string{action}, names...)...))
+	if err != nil {
+		return errors.Wrapf(err, "error on apt-mark %s", action)
+	}
+	p.log.Infof("apt-mark %s %s, out: '%s'", action, strings.Join(names, " "), out)
+	return nil
+}
+
+// parseHeld parses the output of apt-mark showhold, one package per line
+func parseHeld(out []byte) map[string]bool {
+	held := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			held[name] = true
+		}
+	}
+	return held
+}
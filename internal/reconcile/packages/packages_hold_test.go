@@ -0,0 +1,13 @@
+package packages
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestParseHeld tests parsing apt-mark showhold output
+func TestParseHeld(t *testing.T) {
+	held := parseHeld([]byte("nginx\nphp8.2-fpm\n\n"))
+	assert.DeepEqual(t, held, map[string]bool{"nginx": true, "php8.2-fpm": true})
+}
@@ -0,0 +1,123 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// Manager manages packages on a remote system over ssh with the package
+// manager of the system, like apt, dnf, apk or pacman.
+//
+// Versions reported by managers are compared like debian versions, see
+// debversion. The formats of rpm, apk and pacman versions are close enough,
+// [epoch:]version-release, for constraints like >= 1.22 to work.
+type Manager interface {
+	// Name returns the name of the package manager
+	Name() manifest.PackageManager
+	// Update updates the package repository metadata
+	Update() error
+	// Query returns the installed manifest packages with their versions and status
+	Query() (map[string]manifest.Package, error)
+	// Policy returns installed, candidate and available versions of the manifest packages
+	Policy() (map[string]Policy, error)
+	// Install installs packages, pinned to the version when a concrete version is provided
+	Install(pkgs ...manifest.Package) error
+	// Remove removes packages, purge removes configuration too
+	Remove(purge bool, pkgs ...manifest.Package) error
+	// Autoremove removes dependencies no longer needed by installed packages,
+	// purge removes their configuration too
+	Autoremove(purge bool) error
+	// Held returns the names of held packages
+	Held() (map[string]bool, error)
+	// Hold holds packages at the installed version
+	Hold(pkgs ...manifest.Package) error
+	// Unhold removes holds of packages
+	Unhold(pkgs ...manifest.Package) error
+}
+
+// New creates the package manager for a host. The package manager in the
+// manifest is used when set, otherwise it is detected from the content of
+// /etc/os-release. Without os-release apt is used.
+func New(log *zap.SugaredLogger, m *manifest.Manifest, sshClient *ssh.Client, osRelease []byte) (Manager, error) {
+	name := m.PackageManager
+	if name == "" {
+		var err error
+		name, err = Detect(osRelease)
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("using package manager %s for %s", name, m.ID)
+	switch name {
+	case manifest.PackageManagerApt:
+		return NewPackages(log, m, sshClient), nil
+	case manifest.PackageManagerDnf, manifest.PackageManagerYum:
+		return NewDnf(log, m, sshClient, name), nil
+	case manifest.PackageManagerApk:
+		return NewApk(log, m, sshClient), nil
+	case manifest.PackageManagerPacman:
+		return NewPacman(log, m, sshClient), nil
+	}
+	return nil, errors.Errorf("unknown package manager %s", name)
+}
+
+// Detect returns the package manager for the os described by the content of
+// /etc/os-release. The ID is matched first, then ID_LIKE.
+func Detect(osRelease []byte) (manifest.PackageManager, error) {
+	if len(bytes.TrimSpace(osRelease)) == 0 {
+		return manifest.PackageManagerApt, nil
+	}
+	release := ParseOSRelease(osRelease)
+	ids := append([]string{release["ID"]}, strings.Fields(release["ID_LIKE"])...)
+	for _, id := range ids {
+		switch id {
+		case "debian", "ubuntu":
+			return manifest.PackageManagerApt, nil
+		case "rhel", "centos", "fedora", "rocky", "almalinux":
+			// rhel and centos 7 and earlier only have yum
+			major, err := strconv.Atoi(strings.Split(release["VERSION_ID"], ".")[0])
+			if err == nil && major < 8 && id != "fedora" {
+				return manifest.PackageManagerYum, nil
+			}
+			return manifest.PackageManagerDnf, nil
+		case "alpine":
+			return manifest.PackageManagerApk, nil
+		case "arch":
+			return manifest.PackageManagerPacman, nil
+		}
+	}
+	return "", errors.Errorf("unable to detect package manager for os %s, set package_manager in the manifest",
+		release["ID"])
+}
+
+// ParseOSRelease parses the content of /etc/os-release into a map of keys to
+// unquoted values
+func ParseOSRelease(out []byte) map[string]string {
+	release := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		release[key] = strings.Trim(value, `"'`)
+	}
+	return release
+}
+
+// packageNames returns the names of pkgs
+func packageNames(pkgs []manifest.Package) []string {
+	names := make([]string, 0, len(pkgs))
+	for i := range pkgs {
+		names = append(names, pkgs[i].Name)
+	}
+	return names
+}
@@ -0,0 +1,135 @@
+package packages
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+)
+
+// TestDetect tests detecting the package manager from /etc/os-release
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		osRelease string
+		want      manifest.PackageManager
+		wantErr   string
+	}{
+		{osRelease: "", want: manifest.PackageManagerApt},
+		{osRelease: "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\nVERSION_ID=\"12\"\n",
+			want: manifest.PackageManagerApt},
+		{osRelease: "ID=linuxmint\nID_LIKE=\"ubuntu debian\"\n", want: manifest.PackageManagerApt},
+		{osRelease: "ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nVERSION_ID=\"9.2\"\n",
+			want: manifest.PackageManagerDnf},
+		{osRelease: "ID=\"centos\"\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"7\"\n", want: manifest.PackageManagerYum},
+		{osRelease: "ID=fedora\nVERSION_ID=39\n", want: manifest.PackageManagerDnf},
+		{osRelease: "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.18.4\n", want: manifest.PackageManagerApk},
+		{osRelease: "NAME=\"Arch Linux\"\nID=arch\n", want: manifest.PackageManagerPacman},
+		{osRelease: "ID=nixos\n", wantErr: "unable to detect package manager for os nixos"},
+	}
+	for _, tt := range tests {
+		got, err := Detect([]byte(tt.osRelease))
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr)
+			continue
+		}
+		assert.NilError(t, err, tt.osRelease)
+		assert.Equal(t, got, tt.want, tt.osRelease)
+	}
+}
+
+// TestParseDnfList tests parsing dnf list --showduplicates output
+func TestParseDnfList(t *testing.T) {
+	out := []byte(`Last metadata expiration check: 0:12:42 ago on Mon 16 Oct 2023 10:00:00 AM UTC.
+Installed Packages
+nginx.x86_64                 1:1.20.1-14.el9_2.1               @appstream
+Available Packages
+nginx.x86_64                 1:1.20.1-14.el9                   appstream
+nginx.x86_64                 1:1.20.1-14.el9_2.1               appstream
+python3-dnf-plugin-versionlock.noarch
+                             4.3.0-5.el9                       appstream
+`)
+	policies := parseDnfList(out)
+	nginx := policies["nginx"]
+	assert.Equal(t, nginx.Installed, "1:1.20.1-14.el9_2.1")
+	assert.Equal(t, nginx.Candidate, "1:1.20.1-14.el9_2.1")
+	assert.DeepEqual(t, nginx.Versions, []string{"1:1.20.1-14.el9", "1:1.20.1-14.el9_2.1"})
+	assert.Equal(t, policies["python3-dnf-plugin-versionlock"].Candidate, "4.3.0-5.el9")
+}
+
+// TestParseDpkgQuery tests parsing dpkg-query output, lines that are not
+// name,version,status are ignored
+func TestParseDpkgQuery(t *testing.T) {
+	pkglist := parseDpkgQuery([]byte("nginx,1.22.1-9,installed\n" +
+		"dpkg-query: no packages found matching php8.2-fpm\nphp8.2-common,,config-files\n"))
+	assert.Equal(t, len(pkglist), 2)
+	assert.Equal(t, pkglist["nginx"].Version, "1.22.1-9")
+	assert.Equal(t, pkglist["php8.2-common"].Status, "config-files")
+}
+
+// TestParseVersionlock tests parsing dnf and yum versionlock list output
+func TestParseVersionlock(t *testing.T) {
+	held := parseVersionlock([]byte("Last metadata expiration check: 0:00:01 ago.\n" +
+		"nginx-1:1.20.1-14.el9_2.1.*\nphp-fpm-8.0.30-1.el9_2.*\n0:curl-7.29.0-59.el7.*\n"))
+	assert.DeepEqual(t, held, map[string]bool{"nginx": true, "php-fpm": true, "curl": true})
+}
+
+// TestVersionlockMissing tests detecting dnf and yum without the versionlock
+// plugin from the output of versionlock list
+func TestVersionlockMissing(t *testing.T) {
+	tests := []struct {
+		out  string
+		want bool
+	}{
+		{out: "No such command: versionlock. Please use /usr/bin/dnf --help\nIt could be a DNF plugin command, " +
+			"try: \"dnf install 'dnf-command(versionlock)'\"\n", want: true},
+		{out: "Loaded plugins: fastestmirror\nNo such command: versionlock. Please use /usr/bin/yum --help\n",
+			want: true},
+		{out: "Unknown argument \"versionlock\" for command \"dnf5\". Add \"--help\" for more information.\n",
+			want: true},
+		{out: "Error: Failed to download metadata for repo 'appstream'\n", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, versionlockMissing([]byte(tt.out)), tt.want, tt.out)
+	}
+}
+
+// TestParseApkPolicy tests parsing apk policy output
+func TestParseApkPolicy(t *testing.T) {
+	out := []byte(`nginx policy:
+  1.24.0-r6:
+    https://dl-cdn.alpinelinux.org/alpine/v3.18/main
+  1.24.0-r7:
+    lib/apk/db/installed
+    https://dl-cdn.alpinelinux.org/alpine/v3.18/main
+curl policy:
+  8.4.0-r0:
+    https://dl-cdn.alpinelinux.org/alpine/v3.18/main
+`)
+	policies := parseApkPolicy(out)
+	assert.Equal(t, policies["nginx"].Installed, "1.24.0-r7")
+	assert.Equal(t, policies["nginx"].Candidate, "1.24.0-r7")
+	assert.Equal(t, policies["curl"].Installed, "")
+	assert.DeepEqual(t, policies["curl"].Versions, []string{"8.4.0-r0"})
+
+	held := parseApkWorld([]byte("alpine-base\nnginx=1.24.0-r7\ncurl~8.4\n"))
+	assert.DeepEqual(t, held, map[string]bool{"nginx": true})
+}
+
+// TestParsePacmanInfo tests parsing pacman -Si output
+func TestParsePacmanInfo(t *testing.T) {
+	out := []byte(`Repository      : extra
+Name            : nginx
+Version         : 1.24.0-1
+Description     : Lightweight HTTP server and IMAP/POP3 proxy server
+
+Repository      : extra
+Name            : curl
+Version         : 8.4.0-2
+error: package 'nosuchpackage' was not found
+`)
+	policies := parsePacmanInfo(out)
+	assert.Equal(t, len(policies), 2)
+	assert.Equal(t, policies["nginx"].Candidate, "1.24.0-1")
+	assert.DeepEqual(t, policies["curl"].Versions, []string{"8.4.0-2"})
+}
@@ -0,0 +1,197 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	cryptossh "golang.org/x/crypto/ssh"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// verify pacman implements interface for package managers
+var _ Manager = &Pacman{}
+
+// Pacman manages packages with pacman, for arch. Repositories only have one
+// version of a package, so only the candidate version can be installed.
+// Holds are IgnorePkg in pacman.conf, they are reported but not managed.
+type Pacman struct {
+	// log is the logger
+	log *zap.SugaredLogger
+	// ssh client to invoke package commands on
+	ssh *ssh.Client
+	// manifest is the manifest for the host
+	manifest *manifest.Manifest
+}
+
+// NewPacman creates a new pacman package manager
+func NewPacman(log *zap.SugaredLogger, m *manifest.Manifest, ssh *ssh.Client) *Pacman {
+	return &Pacman{
+		log:      log,
+		ssh:      ssh,
+		manifest: m,
+	}
+}
+
+// Name returns pacman
+func (p *Pacman) Name() manifest.PackageManager {
+	return manifest.PackageManagerPacman
+}
+
+// Update synchronizes the package databases
+func (p *Pacman) Update() error {
+	_, err := p.ssh.Stream(ssh.Cmd("pacman", "-Sy", "--noconfirm"))
+	if err != nil {
+		return errors.Wrap(err, "error on pacman -Sy")
+	}
+	return nil
+}
+
+// Query queries installed packages with pacman -Q
+func (p *Pacman) Query() (map[string]manifest.Package, error) {
+	if len(p.manifest.Packages) == 0 {
+		return nil, errors.New("no packages provided to query")
+	}
+	// pacman -Q exits 1 and prints error: package 'name' was not found
+	// for packages that are not installed
+	out, err := p.ssh.Exec(ssh.Cmd("pacman", append([]string{"-Q"}, packageNames(p.manifest.Packages)...)...))
+	if err != nil {
+		var exitErr *cryptossh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+			return nil, errors.Wrap(err, "error on pacman -Q")
+		}
+	}
+	pkglist := make(map[string]manifest.Package)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] == "error:" {
+			continue
+		}
+		pkglist[fields[0]] = manifest.Package{Name: fields[0], Version: fields[1], Status: "installed"}
+	}
+	return pkglist, nil
+}
+
+// Policy returns the repository version of packages with pacman -Si
+func (p *Pacman) Policy() (map[string]Policy, error) {
+	if len(p.manifest.Packages) == 0 {
+		return nil, errors.New("no packages provided to query policy")
+	}
+	out, err := p.ssh.Exec(ssh.Cmd("pacman", append([]string{"-Si"}, packageNames(p.manifest.Packages)...)...))
+	if err != nil {
+		var exitErr *cryptossh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+			return nil, errors.Wrap(err, "error on pacman -Si")
+		}
+	}
+	return parsePacmanInfo(out), nil
+}
+
+// Install installs packages with the repository version. Resolve only
+// resolves to the repository version, so versions are not passed to pacman.
+func (p *Pacman) Install(pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages provided to install")
+	}
+	_, err := p.ssh.Stream(ssh.Cmd("pacman", append([]string{"-S", "--noconfirm", "--needed"},
+		packageNames(pkgs)...)...))
+	if err != nil {
+		return errors.Wrap(err, "error on pacman -S")
+	}
+	return nil
+}
+
+// Remove removes packages, purge also removes backup configuration files
+func (p *Pacman) Remove(purge bool, pkgs ...manifest.Package) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages provided to remove")
+	}
+	names := packageNames(pkgs)
+	p.log.Infof("removing packages (purge? %v), %s", purge, strings.Join(names, " "))
+	flags := "-R"
+	if purge {
+		flags = "-Rn"
+	}
+	_, err := p.ssh.Stream(ssh.Cmd("pacman", append([]string{flags, "--noconfirm"}, names...)...))
+	if err != nil {
+		return errors.Wrapf(err, "error on pacman %s", flags)
+	}
+	return nil
+}
+
+// Autoremove removes packages installed as dependencies that are no longer
+// needed, pacman -Qdtq lists them and exits 1 when there are none. Purge
+// also removes their backup configuration files.
+func (p *Pacman) Autoremove(purge bool) error {
+	flags := "-Rs"
+	if purge {
+		flags = "-Rns"
+	}
+	_, err := p.ssh.Stream(ssh.Script(`orphans=$(pacman -Qdtq); [ -z "$orphans" ] || pacman "$1" --noconfirm $orphans`,
+		flags))
+	if err != nil {
+		return errors.Wrapf(err, "error on pacman %s of orphaned dependencies", flags)
+	}
+	return nil
+}
+
+// Held returns packages in IgnorePkg of pacman.conf
+func (p *Pacman) Held() (map[string]bool, error) {
+	out, err := p.ssh.Exec(ssh.Cmd("pacman-conf", "IgnorePkg"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error on pacman-conf IgnorePkg")
+	}
+	held := make(map[string]bool)
+	for _, name := range strings.Fields(string(out)) {
+		held[name] = true
+	}
+	return held, nil
+}
+
+// Hold is not supported, add packages to IgnorePkg in pacman.conf
+func (p *Pacman) Hold(pkgs ...manifest.Package) error {
+	return errors.Errorf("pacman does not support holding packages %s, add them to IgnorePkg in pacman.conf",
+		strings.Join(packageNames(pkgs), ", "))
+}
+
+// Unhold is not supported, remove packages from IgnorePkg in pacman.conf
+func (p *Pacman) Unhold(pkgs ...manifest.Package) error {
+	return errors.Errorf("pacman does not support unholding packages %s, remove them from IgnorePkg in pacman.conf",
+		strings.Join(packageNames(pkgs), ", "))
+}
+
+// parsePacmanInfo parses the output of pacman -Si, the version of each
+// package is the candidate and only available version.
+//
+// example:
+//
+//	Repository      : extra
+//	Name            : nginx
+//	Version         : 1.24.0-1
+func parsePacmanInfo(out []byte) map[string]Policy {
+	policies := make(map[string]Policy)
+	name := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "Name":
+			name = value
+		case "Version":
+			if name != "" {
+				policies[name] = Policy{Name: name, Candidate: value, Versions: []string{value}}
+			}
+		}
+	}
+	return policies
+}
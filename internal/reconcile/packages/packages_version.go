@@ -0,0 +1,151 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/debversion"
+	"slack-reconcile-deployments/internal/manifest"
+)
+
+// VersionLatest is the version used in manifests to install the candidate
+// version, the version apt-get install would choose.
+const VersionLatest = manifest.VersionLatest
+
+// Policy is the apt policy of one package. See apt-cache policy.
+type Policy struct {
+	// Name is the name of the package
+	Name string
+	// Installed is the installed version, empty when not installed
+	Installed string
+	// Candidate is the version apt-get install would choose, empty when none
+	Candidate string
+	// Versions are the versions available, in apt preference order
+	Versions []string
+}
+
+// HasVersionConstraint returns true when version is a version constraint, instead
+// of latest or empty
+func HasVersionConstraint(version string) bool {
+	return version != "" && version != VersionLatest
+}
+
+// MatchVersion returns true when version satisfies the desired version
+// constraint, see debversion.ParseConstraint. For example 8.2 matches
+// 8.2.7-1~deb12u1 but does not match 8.21, and >= 1.22, << 2.0 matches 1.24.0-1.
+// A desired constraint that does not parse never matches.
+func MatchVersion(desired, version string) bool {
+	c, err := debversion.ParseConstraint(desired)
+	if err != nil {
+		return false
+	}
+	return c.Match(version)
+}
+
+// Resolve returns the concrete version to install for desired. Latest
+// resolves to the candidate version. A constraint resolves to the candidate
+// when it satisfies the constraint, otherwise to the highest available version
+// satisfying the constraint.
+func (p *Policy) Resolve(desired string) (string, error) {
+	if !HasVersionConstraint(desired) {
+		if p.Candidate == "" {
+			return "", errors.Errorf("package %s has no candidate version in the apt cache", p.Name)
+		}
+		return p.Candidate, nil
+	}
+	c, err := debversion.ParseConstraint(desired)
+	if err != nil {
+		return "", errors.Wrapf(err, "package %s", p.Name)
+	}
+	if p.Candidate != "" && c.Match(p.Candidate) {
+		return p.Candidate, nil
+	}
+	resolved := ""
+	for _, version := range p.Versions {
+		if c.Match(version) && (resolved == "" || debversion.Compare(version, resolved) > 0) {
+			resolved = version
+		}
+	}
+	if resolved == "" {
+		return "", errors.Errorf("version %s of package %s is not available in the apt cache, available: %s",
+			desired, p.Name, strings.Join(p.Versions, ", "))
+	}
+	return resolved, nil
+}
+
+// highest returns the highest of versions, empty when there are no versions
+func highest(versions []string) string {
+	result := ""
+	for _, version := range versions {
+		if result == "" || debversion.Compare(version, result) > 0 {
+			result = version
+		}
+	}
+	return result
+}
+
+// parsePolicy parses the output of apt-cache policy for one or more packages.
+//
+// example:
+//
+//	nginx:
+//	  Installed: (none)
+//	  Candidate: 1.22.1-9
+//	  Version table:
+//	 *** 1.22.1-9 500
+//	        500 http://deb.debian.org/debian bookworm/main amd64 Packages
+//	        100 /var/lib/dpkg/status
+func parsePolicy(out []byte) map[string]Policy {
+	policies := make(map[string]Policy)
+	var current *Policy
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		switch {
+		case trimmed == "":
+			continue
+		case !strings.HasPrefix(text, " ") && strings.HasSuffix(text, ":"):
+			// each package starts with the package name at the beginning of the line
+			if current != nil {
+				policies[current.Name] = *current
+			}
+			current = &Policy{Name: strings.TrimSuffix(text, ":")}
+		case current == nil:
+			// warnings, like N: Unable to locate package
+			continue
+		case strings.HasPrefix(trimmed, "Installed:"):
+			current.Installed = policyVersion(strings.TrimPrefix(trimmed, "Installed:"))
+		case strings.HasPrefix(trimmed, "Candidate:"):
+			current.Candidate = policyVersion(strings.TrimPrefix(trimmed, "Candidate:"))
+		default:
+			// version table rows are a version and a priority, the installed
+			// version is marked with ***. Source rows are a priority and a source.
+			fields := strings.Fields(strings.TrimPrefix(trimmed, "***"))
+			if len(fields) != 2 {
+				continue
+			}
+			if _, err := strconv.Atoi(fields[1]); err != nil {
+				continue
+			}
+			current.Versions = append(current.Versions, fields[0])
+		}
+	}
+	if current != nil {
+		policies[current.Name] = *current
+	}
+	return policies
+}
+
+// policyVersion returns a version from apt-cache policy, (none) is empty
+func policyVersion(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "(none)" {
+		return ""
+	}
+	return s
+}
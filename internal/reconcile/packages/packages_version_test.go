@@ -0,0 +1,102 @@
+package packages
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestParsePolicy tests parsing apt-cache policy output for multiple packages
+func TestParsePolicy(t *testing.T) {
+	out := []byte(`nginx:
+  Installed: 1.22.1-9
+  Candidate: 1.22.1-9+deb12u1
+  Version table:
+     1.22.1-9+deb12u1 500
+        500 http://deb.debian.org/debian-security bookworm-security/main amd64 Packages
+ *** 1.22.1-9 500
+        500 http://deb.debian.org/debian bookworm/main amd64 Packages
+        100 /var/lib/dpkg/status
+N: Unable to locate package nosuchpackage
+php8.2-fpm:
+  Installed: (none)
+  Candidate: 8.2.7-1~deb12u1
+  Version table:
+     8.2.7-1~deb12u1 500
+        500 http://deb.debian.org/debian bookworm/main amd64 Packages
+`)
+	policies := parsePolicy(out)
+	assert.Equal(t, len(policies), 2)
+
+	nginx := policies["nginx"]
+	assert.Equal(t, nginx.Installed, "1.22.1-9")
+	assert.Equal(t, nginx.Candidate, "1.22.1-9+deb12u1")
+	assert.DeepEqual(t, nginx.Versions, []string{"1.22.1-9+deb12u1", "1.22.1-9"})
+
+	php := policies["php8.2-fpm"]
+	assert.Equal(t, php.Installed, "")
+	assert.Equal(t, php.Candidate, "8.2.7-1~deb12u1")
+}
+
+// TestMatchVersion tests matching desired versions on version boundaries
+func TestMatchVersion(t *testing.T) {
+	tests := []struct {
+		desired, version string
+		want             bool
+	}{
+		{desired: "8.2", version: "8.2", want: true},
+		{desired: "8.2", version: "8.2.7-1~deb12u1", want: true},
+		{desired: "8.2", version: "8.21", want: false},
+		{desired: "1.22.1", version: "1.22.1-9+deb12u1", want: true},
+		{desired: "1.22.1-9", version: "1.22.1-9+deb12u1", want: false},
+		{desired: "1.22.1-9", version: "1.22.1-90", want: false},
+		{desired: "7.4", version: "8.2.7", want: false},
+		{desired: "~ 8.2", version: "8.2.7-1~deb12u1", want: true},
+		{desired: "~ 8.2", version: "8.3.0-1", want: false},
+		{desired: "9.18", version: "1:9.18.19-1~deb12u1", want: true},
+		{desired: ">= 1.22", version: "1.22.1-9", want: true},
+		{desired: ">= 1.22", version: "1.18.0-6.1+deb11u3", want: false},
+		{desired: ">= 1.22, << 2.0", version: "1.24.0-1", want: true},
+		{desired: ">= 1.22, << 2.0", version: "2.0.1-1", want: false},
+		{desired: "<< 2.0", version: "2.0~rc1-1", want: true},
+		{desired: "> 2.0", version: "2.1", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, MatchVersion(tt.desired, tt.version), tt.want, "%s %s", tt.desired, tt.version)
+	}
+}
+
+// TestPolicyResolve tests resolving desired versions to concrete versions
+func TestPolicyResolve(t *testing.T) {
+	policy := &Policy{
+		Name:      "nginx",
+		Candidate: "1.22.1-9+deb12u1",
+		Versions:  []string{"1.22.1-9+deb12u1", "1.22.1-9", "1.18.0-6.1+deb11u3"},
+	}
+	tests := []struct {
+		desired string
+		want    string
+		wantErr string
+	}{
+		{desired: "latest", want: "1.22.1-9+deb12u1"},
+		{desired: "", want: "1.22.1-9+deb12u1"},
+		{desired: "1.22.1-9", want: "1.22.1-9"},
+		{desired: "1.18", want: "1.18.0-6.1+deb11u3"},
+		{desired: ">= 1.18", want: "1.22.1-9+deb12u1"},
+		{desired: "<< 1.22.1-9+deb12u1", want: "1.22.1-9"},
+		{desired: ">> 1.18, <= 1.22.1-9", want: "1.22.1-9"},
+		{desired: "1.24", wantErr: "version 1.24 of package nginx is not available"},
+	}
+	for _, tt := range tests {
+		got, err := policy.Resolve(tt.desired)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr)
+			continue
+		}
+		assert.NilError(t, err, tt.desired)
+		assert.Equal(t, got, tt.want, tt.desired)
+	}
+
+	_, err := (&Policy{Name: "nosuchpackage"}).Resolve("latest")
+	assert.ErrorContains(t, err, "has no candidate version")
+}
@@ -2,7 +2,7 @@ package reconcile
 
 import (
 	"context"
-	"strings"
+	"io"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -16,7 +16,10 @@ import (
 	"slack-reconcile-deployments/internal/reconcile/backend/linode"
 	slackbackend "slack-reconcile-deployments/internal/reconcile/backend/slack"
 	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/lock"
 	"slack-reconcile-deployments/internal/reconcile/packages"
+	"slack-reconcile-deployments/internal/reconcile/services"
+	"slack-reconcile-deployments/internal/reconcile/state"
 	"slack-reconcile-deployments/internal/ssh"
 )
 
@@ -31,12 +34,46 @@ const (
 	Remove = Operation("remove")
 	// Purge is just like Remove but removes configuration in addition to packages
 	Purge = Operation("purge")
+	// DryRun operation computes the changes reconcile would make and writes
+	// them as a plan, without changing the target
+	DryRun = Operation("dry-run")
+	// DetectDrift operation compares the desired state with the target and
+	// reports drift, without changing the target
+	DetectDrift = Operation("drift")
+	// Rollback operation restores the files backed up by a run, see WithRollback
+	Rollback = Operation("rollback")
 )
 
-// Run runs reconcile with given provider and path to manifest
-func Run(ctx context.Context, log *zap.SugaredLogger, m *manifest.Manifest,
-	op Operation, options ...func(reconciler backend.ProviderBackendReconciler)) error {
+// Run runs reconcile with given provider and path to manifest.
+// Human-readable output, like the plan for a dry run, is written to w, with
+// the output of long running remote commands streamed live to w.
+// A report is always returned, including when the run fails.
+func Run(ctx context.Context, log *zap.SugaredLogger, w io.Writer, m *manifest.Manifest,
+	op Operation, options ...func(reconciler backend.ProviderBackendReconciler)) (*Report, error) {
+	report := NewReport(m, op)
+	report.RunID = RunIDFromContext(ctx)
+	if report.RunID == "" {
+		report.RunID = NewRunID()
+	}
+	err := run(ctx, log, w, m, op, report, options...)
+	report.finish(err)
+	return report, err
+}
+
+// run runs reconcile for Run, recording results in report
+func run(ctx context.Context, log *zap.SugaredLogger, w io.Writer, m *manifest.Manifest,
+	op Operation, report *Report, options ...func(reconciler backend.ProviderBackendReconciler)) error {
 	start := time.Now()
+	// fail before the backend runs, a host is not created for nothing
+	e := escalation(ctx, m)
+	if err := e.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid escalation for %s", m.ID)
+	}
+	if op == Rollback {
+		if err := ValidateRunID(rollbackFromContext(ctx)); err != nil {
+			return err
+		}
+	}
 	var err error
 	var be backend.ProviderBackendReconciler
 	switch m.Provider {
@@ -65,20 +102,55 @@ func Run(ctx context.Context, log *zap.SugaredLogger, m *manifest.Manifest,
 	}
 
 	log.Infof("running backend %+v", be)
+	// close the backend when run fails too, a docker container started before
+	// the run failed or was cancelled is removed
+	defer be.Close()
+	stepStart := time.Now()
 	sshClient, err := be.Run(ctx)
+	report.AddStep("backend", stepStart, err)
 	if err != nil {
 		return errors.Wrap(err, "error on provider backend reconcile")
 	}
-	defer be.Close()
+	report.Host = sshClient.Host()
+	// output of long running commands, like installing packages, is written
+	// live to w, prefixed with the manifest id to tell hosts apart
+	sshClient = sshClient.WithOutput(w, m.ID)
+	sshClient = sshClient.WithEscalation(e)
+
+	// lock the target, so concurrent runs do not race on packages and staged files
+	if op.locks() {
+		l := lock.New(m.ID, report.RunID, string(op))
+		stepStart = time.Now()
+		err = lock.Acquire(log, sshClient, l, lockStaleAfterFromContext(ctx))
+		report.AddStep("lock", stepStart, err)
+		if err != nil {
+			return errors.Wrapf(err, "error locking %s", m.ID)
+		}
+		defer func() {
+			// the lock is released after the run was cancelled or timed out too
+			cleanupCtx, cancel := cleanupContext()
+			defer cancel()
+			if err := lock.Release(log, sshClient.WithContext(cleanupCtx), l); err != nil {
+				log.Warnf("unable to release lock on %s: %v", m.ID, err)
+			}
+		}()
+	}
 
 	out, err := sshClient.Execf("cat /etc/os-release")
 	if err != nil {
 		log.Info("warning: unable to get /etc/os-release")
+		out = nil
 	} else {
 		log.Infof("os-release: %s", out)
 	}
 
 	reconciler := New(log, m, sshClient)
+	reconciler.report = report
+	reconciler.osRelease = out
+	reconciler.runID = report.RunID
+	if transactionalFromContext(ctx) {
+		reconciler.tx = newTransaction()
+	}
 
 	switch op {
 	case Reconcile:
@@ -86,9 +158,32 @@ func Run(ctx context.Context, log *zap.SugaredLogger, m *manifest.Manifest,
 			return errors.Wrap(err, "error on reconciler")
 		}
 	case Remove, Purge:
-		if err := reconciler.Remove(ctx, false); err != nil {
+		if err := reconciler.Remove(ctx, op == Purge); err != nil {
 			return errors.Wrap(err, "error on reconciler")
 		}
+	case DryRun:
+		plan, err := reconciler.Plan(ctx)
+		if err != nil {
+			return errors.Wrap(err, "error on reconciler plan")
+		}
+		report.Plan = plan
+		if err := plan.Write(w); err != nil {
+			return errors.Wrap(err, "error writing plan")
+		}
+	case Rollback:
+		report.RollbackRunID = rollbackFromContext(ctx)
+		if err := reconciler.Rollback(ctx, report.RollbackRunID); err != nil {
+			return errors.Wrap(err, "error on reconciler rollback")
+		}
+	case DetectDrift:
+		drift, err := reconciler.Drift(ctx)
+		if err != nil {
+			return errors.Wrap(err, "error on reconciler drift")
+		}
+		report.Drift = drift
+		if err := WriteDrift(w, m, drift); err != nil {
+			return errors.Wrap(err, "error writing drift")
+		}
 	default:
 		return errors.Errorf("unknown reconcile op: %s", op)
 	}
@@ -102,6 +197,17 @@ type ProviderReconciler struct {
 	log      *zap.SugaredLogger
 	manifest *manifest.Manifest
 	ssh      *ssh.Client
+	// report records the results of reconcile
+	report *Report
+	// osRelease is the content of /etc/os-release on the target, used to
+	// detect the package manager
+	osRelease []byte
+	// runID is the id of the run, files are backed up under it
+	runID string
+	// tx records the mutations of reconcile in transactional mode, nil otherwise
+	tx *transaction
+	// ledger is the state ledger read from the target, nil when the target has none
+	ledger *state.Ledger
 }
 
 // New creates a new provide reconciler
@@ -110,145 +216,272 @@ func New(log *zap.SugaredLogger, m *manifest.Manifest, sshClient *ssh.Client) *P
 		log:      log,
 		manifest: m,
 		ssh:      sshClient,
+		report:   NewReport(m, Reconcile),
 	}
 }
 
+// cleanupTimeout bounds cleanup after a run failed, like reverting a
+// transaction or releasing the lock
+const cleanupTimeout = 2 * time.Minute
+
+// cleanupContext returns a context for cleanup bounded by cleanupTimeout.
+// It is not derived from the context of the run, so cleanup runs after the
+// run was cancelled or timed out.
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
+
+// cleanup runs fn with the ssh client bound to a cleanup context
+func (p *ProviderReconciler) cleanup(fn func() error) error {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+	sshClient := p.ssh
+	p.ssh = sshClient.WithContext(ctx)
+	defer func() {
+		p.ssh = sshClient
+	}()
+	return fn()
+}
+
+// Report returns the report of results recorded by the reconciler
+func (p *ProviderReconciler) Report() *Report {
+	return p.report
+}
+
 // Reconcile run reconcile using backend.
-// this function is common to all backends
-func (p *ProviderReconciler) Reconcile(_ context.Context) error {
+// this function is common to all backends.
+// In transactional mode the mutations of a failed reconcile are reverted.
+func (p *ProviderReconciler) Reconcile(ctx context.Context) error {
+	err := p.reconcile(ctx)
+	if err == nil || p.tx == nil {
+		return err
+	}
+	p.log.Infof("reconcile failed on %s, reverting: %v", p.manifest.ID, err)
+	if revertErr := p.cleanup(p.revert); revertErr != nil {
+		return errors.Wrapf(err, "error reverting, %v", revertErr)
+	}
+	return err
+}
+
+// reconcile runs reconcile for Reconcile
+func (p *ProviderReconciler) reconcile(ctx context.Context) error {
 	p.log.Infof("reconcile")
 
 	p.log.Info("spew manifest", spew.Sdump(p.manifest))
-	missing := make([]manifest.Package, 0, len(p.manifest.Packages))
-	pkgs := packages.NewPackages(p.log, p.manifest, p.ssh)
+	if err := p.readLedger(); err != nil {
+		return err
+	}
+	start := time.Now()
+	pkgs, err := p.packageManager()
+	if err != nil {
+		p.report.AddStep("query-packages", start, err)
+		return err
+	}
 	pkglist, err := pkgs.Query()
 	if err != nil {
+		p.report.AddStep("query-packages", start, err)
 		return errors.Wrap(err, "error getting packages from container")
 	}
+	policies, err := pkgs.Policy()
+	if err != nil {
+		p.report.AddStep("query-packages", start, err)
+		return errors.Wrap(err, "error getting package policy from container")
+	}
+	held, err := pkgs.Held()
+	p.report.AddStep("query-packages", start, err)
+	if err != nil {
+		return errors.Wrap(err, "error getting held packages from container")
+	}
 	p.log.Infof("pkgs %d", len(pkglist))
 
-	// diff the desired and actual packages, make a list of packages to install
-	for _, pkgDesired := range p.manifest.Packages {
-		pkgActual, ok := pkglist[pkgDesired.Name]
-		switch {
-		case !ok:
-			p.log.Infof("desired package %s is missing on target %s",
-				pkgDesired.Name, p.manifest.ID)
-			missing = append(missing, manifest.Package{Name: pkgDesired.Name, Version: pkgDesired.Version})
-		case ok && pkgActual.Status != "installed":
-			p.log.Info("desired package %s appears not to be installed, will install status: %s",
-				pkgActual.Name, pkgActual.Status)
-			missing = append(missing, manifest.Package{Name: pkgDesired.Name, Version: pkgDesired.Version})
-		case strings.Compare(pkgDesired.Version, pkgActual.Version) != 0:
-			p.log.Infof("desired package %s version %s does not match actual version %s",
-				pkgDesired.Name, pkgDesired.Version, pkgActual.Version)
-		default:
-			p.log.Infof("desired package %s is ok, installed", pkgDesired.Name)
-		}
-	}
-
-	if len(missing) > 0 {
-		p.log.Infof("%s is missing %d packages, installing", p.manifest.ID, len(missing))
-		if err := pkgs.Update(); err != nil {
+	diff := p.diffPackages(pkglist, policies, held)
+	if len(diff.install) > 0 || len(diff.unavailable) > 0 {
+		p.log.Infof("%s has %d packages to install, updating", p.manifest.ID, len(diff.install))
+		start = time.Now()
+		err := pkgs.Update()
+		p.report.AddStep("update-packages", start, err)
+		if err != nil {
 			return errors.Wrapf(err, "error update packages on %s", p.manifest.ID)
 		}
-		if err := pkgs.Install(missing...); err != nil {
-			return errors.Wrapf(err, "error installing packages on %s", p.manifest.ID)
+		// resolve versions again with the updated apt cache
+		policies, err = pkgs.Policy()
+		if err != nil {
+			return errors.Wrapf(err, "error getting package policy on %s", p.manifest.ID)
+		}
+		diff = p.diffPackages(pkglist, policies, held)
+		if err := diff.err(); err != nil {
+			diff.record(p.report, false)
+			return errors.Wrapf(err, "error resolving package versions on %s", p.manifest.ID)
 		}
 	}
-
-	// there are tests that cover these data values.
-	data := map[string]string{
-		files.FileTemplateKeyLastModifiedDate: time.Now().UTC().Format(time.RFC3339),
+	if len(diff.unhold) > 0 {
+		start = time.Now()
+		err = pkgs.Unhold(diff.unhold...)
+		p.report.AddStep("unhold-packages", start, err)
+		if err != nil {
+			diff.record(p.report, false)
+			return errors.Wrapf(err, "error removing package holds on %s", p.manifest.ID)
+		}
+	}
+	if err := p.removePackages(pkgs, diff); err != nil {
+		diff.record(p.report, false)
+		return err
 	}
-	// copy values from parameters to data map, for used by templates
-	for k, v := range p.manifest.Parameters {
-		data[k] = v
+	if len(diff.install) > 0 {
+		p.log.Infof("%s installing %d packages", p.manifest.ID, len(diff.install))
+		start = time.Now()
+		err = pkgs.Install(diff.packages()...)
+		p.report.AddStep("install-packages", start, err)
+		if err != nil {
+			diff.record(p.report, false)
+			return errors.Wrapf(err, "error installing packages on %s", p.manifest.ID)
+		}
+	}
+	if len(diff.hold) > 0 {
+		start = time.Now()
+		err = pkgs.Hold(diff.hold...)
+		p.report.AddStep("hold-packages", start, err)
+		if err != nil {
+			diff.record(p.report, false)
+			return errors.Wrapf(err, "error holding packages on %s", p.manifest.ID)
+		}
 	}
+	diff.record(p.report, true)
 
+	data := p.templateData()
 	p.log.Infof("rendering and copying template with %v", data)
-	fm := files.New(p.log, p.manifest, p.ssh)
-	changedPackages, err := fm.RenderAndTransfer(data)
+	start = time.Now()
+	fm := files.New(p.log, p.manifest, p.ssh).WithBackup(p.runID)
+	changes, err := fm.RenderAndTransfer(data)
+	for i := range changes {
+		p.report.AddFile(&changes[i])
+	}
+	if p.tx != nil {
+		p.tx.changes = changes
+	}
+	p.report.AddStep("files", start, err)
 	if err != nil {
 		return errors.Wrap(err, "error rendering files")
 	}
-	p.log.Infof("changed packages %+v", changedPackages)
-
+	// a failure to prune old backups does not fail the run
+	if err := fm.PruneBackups(p.manifest.Retention()); err != nil {
+		p.log.Warnf("unable to prune backups on %s: %v", p.manifest.ID, err)
+	}
 	// apply file modes and ownership
 	p.log.Info("applying permissions to files")
-	if err := fm.ApplyPermissions(); err != nil {
+	start = time.Now()
+	err = fm.ApplyPermissions()
+	p.report.AddStep("permissions", start, err)
+	if err != nil {
 		return errors.Wrap(err, "error applying permissions")
 	}
+	// remove files no longer declared, then record the managed files so
+	// the next run can find files that are no longer declared
+	if err := p.removeOrphans(); err != nil {
+		return err
+	}
+	if err := p.writeLedger(changes); err != nil {
+		return err
+	}
 
-	// now that packages are reconcile, files reconciled, restart services
-	// only restart services that had packaged with changes
-	for _, pkg := range changedPackages {
-		if pkg.Kind == manifest.PackageKindService {
-			if err := pkgs.RestartService(pkg.Name); err != nil {
-				return errors.Wrapf(err, "error restarting service %s on %s", pkg.Name, p.manifest.ID)
-			}
-		}
+	svc, err := p.serviceManager()
+	if err != nil {
+		return err
 	}
 
-	// Check status for the services we expect to be running. Docker does
-	// not have systemd running, so we use service(system V init, which is running in
-	// docker) when the backed is docker.
-	// TODO: move service status into backed, add to interface
-	for _, pkg := range p.manifest.Packages {
-		if pkg.Kind != manifest.PackageKindService {
-			continue
-		}
-		cmd := "systemctl status %s"
-		if p.manifest.Provider == manifest.ProviderBackendDocker {
-			cmd = "service %s status"
-		}
-		// service name and package name might not be the same thing.
-		// They work for now with the current manifests, but a future
-		// improvement would be to add a service name override or something fancier.
-		out, err := p.ssh.Execf(cmd, pkg.Name)
-		if err != nil {
-			p.log.Infof("warning: unable to get status of service out: '%s', pkage name: %s", out, pkg.Name)
-		} else {
-			p.log.Infof("service status out: '%s', package name: %s", out, pkg.Name)
-		}
+	// now that packages are reconcile, files reconciled, converge services:
+	// start, stop, enable and disable, restart or reload services of packages
+	// with changed files
+	start = time.Now()
+	err = p.convergeServices(svc, changes)
+	p.report.AddStep("converge-services", start, err)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	// Check status for the services we expect in their desired state.
+	start = time.Now()
+	err = p.checkServices(svc)
+	p.report.AddStep("service-status", start, err)
+	if err != nil {
+		return err
+	}
+	return p.checkHealth(ctx)
+}
+
+// packageManager returns the package manager for the target, from the
+// manifest or detected from /etc/os-release
+func (p *ProviderReconciler) packageManager() (packages.Manager, error) {
+	pkgs, err := packages.New(p.log, p.manifest, p.ssh, p.osRelease)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting package manager for %s", p.manifest.ID)
+	}
+	return pkgs, nil
+}
+
+// serviceManager returns the service manager for the target, from the
+// manifest or detected on the target
+func (p *ProviderReconciler) serviceManager() (services.Manager, error) {
+	svc, err := services.New(p.log, p.manifest, p.ssh)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting service manager for %s", p.manifest.ID)
+	}
+	return svc, nil
 }
 
 // Remove removes packages and files installed by reconcile
 // context parameter is not yet used
-// purge is passed to packages to purge package instead of just remove
+// purge tears down packages fully, see purge
 func (p *ProviderReconciler) Remove(_ context.Context, purge bool) error {
-	p.log.Infof("remove")
+	p.log.Infof("remove (purge? %v)", purge)
 
 	p.log.Info("spew manifest", spew.Sdump(p.manifest))
+	if err := p.readLedger(); err != nil {
+		return err
+	}
 	remove := make([]manifest.Package, 0, len(p.manifest.Packages))
-	pkgs := packages.NewPackages(p.log, p.manifest, p.ssh)
+	pkgs, err := p.packageManager()
+	if err != nil {
+		return err
+	}
 	pkglist, err := pkgs.Query()
 	if err != nil {
 		return errors.Wrap(err, "error getting packages from container")
 	}
 	p.log.Infof("pkgs %d", len(pkglist))
 
-	// diff the desired and actual packages, make a list of packages to install
+	// diff the desired and actual packages, make a list of packages to remove
 	for _, pkg := range p.manifest.Packages {
-		_, ok := pkglist[pkg.Name]
-		if ok {
+		pkgActual, ok := pkglist[pkg.Name]
+		if ok && (pkgActual.Status == "installed" || purge && pkgActual.Status != "not-installed") {
 			p.log.Infof("package %s exists on remote, will remove, %s",
 				pkg.Name, p.manifest.ID)
-			remove = append(remove, manifest.Package{Name: pkg.Name, Version: pkg.Version})
+			remove = append(remove, pkg)
 		}
 	}
 
-	if len(remove) > 0 {
-		if err := pkgs.Remove(purge, remove...); err != nil {
-			p.log.Infof("remove package error, err: %v", err)
-		}
-		p.log.Infof("remove packages")
-		fm := files.New(p.log, p.manifest, p.ssh)
-		if err := fm.Remove(remove...); err != nil {
-			return errors.Wrap(err, "error rendering files")
-		}
+	if purge {
+		return p.purge(pkgs, remove)
+	}
+	if len(remove) == 0 {
+		return nil
+	}
+	start := time.Now()
+	err = pkgs.Remove(false, remove...)
+	p.report.AddStep("remove-packages", start, err)
+	if err != nil {
+		return errors.Wrapf(err, "error removing packages on %s", p.manifest.ID)
+	}
+	for _, pkg := range remove {
+		p.report.Packages.Removed = append(p.report.Packages.Removed, pkg.Name)
+	}
+	p.log.Infof("remove files")
+	start = time.Now()
+	fm := files.New(p.log, p.manifest, p.ssh)
+	err = fm.Remove(p.ownedFiles(remove)...)
+	p.report.AddStep("remove-files", start, err)
+	if err != nil {
+		return errors.Wrap(err, "error removing files")
 	}
 	return nil
 }
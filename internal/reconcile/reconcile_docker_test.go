@@ -2,6 +2,7 @@ package reconcile
 
 import (
 	"context"
+	"os"
 	"testing"
 
 	"gotest.tools/v3/assert"
@@ -32,7 +33,9 @@ func TestReconcileDocker(t *testing.T) {
 				}},
 		},
 	}
-	err := Run(context.TODO(), log, m, Reconcile)
+	report, err := Run(context.TODO(), log, os.Stdout, m, Reconcile)
 	assert.NilError(t, err)
+	assert.Equal(t, report.Outcome, OutcomeSuccess)
+	assert.Check(t, len(report.Files) == 2, "expected 2 files in report")
 	log.Infof("reconciler is finished")
 }
@@ -0,0 +1,210 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/packages"
+	"slack-reconcile-deployments/internal/reconcile/state"
+)
+
+// DriftKind is the kind of difference between desired state and a target
+type DriftKind string
+
+const (
+	// DriftKindPackageMissing a desired package is not installed
+	DriftKindPackageMissing = DriftKind("package-missing")
+	// DriftKindPackageVersion a package is installed with another version
+	DriftKindPackageVersion = DriftKind("package-version")
+	// DriftKindPackagePresent a package desired absent or purged is installed
+	DriftKindPackagePresent = DriftKind("package-present")
+	// DriftKindPackageHold a package desired held is not held
+	DriftKindPackageHold = DriftKind("package-hold")
+	// DriftKindFileMissing a desired file does not exist
+	DriftKindFileMissing = DriftKind("file-missing")
+	// DriftKindFileModified a file content was modified
+	DriftKindFileModified = DriftKind("file-modified")
+	// DriftKindFileMode a file has another mode
+	DriftKindFileMode = DriftKind("file-mode")
+	// DriftKindFileOwner a file has another owner
+	DriftKindFileOwner = DriftKind("file-owner")
+	// DriftKindServiceStopped a service desired running is not running
+	DriftKindServiceStopped = DriftKind("service-stopped")
+	// DriftKindServiceRunning a service desired stopped is running
+	DriftKindServiceRunning = DriftKind("service-running")
+	// DriftKindServiceEnabled a service is enabled or disabled at boot other than desired
+	DriftKindServiceEnabled = DriftKind("service-enabled")
+	// DriftKindOrphanFile a file in the state ledger is no longer declared
+	DriftKindOrphanFile = DriftKind("orphan-file")
+	// DriftKindOrphanPackage a package in the state ledger is no longer declared
+	DriftKindOrphanPackage = DriftKind("orphan-package")
+)
+
+// Drift is one difference between the desired state in a manifest and a target
+type Drift struct {
+	// Kind is the kind of drift
+	Kind DriftKind `json:"kind"`
+	// Target is the package, file or service that drifted
+	Target string `json:"target"`
+	// Detail is a human-readable description of the drift
+	Detail string `json:"detail"`
+	// Diff is a unified diff of file content for modified files
+	Diff string `json:"diff,omitempty"`
+}
+
+// WriteDrift writes a human-readable list of drift to w. The list is written
+// with a single write so output for concurrent manifests is not interleaved.
+func WriteDrift(w io.Writer, m *manifest.Manifest, drift []Drift) error {
+	buf := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(buf, "drift for %s (%s): %d drifted\n", m.ID, m.Provider, len(drift))
+	for _, d := range drift {
+		fmt.Fprintf(buf, "  %-16s %s: %s\n", d.Kind, d.Target, d.Detail)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Drift compares the desired state in the manifest with the target and
+// returns the differences. Drift does not change the target.
+func (p *ProviderReconciler) Drift(_ context.Context) ([]Drift, error) {
+	p.log.Infof("drift")
+
+	drift := make([]Drift, 0)
+	pkgs, err := p.packageManager()
+	if err != nil {
+		return nil, err
+	}
+	pkglist, err := pkgs.Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting packages from container")
+	}
+	held, err := pkgs.Held()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting held packages from container")
+	}
+	for _, pkgDesired := range p.manifest.Packages {
+		pkgActual, ok := pkglist[pkgDesired.Name]
+		desired := pkgDesired.DesiredState()
+		switch {
+		case desired == manifest.PackageStateAbsent && ok &&
+			pkgActual.Status != "not-installed" && pkgActual.Status != "config-files",
+			desired == manifest.PackageStatePurged && ok && pkgActual.Status != "not-installed":
+			drift = append(drift, Drift{Kind: DriftKindPackagePresent, Target: pkgDesired.Name,
+				Detail: fmt.Sprintf("desired %s, actual %s version %s", desired, pkgActual.Status, pkgActual.Version)})
+			continue
+		case pkgDesired.Removed():
+			continue
+		case !ok || pkgActual.Status != "installed":
+			drift = append(drift, Drift{Kind: DriftKindPackageMissing, Target: pkgDesired.Name,
+				Detail: fmt.Sprintf("desired version %s is not installed", pkgDesired.Version)})
+		case packages.HasVersionConstraint(pkgDesired.Version) &&
+			!packages.MatchVersion(pkgDesired.Version, pkgActual.Version):
+			drift = append(drift, Drift{Kind: DriftKindPackageVersion, Target: pkgDesired.Name,
+				Detail: fmt.Sprintf("desired version %s, actual version %s", pkgDesired.Version, pkgActual.Version)})
+		}
+		if desired == manifest.PackageStateHeld && !held[pkgDesired.Name] {
+			drift = append(drift, Drift{Kind: DriftKindPackageHold, Target: pkgDesired.Name,
+				Detail: "package is not held"})
+		}
+	}
+
+	fm := files.New(p.log, p.manifest, p.ssh)
+	data := p.templateData()
+	for _, pkg := range p.manifest.Packages {
+		if pkg.Removed() {
+			continue
+		}
+		for _, f := range pkg.Files {
+			fileDrift, err := p.fileDrift(fm, &pkg, &f, data)
+			if err != nil {
+				return nil, err
+			}
+			drift = append(drift, fileDrift...)
+		}
+	}
+
+	ledger, err := state.Read(p.ssh)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading state on %s", p.manifest.ID)
+	}
+	orphanFiles, orphanPackages := ledger.Orphans(p.manifest)
+	for _, f := range orphanFiles {
+		drift = append(drift, Drift{Kind: DriftKindOrphanFile, Target: f.Path,
+			Detail: fmt.Sprintf("file of package %s is no longer declared", f.Package)})
+	}
+	for _, pkg := range orphanPackages {
+		drift = append(drift, Drift{Kind: DriftKindOrphanPackage, Target: pkg.Name,
+			Detail: "package is no longer declared"})
+	}
+
+	svc, err := p.serviceManager()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range p.serviceDefinitions() {
+		status, err := svc.Status(d.svc.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting status of service %s", d.svc.Name)
+		}
+		switch {
+		case d.svc.DesiredState() == manifest.ServiceStateRunning && !status.Running():
+			p.log.Infof("service %s is %s, out: '%s'", d.svc.Name, status.State, status.Output)
+			drift = append(drift, Drift{Kind: DriftKindServiceStopped, Target: d.svc.Name,
+				Detail: fmt.Sprintf("service is %s", status.State)})
+		case d.svc.DesiredState() == manifest.ServiceStateStopped && status.Running():
+			drift = append(drift, Drift{Kind: DriftKindServiceRunning, Target: d.svc.Name,
+				Detail: "service is running, desired stopped"})
+		}
+		if d.svc.Enabled != nil && *d.svc.Enabled != status.Enabled {
+			drift = append(drift, Drift{Kind: DriftKindServiceEnabled, Target: d.svc.Name,
+				Detail: fmt.Sprintf("desired enabled %v, actual enabled %v", *d.svc.Enabled, status.Enabled)})
+		}
+	}
+	return drift, nil
+}
+
+// fileDrift compares one file with the file on the target
+func (p *ProviderReconciler) fileDrift(fm *files.FileManager, pkg *manifest.Package, f *manifest.File,
+	data map[string]string) ([]Drift, error) {
+	stat, err := fm.Stat(f)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Drift{{Kind: DriftKindFileMissing, Target: f.Path, Detail: "file does not exist"}}, nil
+		}
+		return nil, errors.Wrapf(err, "error stat %s", f.Path)
+	}
+
+	drift := make([]Drift, 0)
+	remote, err := fm.Read(f)
+	if err != nil {
+		return nil, err
+	}
+	modified, err := fm.Modified(pkg, f, data, remote)
+	if err != nil {
+		return nil, err
+	}
+	if modified {
+		change, err := fm.Compare(pkg, f, data)
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, Drift{Kind: DriftKindFileModified, Target: f.Path,
+			Detail: fmt.Sprintf("sha256 %s", stat.Sha256), Diff: change.Diff})
+	}
+	if f.Mode != "" && !files.ModeEqual(f.Mode, stat.Mode) {
+		drift = append(drift, Drift{Kind: DriftKindFileMode, Target: f.Path,
+			Detail: fmt.Sprintf("desired mode %s, actual mode %s", f.Mode, stat.Mode)})
+	}
+	if f.Owner != "" && !files.OwnerEqual(f.Owner, stat) {
+		drift = append(drift, Drift{Kind: DriftKindFileOwner, Target: f.Path,
+			Detail: fmt.Sprintf("desired owner %s, actual owner %s:%s", f.Owner, stat.Owner, stat.Group)})
+	}
+	return drift, nil
+}
@@ -0,0 +1,32 @@
+package reconcile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+)
+
+// TestWriteDrift tests writing a human-readable list of drift
+func TestWriteDrift(t *testing.T) {
+	m := &manifest.Manifest{ID: "b2267d6b23", Provider: manifest.ProviderBackendDocker}
+	drift := []Drift{
+		{Kind: DriftKindPackageMissing, Target: "nginx", Detail: "desired version latest is not installed"},
+		{Kind: DriftKindFileMode, Target: "/var/www/html/info.php", Detail: "desired mode 0777, actual mode 644"},
+	}
+	buf := bytes.NewBuffer([]byte{})
+	err := WriteDrift(buf, m, drift)
+	assert.NilError(t, err, "write drift")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 3)
+	assert.Equal(t, lines[0], "drift for b2267d6b23 (docker): 2 drifted")
+	assert.Check(t, strings.Contains(lines[2], "file-mode"), lines[2])
+
+	report := NewReport(m, DetectDrift)
+	assert.Check(t, !report.Drifted())
+	report.Drift = drift
+	assert.Check(t, report.Drifted())
+}
@@ -0,0 +1,29 @@
+package reconcile
+
+import (
+	"context"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// escalationKey is the context key of the privilege escalation of the run
+type escalationKey struct{}
+
+// WithEscalation returns a context with the privilege escalation of commands
+// on the targets. The method overrides the escalation of the manifests when
+// set, the password is used on all targets.
+func WithEscalation(ctx context.Context, escalation ssh.Escalation) context.Context {
+	return context.WithValue(ctx, escalationKey{}, escalation)
+}
+
+// escalation returns the escalation of commands on the target of m, the
+// method of the context, then of the manifest. The method is derived from
+// the ssh user when neither is set.
+func escalation(ctx context.Context, m *manifest.Manifest) ssh.Escalation {
+	e, _ := ctx.Value(escalationKey{}).(ssh.Escalation)
+	if e.Method == "" {
+		e.Method = ssh.EscalationMethod(m.Escalation)
+	}
+	return e
+}
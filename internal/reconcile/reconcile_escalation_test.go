@@ -0,0 +1,25 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+func TestEscalation(t *testing.T) {
+	m := &manifest.Manifest{ID: "b2267d6b23", Escalation: manifest.EscalationDoas}
+	assert.Equal(t, escalation(context.Background(), m).Method, ssh.EscalationDoas)
+	assert.Equal(t, escalation(context.Background(), &manifest.Manifest{}).Method, ssh.EscalationMethod(""))
+
+	ctx := WithEscalation(context.Background(), ssh.Escalation{Password: "s3cret"})
+	e := escalation(ctx, m)
+	assert.Equal(t, e.Method, ssh.EscalationDoas)
+	assert.Equal(t, e.Password, "s3cret")
+
+	ctx = WithEscalation(context.Background(), ssh.Escalation{Method: ssh.EscalationSudo, Password: "s3cret"})
+	assert.Equal(t, escalation(ctx, m).Method, ssh.EscalationSudo)
+}
@@ -0,0 +1,63 @@
+package reconcile
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HealthCheckReport is the result of one health check
+type HealthCheckReport struct {
+	// Name is the name of the health check
+	Name string `json:"name"`
+	// Healthy is true when the command exited 0
+	Healthy bool `json:"healthy"`
+	// Attempts is the number of times the command was run
+	Attempts int `json:"attempts"`
+	// Output is the output of the last run of the command
+	Output string `json:"output"`
+}
+
+// checkHealth runs the health checks of the manifest, retrying unhealthy
+// checks. An error is returned when any health check is unhealthy.
+func (p *ProviderReconciler) checkHealth(ctx context.Context) error {
+	if len(p.manifest.HealthChecks) == 0 {
+		return nil
+	}
+	start := time.Now()
+	unhealthy := make([]string, 0)
+	for _, h := range p.manifest.HealthChecks {
+		report := HealthCheckReport{Name: h.Name}
+		for report.Attempts <= h.Retries {
+			if report.Attempts > 0 {
+				select {
+				case <-time.After(h.RetryInterval()):
+				case <-ctx.Done():
+					p.report.AddStep("health-checks", start, ctx.Err())
+					return errors.Wrapf(ctx.Err(), "health check %s on %s", h.Name, p.manifest.ID)
+				}
+			}
+			report.Attempts++
+			result, err := p.ssh.Streamf("%s", h.Command)
+			report.Output = strings.TrimSpace(string(result.Combined))
+			if err == nil {
+				report.Healthy = true
+				break
+			}
+			p.log.Infof("health check %s on %s failed, attempt %d of %d: %v, out: '%s'",
+				h.Name, p.manifest.ID, report.Attempts, h.Retries+1, err, report.Output)
+		}
+		if !report.Healthy {
+			unhealthy = append(unhealthy, h.Name)
+		}
+		p.report.HealthChecks = append(p.report.HealthChecks, report)
+	}
+	var err error
+	if len(unhealthy) > 0 {
+		err = errors.Errorf("unhealthy on %s: %s", p.manifest.ID, strings.Join(unhealthy, ", "))
+	}
+	p.report.AddStep("health-checks", start, err)
+	return err
+}
@@ -0,0 +1,38 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"slack-reconcile-deployments/internal/reconcile/lock"
+)
+
+// lockStaleAfterKey is the context key of the age after which a lock is stale
+type lockStaleAfterKey struct{}
+
+// WithLockStaleAfter returns a context with the age after which the lock of
+// another run on the target is stale and broken
+func WithLockStaleAfter(ctx context.Context, staleAfter time.Duration) context.Context {
+	return context.WithValue(ctx, lockStaleAfterKey{}, staleAfter)
+}
+
+// lockStaleAfterFromContext returns the age after which a lock is stale,
+// lock.DefaultStaleAfter when not set
+func lockStaleAfterFromContext(ctx context.Context) time.Duration {
+	staleAfter, ok := ctx.Value(lockStaleAfterKey{}).(time.Duration)
+	if !ok || staleAfter <= 0 {
+		return lock.DefaultStaleAfter
+	}
+	return staleAfter
+}
+
+// locks returns true when the operation changes the target, so the target
+// is locked for the run. Dry runs and drift detection only read the target.
+func (op Operation) locks() bool {
+	switch op {
+	case DryRun, DetectDrift:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,24 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/reconcile/lock"
+)
+
+func TestLockStaleAfter(t *testing.T) {
+	assert.Equal(t, lockStaleAfterFromContext(context.Background()), lock.DefaultStaleAfter)
+	ctx := WithLockStaleAfter(context.Background(), 30*time.Minute)
+	assert.Equal(t, lockStaleAfterFromContext(ctx), 30*time.Minute)
+
+	for _, op := range []Operation{Reconcile, Remove, Purge, Rollback} {
+		assert.Check(t, op.locks(), op)
+	}
+	for _, op := range []Operation{DryRun, DetectDrift} {
+		assert.Check(t, !op.locks(), op)
+	}
+}
@@ -0,0 +1,214 @@
+package reconcile
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/packages"
+)
+
+// packageInstall is a package to install with the version it replaces
+type packageInstall struct {
+	// pkg is the package to install, with the concrete version to install
+	pkg manifest.Package
+	// from is the installed version, empty when the package is not installed
+	from string
+}
+
+// packageDiff is the difference between the desired and actual packages
+type packageDiff struct {
+	// install are the packages to install, upgrade or downgrade
+	install []packageInstall
+	// unavailable are the errors for desired versions not in the apt cache
+	unavailable []error
+	// found are the desired packages installed on the target
+	found []string
+	// mismatched are the packages installed with a version other than desired
+	mismatched []PackageMismatch
+	// remove are the installed packages with desired state absent
+	remove []manifest.Package
+	// purge are the packages with desired state purged, installed or with
+	// configuration files left
+	purge []manifest.Package
+	// hold are the packages with desired state held that are not held
+	hold []manifest.Package
+	// unhold are held packages with a desired state other than held
+	unhold []manifest.Package
+}
+
+// packages returns the packages to install
+func (d *packageDiff) packages() []manifest.Package {
+	pkgs := make([]manifest.Package, 0, len(d.install))
+	for _, i := range d.install {
+		pkgs = append(pkgs, i.pkg)
+	}
+	return pkgs
+}
+
+// err returns an error listing the unavailable versions, nil when all
+// desired versions are available
+func (d *packageDiff) err() error {
+	if len(d.unavailable) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(d.unavailable))
+	for _, err := range d.unavailable {
+		messages = append(messages, err.Error())
+	}
+	return errors.Errorf("%d desired package versions are not available: %s",
+		len(d.unavailable), strings.Join(messages, "; "))
+}
+
+// record records found, mismatched and installed packages in the report
+func (d *packageDiff) record(r *Report, installed bool) {
+	r.Packages.Found = append(r.Packages.Found, d.found...)
+	r.Packages.Mismatched = append(r.Packages.Mismatched, d.mismatched...)
+	if installed {
+		for _, i := range d.install {
+			r.Packages.Installed = append(r.Packages.Installed, i.pkg.Name)
+		}
+		for _, pkg := range d.remove {
+			r.Packages.Removed = append(r.Packages.Removed, pkg.Name)
+		}
+		for _, pkg := range d.purge {
+			r.Packages.Removed = append(r.Packages.Removed, pkg.Name)
+		}
+		for _, pkg := range d.hold {
+			r.Packages.Held = append(r.Packages.Held, pkg.Name)
+		}
+		for _, pkg := range d.unhold {
+			r.Packages.Unheld = append(r.Packages.Unheld, pkg.Name)
+		}
+	}
+}
+
+// diffPackages diffs the desired and actual packages. Desired versions are
+// resolved to concrete versions with the apt policy: latest is upgraded to the
+// candidate version, and a version constraint is installed, upgraded or
+// downgraded to a matching version when the installed version does not satisfy it.
+// Packages with desired state absent or purged are removed, and holds are
+// set for held packages. A hold is only removed from a package with an
+// explicit state, packages without a state do not manage holds.
+func (p *ProviderReconciler) diffPackages(pkglist map[string]manifest.Package,
+	policies map[string]packages.Policy, held map[string]bool) *packageDiff {
+	diff := &packageDiff{}
+	for _, pkgDesired := range p.manifest.Packages {
+		pkgActual, ok := pkglist[pkgDesired.Name]
+		installed := ok && pkgActual.Status == "installed"
+		policy, hasPolicy := policies[pkgDesired.Name]
+		if !hasPolicy {
+			policy = packages.Policy{Name: pkgDesired.Name}
+		}
+		state := pkgDesired.DesiredState()
+		if held[pkgDesired.Name] && pkgDesired.State != "" && state != manifest.PackageStateHeld {
+			diff.unhold = append(diff.unhold, pkgDesired)
+		}
+		if state == manifest.PackageStateHeld && !held[pkgDesired.Name] {
+			diff.hold = append(diff.hold, pkgDesired)
+		}
+
+		switch {
+		case state == manifest.PackageStateAbsent:
+			if ok && pkgActual.Status != "not-installed" && pkgActual.Status != "config-files" {
+				p.log.Infof("package %s is %s, desired absent, will remove", pkgDesired.Name, pkgActual.Status)
+				diff.remove = append(diff.remove, pkgDesired)
+			}
+			continue
+		case state == manifest.PackageStatePurged:
+			if ok && pkgActual.Status != "not-installed" {
+				p.log.Infof("package %s is %s, desired purged, will purge", pkgDesired.Name, pkgActual.Status)
+				diff.purge = append(diff.purge, pkgDesired)
+			}
+			continue
+		case installed && packages.HasVersionConstraint(pkgDesired.Version) &&
+			packages.MatchVersion(pkgDesired.Version, pkgActual.Version):
+			p.log.Infof("desired package %s is ok, installed %s", pkgDesired.Name, pkgActual.Version)
+			diff.found = append(diff.found, pkgDesired.Name)
+			continue
+		case installed && !packages.HasVersionConstraint(pkgDesired.Version) &&
+			(!upgrade(&pkgDesired) || policy.Candidate == "" || policy.Candidate == pkgActual.Version):
+			p.log.Infof("desired package %s is ok, installed %s is the candidate", pkgDesired.Name, pkgActual.Version)
+			diff.found = append(diff.found, pkgDesired.Name)
+			continue
+		case installed:
+			p.log.Infof("desired package %s version %s does not match actual version %s",
+				pkgDesired.Name, pkgDesired.Version, pkgActual.Version)
+			diff.found = append(diff.found, pkgDesired.Name)
+			diff.mismatched = append(diff.mismatched, PackageMismatch{
+				Name:    pkgDesired.Name,
+				Desired: pkgDesired.Version,
+				Actual:  pkgActual.Version,
+			})
+		case ok:
+			p.log.Infof("desired package %s appears not to be installed, will install status: %s",
+				pkgActual.Name, pkgActual.Status)
+		default:
+			p.log.Infof("desired package %s is missing on target %s",
+				pkgDesired.Name, p.manifest.ID)
+		}
+
+		version, err := policy.Resolve(pkgDesired.Version)
+		if err != nil {
+			diff.unavailable = append(diff.unavailable, err)
+			continue
+		}
+		from := ""
+		if installed {
+			from = pkgActual.Version
+		}
+		diff.install = append(diff.install, packageInstall{
+			pkg: manifest.Package{Name: pkgDesired.Name, Version: version, Kind: pkgDesired.Kind,
+				State: pkgDesired.State},
+			from: from,
+		})
+	}
+	return diff
+}
+
+// removePackages removes packages with desired state absent and purges
+// packages with desired state purged, then removes the files of removed
+// packages from the manifest
+func (p *ProviderReconciler) removePackages(pkgs packages.Manager, diff *packageDiff) error {
+	start := time.Now()
+	if len(diff.remove) > 0 {
+		p.log.Infof("%s removing %d packages", p.manifest.ID, len(diff.remove))
+		if err := pkgs.Remove(false, diff.remove...); err != nil {
+			p.report.AddStep("remove-packages", start, err)
+			return errors.Wrapf(err, "error removing packages on %s", p.manifest.ID)
+		}
+	}
+	if len(diff.purge) > 0 {
+		p.log.Infof("%s purging %d packages", p.manifest.ID, len(diff.purge))
+		if err := pkgs.Remove(true, diff.purge...); err != nil {
+			p.report.AddStep("remove-packages", start, err)
+			return errors.Wrapf(err, "error purging packages on %s", p.manifest.ID)
+		}
+	}
+
+	removed := make([]manifest.Package, 0)
+	for _, pkg := range p.manifest.Packages {
+		if pkg.Removed() && len(pkg.Files) > 0 {
+			removed = append(removed, pkg)
+		}
+	}
+	if len(removed) == 0 && len(diff.remove) == 0 && len(diff.purge) == 0 {
+		return nil
+	}
+	fm := files.New(p.log, p.manifest, p.ssh)
+	err := fm.Remove(p.ownedFiles(removed)...)
+	p.report.AddStep("remove-packages", start, err)
+	if err != nil {
+		return errors.Wrapf(err, "error removing files on %s", p.manifest.ID)
+	}
+	return nil
+}
+
+// upgrade returns true when an installed package is upgraded to the candidate
+// version, with state latest or version latest
+func upgrade(pkg *manifest.Package) bool {
+	return pkg.DesiredState() == manifest.PackageStateLatest || pkg.Version == manifest.VersionLatest
+}
@@ -0,0 +1,116 @@
+package reconcile
+
+import (
+	"fmt"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/packages"
+)
+
+// TestDiffPackages tests installs, upgrades and downgrades are resolved with
+// the apt policy
+func TestDiffPackages(t *testing.T) {
+	m := &manifest.Manifest{
+		ID: "b2267d6b23",
+		Packages: []manifest.Package{
+			{Name: "nginx", Version: "latest", Kind: manifest.PackageKindService},
+			{Name: "php8.2-fpm", Version: "8.2", Kind: manifest.PackageKindService},
+			{Name: "dnsutils", Version: "1:9.18.16-1~deb12u1", Kind: manifest.PackageKindBinary},
+			{Name: "netcat-traditional", Version: "latest", Kind: manifest.PackageKindBinary},
+			{Name: "curl", Version: "9.9", Kind: manifest.PackageKindBinary},
+			{Name: "openssl", Version: ">= 3.0, << 4.0", Kind: manifest.PackageKindBinary},
+			{Name: "git", Version: ">= 1:2.39", Kind: manifest.PackageKindBinary},
+		},
+	}
+	pkglist := map[string]manifest.Package{
+		"nginx":      {Name: "nginx", Version: "1.22.1-9", Status: "installed"},
+		"php8.2-fpm": {Name: "php8.2-fpm", Version: "8.2.7-1~deb12u1", Status: "installed"},
+		"dnsutils":   {Name: "dnsutils", Version: "1:9.18.19-1~deb12u1", Status: "installed"},
+		"openssl":    {Name: "openssl", Version: "3.0.11-1~deb12u1", Status: "installed"},
+		"git":        {Name: "git", Version: "1:2.30.2-1+deb11u2", Status: "installed"},
+	}
+	policies := map[string]packages.Policy{
+		"nginx": {Name: "nginx", Candidate: "1.22.1-9+deb12u1",
+			Versions: []string{"1.22.1-9+deb12u1", "1.22.1-9"}},
+		"php8.2-fpm": {Name: "php8.2-fpm", Candidate: "8.2.7-1~deb12u1",
+			Versions: []string{"8.2.7-1~deb12u1"}},
+		"dnsutils": {Name: "dnsutils", Candidate: "1:9.18.19-1~deb12u1",
+			Versions: []string{"1:9.18.19-1~deb12u1", "1:9.18.16-1~deb12u1"}},
+		"netcat-traditional": {Name: "netcat-traditional", Candidate: "1.10-47",
+			Versions: []string{"1.10-47"}},
+		"curl": {Name: "curl", Candidate: "7.88.1-10+deb12u5",
+			Versions: []string{"7.88.1-10+deb12u5"}},
+		"openssl": {Name: "openssl", Candidate: "3.0.13-1~deb12u1",
+			Versions: []string{"3.0.13-1~deb12u1", "3.0.11-1~deb12u1"}},
+		"git": {Name: "git", Candidate: "1:2.39.2-1.1",
+			Versions: []string{"1:2.39.2-1.1", "1:2.30.2-1+deb11u2"}},
+	}
+
+	p := New(logging.New(t.Name(), false), m, nil)
+	diff := p.diffPackages(pkglist, policies, map[string]bool{})
+	installs := make([]string, 0, len(diff.install))
+	for _, i := range diff.install {
+		installs = append(installs, fmt.Sprintf("%s=%s from %s", i.pkg.Name, i.pkg.Version, i.from))
+	}
+	assert.DeepEqual(t, installs, []string{
+		"nginx=1.22.1-9+deb12u1 from 1.22.1-9",
+		"dnsutils=1:9.18.16-1~deb12u1 from 1:9.18.19-1~deb12u1",
+		"netcat-traditional=1.10-47 from ",
+		"git=1:2.39.2-1.1 from 1:2.30.2-1+deb11u2",
+	})
+	assert.DeepEqual(t, diff.found, []string{"nginx", "php8.2-fpm", "dnsutils", "openssl", "git"})
+	assert.Equal(t, len(diff.mismatched), 3)
+	assert.ErrorContains(t, diff.err(), "version 9.9 of package curl is not available")
+}
+
+// TestDiffPackagesState tests packages are removed, purged, held and unheld
+// by desired state
+func TestDiffPackagesState(t *testing.T) {
+	m := &manifest.Manifest{
+		ID: "b2267d6b23",
+		Packages: []manifest.Package{
+			{Name: "php7.4-fpm", State: manifest.PackageStateAbsent, Kind: manifest.PackageKindService},
+			{Name: "php7.4-common", State: manifest.PackageStatePurged, Kind: manifest.PackageKindBinary},
+			{Name: "php8.2-fpm", Version: "8.2", State: manifest.PackageStateHeld, Kind: manifest.PackageKindService},
+			{Name: "nginx", State: manifest.PackageStateLatest, Kind: manifest.PackageKindService},
+			{Name: "dnsutils", Kind: manifest.PackageKindBinary},
+			{Name: "curl", State: manifest.PackageStateAbsent, Kind: manifest.PackageKindBinary},
+		},
+	}
+	pkglist := map[string]manifest.Package{
+		"php7.4-fpm":    {Name: "php7.4-fpm", Version: "7.4.33-1+deb11u4", Status: "installed"},
+		"php7.4-common": {Name: "php7.4-common", Version: "2:92", Status: "config-files"},
+		"php8.2-fpm":    {Name: "php8.2-fpm", Version: "8.2.7-1~deb12u1", Status: "installed"},
+		"nginx":         {Name: "nginx", Version: "1.22.1-9", Status: "installed"},
+		"dnsutils":      {Name: "dnsutils", Version: "1:9.18.16-1~deb12u1", Status: "installed"},
+		"curl":          {Name: "curl", Version: "7.88.1-10+deb12u5", Status: "config-files"},
+	}
+	policies := map[string]packages.Policy{
+		"nginx": {Name: "nginx", Candidate: "1.22.1-9+deb12u1",
+			Versions: []string{"1.22.1-9+deb12u1", "1.22.1-9"}},
+		"dnsutils": {Name: "dnsutils", Candidate: "1:9.18.19-1~deb12u1",
+			Versions: []string{"1:9.18.19-1~deb12u1", "1:9.18.16-1~deb12u1"}},
+	}
+	held := map[string]bool{"nginx": true, "dnsutils": true}
+
+	p := New(logging.New(t.Name(), false), m, nil)
+	diff := p.diffPackages(pkglist, policies, held)
+	names := func(pkgs []manifest.Package) []string {
+		list := make([]string, 0, len(pkgs))
+		for _, pkg := range pkgs {
+			list = append(list, pkg.Name)
+		}
+		return list
+	}
+	assert.DeepEqual(t, names(diff.remove), []string{"php7.4-fpm"})
+	assert.DeepEqual(t, names(diff.purge), []string{"php7.4-common"})
+	assert.DeepEqual(t, names(diff.hold), []string{"php8.2-fpm"})
+	// dnsutils has no state and keeps its hold, present does not upgrade
+	assert.DeepEqual(t, names(diff.unhold), []string{"nginx"})
+	assert.DeepEqual(t, names(diff.packages()), []string{"nginx"})
+	assert.DeepEqual(t, diff.found, []string{"php8.2-fpm", "nginx", "dnsutils"})
+}
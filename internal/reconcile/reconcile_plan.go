@@ -0,0 +1,266 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/state"
+)
+
+// ChangeKind is the kind of change reconcile will make on a target
+type ChangeKind string
+
+const (
+	// ChangeKindPackageInstall a package will be installed
+	ChangeKindPackageInstall = ChangeKind("package-install")
+	// ChangeKindPackageRemove a package will be removed or purged
+	ChangeKindPackageRemove = ChangeKind("package-remove")
+	// ChangeKindPackageHold a package hold will be set or removed
+	ChangeKindPackageHold = ChangeKind("package-hold")
+	// ChangeKindFileWrite a file will be created or overwritten
+	ChangeKindFileWrite = ChangeKind("file-write")
+	// ChangeKindFileRemove a file of a removed package will be removed
+	ChangeKindFileRemove = ChangeKind("file-remove")
+	// ChangeKindFilePermissions a file mode or owner will be changed
+	ChangeKindFilePermissions = ChangeKind("file-permissions")
+	// ChangeKindServiceRestart a service will be restarted
+	ChangeKindServiceRestart = ChangeKind("service-restart")
+	// ChangeKindServiceReload a service will be reloaded
+	ChangeKindServiceReload = ChangeKind("service-reload")
+	// ChangeKindServiceStart a service will be started
+	ChangeKindServiceStart = ChangeKind("service-start")
+	// ChangeKindServiceStop a service will be stopped
+	ChangeKindServiceStop = ChangeKind("service-stop")
+	// ChangeKindServiceEnable a service will be enabled at boot
+	ChangeKindServiceEnable = ChangeKind("service-enable")
+	// ChangeKindServiceDisable a service will be disabled at boot
+	ChangeKindServiceDisable = ChangeKind("service-disable")
+	// ChangeKindHandlerCommand the command of a notified handler will be run
+	ChangeKindHandlerCommand = ChangeKind("handler-command")
+)
+
+// serviceChangeKinds are the change kinds of service actions
+var serviceChangeKinds = map[ServiceActionKind]ChangeKind{
+	ServiceActionRestart: ChangeKindServiceRestart,
+	ServiceActionReload:  ChangeKindServiceReload,
+	ServiceActionStart:   ChangeKindServiceStart,
+	ServiceActionStop:    ChangeKindServiceStop,
+	ServiceActionEnable:  ChangeKindServiceEnable,
+	ServiceActionDisable: ChangeKindServiceDisable,
+	ServiceActionCommand: ChangeKindHandlerCommand,
+}
+
+// Change is one change reconcile will make on a target
+type Change struct {
+	// Kind is the kind of change
+	Kind ChangeKind `json:"kind"`
+	// Target is the package, file or service the change applies to
+	Target string `json:"target"`
+	// Detail is a human-readable description of the change
+	Detail string `json:"detail"`
+	// Diff is a unified diff of file content for file writes
+	Diff string `json:"diff,omitempty"`
+}
+
+// Plan is the list of changes reconcile will make on a target. A plan is
+// computed without modifying the target.
+type Plan struct {
+	// ManifestID is the id of the manifest the plan was computed for
+	ManifestID string `json:"manifest_id"`
+	// Provider is the provider backend of the manifest
+	Provider manifest.ProviderBackend `json:"provider"`
+	// Changes are the changes in the order reconcile applies them
+	Changes []Change `json:"changes"`
+}
+
+// Add adds a change to the plan
+func (p *Plan) Add(kind ChangeKind, target, format string, args ...interface{}) {
+	p.Changes = append(p.Changes, Change{
+		Kind:   kind,
+		Target: target,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// Write writes a human-readable plan to w. The plan is written with a single
+// write so plans for concurrent manifests are not interleaved.
+func (p *Plan) Write(w io.Writer) error {
+	buf := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(buf, "plan for %s (%s): %d changes\n", p.ManifestID, p.Provider, len(p.Changes))
+	for _, c := range p.Changes {
+		fmt.Fprintf(buf, "  %-16s %s: %s\n", c.Kind, c.Target, c.Detail)
+		if c.Diff != "" {
+			// indent the diff under the change
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(c.Diff, "\n"), "\n") {
+				fmt.Fprintf(buf, "    %s", line)
+			}
+			buf.WriteString("\n")
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Plan computes the changes Reconcile would make without applying them.
+// Packages are queried, and files are rendered and compared with the files on
+// the target.
+func (p *ProviderReconciler) Plan(_ context.Context) (*Plan, error) {
+	p.log.Infof("plan")
+
+	plan := &Plan{
+		ManifestID: p.manifest.ID,
+		Provider:   p.manifest.Provider,
+	}
+	pkgs, err := p.packageManager()
+	if err != nil {
+		return nil, err
+	}
+	pkglist, err := pkgs.Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting packages from container")
+	}
+	// the apt cache is not updated in a plan, versions are resolved with the
+	// cache on the target
+	policies, err := pkgs.Policy()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting package policy from container")
+	}
+	held, err := pkgs.Held()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting held packages from container")
+	}
+	diff := p.diffPackages(pkglist, policies, held)
+	diff.record(p.report, false)
+	for _, pkg := range diff.unhold {
+		plan.Add(ChangeKindPackageHold, pkg.Name, "unhold")
+	}
+	for _, pkg := range diff.remove {
+		plan.Add(ChangeKindPackageRemove, pkg.Name, "remove version %s", pkglist[pkg.Name].Version)
+	}
+	for _, pkg := range diff.purge {
+		plan.Add(ChangeKindPackageRemove, pkg.Name, "purge version %s", pkglist[pkg.Name].Version)
+	}
+	for _, i := range diff.install {
+		if i.from == "" {
+			plan.Add(ChangeKindPackageInstall, i.pkg.Name, "install version %s", i.pkg.Version)
+		} else {
+			plan.Add(ChangeKindPackageInstall, i.pkg.Name, "change version %s -> %s", i.from, i.pkg.Version)
+		}
+	}
+	for _, err := range diff.unavailable {
+		plan.Add(ChangeKindPackageInstall, "unresolved", "%v", err)
+	}
+	for _, pkg := range diff.hold {
+		plan.Add(ChangeKindPackageHold, pkg.Name, "hold")
+	}
+
+	fm := files.New(p.log, p.manifest, p.ssh)
+	for _, pkg := range p.manifest.Packages {
+		if !pkg.Removed() {
+			continue
+		}
+		for _, f := range pkg.Files {
+			_, err := fm.Stat(&f)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "error stat %s", f.Path)
+			}
+			plan.Add(ChangeKindFileRemove, f.Path, "package %s is %s", pkg.Name, pkg.DesiredState())
+		}
+	}
+	// orphaned files created by reconcile are removed
+	ledger, err := state.Read(p.ssh)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading state on %s", p.manifest.ID)
+	}
+	p.ledger = ledger
+	if orphans := p.orphans(); orphans != nil {
+		for _, path := range orphans.Removed {
+			plan.Add(ChangeKindFileRemove, path, "orphan, no longer declared")
+		}
+	}
+	changes, err := fm.Plan(p.templateData())
+	if err != nil {
+		return nil, errors.Wrap(err, "error planning files")
+	}
+
+	for _, c := range changes {
+		p.report.AddFile(&c)
+		switch {
+		case c.Create:
+			plan.Add(ChangeKindFileWrite, c.File.Path, "create, sha256 %s", c.LocalSha256)
+		case c.Content:
+			plan.Add(ChangeKindFileWrite, c.File.Path, "update, sha256 %s -> %s", c.Stat.Sha256, c.LocalSha256)
+		}
+		if c.Content {
+			plan.Changes[len(plan.Changes)-1].Diff = c.Diff
+		}
+		if c.Mode || c.Owner {
+			plan.Add(ChangeKindFilePermissions, c.File.Path, "%s", permissionsDetail(&c))
+		}
+	}
+
+	// services are converged the same as Reconcile, with the status of the
+	// services now
+	svc, err := p.serviceManager()
+	if err != nil {
+		return nil, err
+	}
+	actions, err := p.serviceActions(svc, changes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error planning services")
+	}
+	for _, a := range actions {
+		if a.action == ServiceActionCommand && a.become != "" {
+			plan.Add(ChangeKindHandlerCommand, a.name, "%s, run %s as %s", a.reason, a.command, a.become)
+			continue
+		}
+		if a.action == ServiceActionCommand {
+			plan.Add(ChangeKindHandlerCommand, a.name, "%s, run %s", a.reason, a.command)
+			continue
+		}
+		plan.Add(serviceChangeKinds[a.action], a.name, "%s", a.reason)
+	}
+	return plan, nil
+}
+
+// templateData returns the data used to render templates
+func (p *ProviderReconciler) templateData() map[string]string {
+	// there are tests that cover these data values.
+	data := map[string]string{
+		files.FileTemplateKeyLastModifiedDate: time.Now().UTC().Format(time.RFC3339),
+	}
+	// copy values from parameters to data map, for used by templates
+	for k, v := range p.manifest.Parameters {
+		data[k] = v
+	}
+	return data
+}
+
+// permissionsDetail describes the mode and owner changes for a file
+func permissionsDetail(c *files.FileChange) string {
+	currentMode, currentOwner := "none", "none"
+	if c.Stat != nil {
+		currentMode = c.Stat.Mode
+		currentOwner = fmt.Sprintf("%s:%s", c.Stat.Owner, c.Stat.Group)
+	}
+	details := make([]string, 0, 2)
+	if c.Mode {
+		details = append(details, fmt.Sprintf("mode %s -> %s", currentMode, c.File.Mode))
+	}
+	if c.Owner {
+		details = append(details, fmt.Sprintf("owner %s -> %s", currentOwner, c.File.Owner))
+	}
+	return strings.Join(details, ", ")
+}
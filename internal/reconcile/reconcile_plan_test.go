@@ -0,0 +1,31 @@
+package reconcile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+)
+
+// TestPlanWrite tests writing a human-readable plan
+func TestPlanWrite(t *testing.T) {
+	plan := &Plan{ManifestID: "b2267d6b23", Provider: manifest.ProviderBackendDocker}
+	plan.Add(ChangeKindPackageInstall, "nginx", "install version %s", "latest")
+	plan.Add(ChangeKindFileWrite, "/etc/nginx/sites-available/default", "create")
+	plan.Changes[1].Diff = "--- a/etc/nginx/sites-available/default\n+++ b/etc/nginx/sites-available/default\n"
+	plan.Add(ChangeKindServiceRestart, "nginx", "files changed")
+
+	buf := bytes.NewBuffer([]byte{})
+	err := plan.Write(buf)
+	assert.NilError(t, err, "write plan")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 6)
+	assert.Equal(t, lines[0], "plan for b2267d6b23 (docker): 3 changes")
+	assert.Check(t, strings.Contains(lines[1], "package-install"), lines[1])
+	assert.Check(t, strings.Contains(lines[2], "/etc/nginx/sites-available/default: create"), lines[2])
+	assert.Equal(t, lines[3], "    --- a/etc/nginx/sites-available/default")
+	assert.Check(t, strings.Contains(lines[5], "service-restart"), lines[5])
+}
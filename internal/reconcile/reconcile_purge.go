@@ -0,0 +1,178 @@
+package reconcile
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/packages"
+	"slack-reconcile-deployments/internal/reconcile/services"
+	"slack-reconcile-deployments/internal/reconcile/state"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// purge tears down the packages of the manifest for decommissioning:
+// services are stopped and disabled, packages are purged, managed files and
+// configuration directories are removed, dependencies no longer needed are
+// removed, then the target is verified to have nothing left.
+func (p *ProviderReconciler) purge(pkgs packages.Manager, remove []manifest.Package) error {
+	svc, err := p.serviceManager()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = p.stopServices(svc)
+	p.report.AddStep("stop-services", start, err)
+	if err != nil {
+		return err
+	}
+
+	if len(remove) > 0 {
+		start = time.Now()
+		err = pkgs.Remove(true, remove...)
+		p.report.AddStep("purge-packages", start, err)
+		if err != nil {
+			return errors.Wrapf(err, "error purging packages on %s", p.manifest.ID)
+		}
+		for _, pkg := range remove {
+			p.report.Packages.Removed = append(p.report.Packages.Removed, pkg.Name)
+		}
+	}
+
+	// all managed files are removed on purge, including files that existed
+	// before reconcile managed them
+	start = time.Now()
+	err = p.removeManaged()
+	p.report.AddStep("remove-files", start, err)
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	err = pkgs.Autoremove(true)
+	p.report.AddStep("autoremove", start, err)
+	if err != nil {
+		return errors.Wrapf(err, "error removing dependencies no longer needed on %s", p.manifest.ID)
+	}
+
+	start = time.Now()
+	err = p.verifyPurged(pkgs, svc)
+	p.report.AddStep("verify-purge", start, err)
+	return err
+}
+
+// stopServices stops and disables the services of all packages
+func (p *ProviderReconciler) stopServices(svc services.Manager) error {
+	seen := make(map[string]bool)
+	for _, pkg := range p.manifest.Packages {
+		for _, s := range pkg.ServiceDefinitions() {
+			if seen[s.Name] {
+				continue
+			}
+			seen[s.Name] = true
+			status, err := svc.Status(s.Name)
+			if err != nil {
+				return errors.Wrapf(err, "error getting status of service %s", s.Name)
+			}
+			actions := make([]serviceAction, 0, 2)
+			if status.Running() {
+				actions = append(actions, serviceAction{name: s.Name, action: ServiceActionStop, reason: "purge"})
+			}
+			if status.Enabled {
+				actions = append(actions, serviceAction{name: s.Name, action: ServiceActionDisable, reason: "purge"})
+			}
+			for _, a := range actions {
+				p.log.Infof("service %s %s, %s", a.action, a.name, a.reason)
+				if err := p.runServiceAction(svc, a); err != nil {
+					return errors.Wrapf(err, "error on service %s %s on %s", a.action, a.name, p.manifest.ID)
+				}
+				p.report.ServiceActions = append(p.report.ServiceActions,
+					ServiceActionReport{Name: a.name, Action: a.action, Reason: a.reason})
+			}
+		}
+	}
+	return nil
+}
+
+// removeManaged removes the files and configuration directories of all
+// packages, orphaned files created by reconcile, and the state ledger
+func (p *ProviderReconciler) removeManaged() error {
+	fm := files.New(p.log, p.manifest, p.ssh)
+	if err := fm.Remove(p.manifest.Packages...); err != nil {
+		return errors.Wrapf(err, "error removing files on %s", p.manifest.ID)
+	}
+	if orphans := p.orphans(); orphans != nil {
+		p.report.Orphans = orphans
+		for _, path := range orphans.Removed {
+			if err := fm.RemoveOne(&manifest.File{Path: path}); err != nil {
+				return errors.Wrapf(err, "error removing orphaned file %s on %s", path, p.manifest.ID)
+			}
+		}
+	}
+	for _, pkg := range p.manifest.Packages {
+		for _, dir := range pkg.ConfigDirs {
+			if out, err := p.ssh.Exec(ssh.Cmd("rm", "-rf", "--", dir)); err != nil {
+				return errors.Wrapf(err, "error removing config dir %s on %s, out: %s", dir, p.manifest.ID, out)
+			}
+			p.log.Infof("removed config dir %s of package %s", dir, pkg.Name)
+		}
+	}
+	if out, err := p.ssh.Exec(ssh.Cmd("rm", "-f", state.Path)); err != nil {
+		return errors.Wrapf(err, "error removing state %s on %s, out: %s", state.Path, p.manifest.ID, out)
+	}
+	return nil
+}
+
+// verifyPurged verifies no package, file, configuration directory or running
+// service of the manifest is left on the target
+func (p *ProviderReconciler) verifyPurged(pkgs packages.Manager, svc services.Manager) error {
+	left := make([]string, 0)
+	pkglist, err := pkgs.Query()
+	if err != nil {
+		return errors.Wrap(err, "error getting packages from container")
+	}
+	paths := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pkg := range p.manifest.Packages {
+		if pkgActual, ok := pkglist[pkg.Name]; ok && pkgActual.Status != "not-installed" {
+			left = append(left, fmt.Sprintf("package %s is %s", pkg.Name, pkgActual.Status))
+		}
+		for _, f := range pkg.Files {
+			paths = append(paths, f.Path)
+		}
+		paths = append(paths, pkg.ConfigDirs...)
+		for _, s := range pkg.ServiceDefinitions() {
+			if seen[s.Name] {
+				continue
+			}
+			seen[s.Name] = true
+			status, err := svc.Status(s.Name)
+			if err != nil {
+				return errors.Wrapf(err, "error getting status of service %s", s.Name)
+			}
+			if status.Running() {
+				left = append(left, fmt.Sprintf("service %s is running", s.Name))
+			}
+		}
+	}
+	if len(paths) > 0 {
+		result, err := p.ssh.Run(ssh.Script(`for f in "$@"; do [ -e "$f" ] && echo "$f"; done; true`, paths...))
+		if err != nil {
+			return errors.Wrap(err, "error checking files are removed")
+		}
+		// paths can have spaces, each path left is on its own line
+		for _, path := range strings.Split(string(result.Stdout), "\n") {
+			if path != "" {
+				left = append(left, fmt.Sprintf("%s exists", path))
+			}
+		}
+	}
+	if len(left) > 0 {
+		return errors.Errorf("purge of %s left: %s", p.manifest.ID, strings.Join(left, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package reconcile
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/services"
+)
+
+// TestStopServices tests services of all packages, including removed
+// packages, are stopped and disabled before purge
+func TestStopServices(t *testing.T) {
+	m := &manifest.Manifest{
+		ID: "b2267d6b23",
+		Packages: []manifest.Package{
+			{Name: "nginx", Kind: manifest.PackageKindService},
+			{Name: "postgresql-15", Kind: manifest.PackageKindService, Services: []manifest.Service{
+				{Name: "postgresql"},
+			}},
+			{Name: "memcached", Kind: manifest.PackageKindService, State: manifest.PackageStateAbsent},
+			{Name: "curl"},
+		},
+	}
+	svc := &fakeServices{statuses: map[string]services.Status{
+		"nginx":      {State: services.StateRunning, Enabled: true},
+		"postgresql": {State: services.StateStopped, Enabled: true},
+		"memcached":  {State: services.StateRunning},
+	}}
+	p := New(logging.New(t.Name(), false), m, nil)
+
+	assert.NilError(t, p.stopServices(svc))
+	assert.DeepEqual(t, svc.calls, []string{"stop nginx", "disable nginx", "disable postgresql", "stop memcached"})
+	assert.Equal(t, len(p.report.ServiceActions), 4)
+}
@@ -0,0 +1,311 @@
+package reconcile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/services"
+)
+
+// Outcome is the final outcome of a run
+type Outcome string
+
+const (
+	// OutcomeSuccess the run finished without errors
+	OutcomeSuccess = Outcome("success")
+	// OutcomeFailed the run returned an error
+	OutcomeFailed = Outcome("failed")
+	// OutcomeSkipped the run was not started, like when a rollout stopped
+	OutcomeSkipped = Outcome("skipped")
+)
+
+// Report is the structured result of running reconcile for one manifest.
+// Reports are serialized to json for consumption by ci and dashboards.
+type Report struct {
+	// ManifestID is the id of the manifest
+	ManifestID string `json:"manifest_id"`
+	// Provider is the provider backend of the manifest
+	Provider manifest.ProviderBackend `json:"provider"`
+	// Host is the host:port reconcile connected to over ssh
+	Host string `json:"host,omitempty"`
+	// Operation is the operation that was run
+	Operation Operation `json:"operation"`
+	// RunID is the id of the run, files overwritten by the run are backed up under it
+	RunID string `json:"run_id,omitempty"`
+	// RollbackRunID is the id of the run restored by the rollback operation
+	RollbackRunID string `json:"rollback_run_id,omitempty"`
+	// Packages are the packages found, installed and mismatched on the target
+	Packages PackagesReport `json:"packages"`
+	// Files are the files compared and transferred to the target
+	Files []FileReport `json:"files"`
+	// ServicesRestarted are the names of services restarted
+	ServicesRestarted []string `json:"services_restarted"`
+	// ServiceActions are the actions run on services to converge them
+	ServiceActions []ServiceActionReport `json:"service_actions"`
+	// ServiceStatus is the status output of services after reconcile
+	ServiceStatus []ServiceStatusReport `json:"service_status"`
+	// HealthChecks are the results of the health checks after reconcile
+	HealthChecks []HealthCheckReport `json:"health_checks,omitempty"`
+	// Plan is the plan computed for a dry run
+	Plan *Plan `json:"plan,omitempty"`
+	// Drift is the drift detected by the drift operation
+	Drift []Drift `json:"drift,omitempty"`
+	// Orphans are resources in the state ledger no longer declared by the manifest
+	Orphans *OrphansReport `json:"orphans,omitempty"`
+	// Transaction is what was reverted when a transactional run failed
+	Transaction *TransactionReport `json:"transaction,omitempty"`
+	// Steps are the steps run with their durations
+	Steps []StepReport `json:"steps"`
+	// StartTime is when the run started
+	StartTime time.Time `json:"start_time"`
+	// DurationMillis is the duration of the whole run in milliseconds
+	DurationMillis int64 `json:"duration_ms"`
+	// Outcome is the final outcome of the run
+	Outcome Outcome `json:"outcome"`
+	// Error is the error returned by the run, when the run failed
+	Error string `json:"error,omitempty"`
+}
+
+// PackagesReport reports packages on the target
+type PackagesReport struct {
+	// Found are the desired packages found installed on the target
+	Found []string `json:"found"`
+	// Installed are the packages installed by the run
+	Installed []string `json:"installed"`
+	// Removed are the packages removed or purged by the run
+	Removed []string `json:"removed"`
+	// Held are the packages held by the run
+	Held []string `json:"held"`
+	// Unheld are the packages with holds removed by the run
+	Unheld []string `json:"unheld"`
+	// Mismatched are the packages installed with a version other than desired
+	Mismatched []PackageMismatch `json:"mismatched"`
+}
+
+// PackageMismatch is a package installed with a version other than desired
+type PackageMismatch struct {
+	// Name is the name of the package
+	Name string `json:"name"`
+	// Desired is the version from the manifest
+	Desired string `json:"desired"`
+	// Actual is the version installed on the target
+	Actual string `json:"actual"`
+}
+
+// FileReport reports one file compared with, or transferred to, the target
+type FileReport struct {
+	// Package is the name of the package the file belongs to
+	Package string `json:"package"`
+	// Path is the path on the target
+	Path string `json:"path"`
+	// Changed is true when the content of the file was changed
+	Changed bool `json:"changed"`
+	// Before is the sha256 of the file before the run, empty when it did not exist
+	Before string `json:"before,omitempty"`
+	// After is the sha256 of the rendered file
+	After string `json:"after"`
+	// Diff is a unified diff of the content when changed
+	Diff string `json:"diff,omitempty"`
+}
+
+// ServiceActionReport is an action run on a service
+type ServiceActionReport struct {
+	// Name is the name of the service
+	Name string `json:"name"`
+	// Action is the action run on the service
+	Action ServiceActionKind `json:"action"`
+	// Reason is why the action was run
+	Reason string `json:"reason"`
+}
+
+// ServiceStatusReport is the status output of one service
+type ServiceStatusReport struct {
+	// Name is the name of the service
+	Name string `json:"name"`
+	// State is the parsed state of the service
+	State services.State `json:"state"`
+	// Enabled is true when the service is started at boot
+	Enabled bool `json:"enabled"`
+	// Output is the output of the status command
+	Output string `json:"output"`
+	// Error is the error returned by the status command
+	Error string `json:"error,omitempty"`
+}
+
+// StepReport is the duration and error of one step of a run
+type StepReport struct {
+	// Name is the name of the step
+	Name string `json:"name"`
+	// DurationMillis is the duration of the step in milliseconds
+	DurationMillis int64 `json:"duration_ms"`
+	// Error is the error returned by the step
+	Error string `json:"error,omitempty"`
+}
+
+// NewReport creates a new report for a manifest and operation
+func NewReport(m *manifest.Manifest, op Operation) *Report {
+	return &Report{
+		ManifestID: m.ID,
+		Provider:   m.Provider,
+		Operation:  op,
+		Packages: PackagesReport{
+			Found:      []string{},
+			Installed:  []string{},
+			Removed:    []string{},
+			Held:       []string{},
+			Unheld:     []string{},
+			Mismatched: []PackageMismatch{},
+		},
+		Files:             []FileReport{},
+		ServicesRestarted: []string{},
+		ServiceActions:    []ServiceActionReport{},
+		ServiceStatus:     []ServiceStatusReport{},
+		Steps:             []StepReport{},
+		StartTime:         time.Now().UTC(),
+	}
+}
+
+// AddStep records a step that started at start and finished now
+func (r *Report) AddStep(name string, start time.Time, err error) {
+	step := StepReport{
+		Name:           name,
+		DurationMillis: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	r.Steps = append(r.Steps, step)
+}
+
+// AddFile records a file compared with, or transferred to, the target
+func (r *Report) AddFile(c *files.FileChange) {
+	f := FileReport{
+		Package: c.Package,
+		Path:    c.File.Path,
+		Changed: c.Content,
+		After:   c.LocalSha256,
+		Diff:    c.Diff,
+	}
+	if c.Stat != nil {
+		f.Before = c.Stat.Sha256
+	}
+	r.Files = append(r.Files, f)
+}
+
+// Drifted returns true when drift was detected on the target
+func (r *Report) Drifted() bool {
+	return len(r.Drift) > 0
+}
+
+// finish sets the outcome and duration of the run
+func (r *Report) finish(err error) {
+	r.DurationMillis = time.Since(r.StartTime).Milliseconds()
+	r.Outcome = OutcomeSuccess
+	if err != nil {
+		r.Outcome = OutcomeFailed
+		r.Error = err.Error()
+	}
+}
+
+// NewSkippedReport creates a report for a manifest that was not run, with
+// the reason it was skipped
+func NewSkippedReport(m *manifest.Manifest, op Operation, reason string) *Report {
+	r := NewReport(m, op)
+	r.Outcome = OutcomeSkipped
+	r.Error = reason
+	return r
+}
+
+// reason returns the first line of the error of the report, the reason a
+// failed or skipped run did not succeed
+func (r *Report) reason() string {
+	reason, _, _ := strings.Cut(r.Error, "\n")
+	return reason
+}
+
+// WriteSummary writes a human-readable summary table of reports to w, one
+// row per host with the reason failed and skipped hosts did not succeed.
+// Nil reports, for manifests that did not run, are skipped.
+func WriteSummary(w io.Writer, reports []*Report) error {
+	outcomes := make(map[Outcome]int)
+	total := 0
+	for _, r := range reports {
+		if r != nil {
+			outcomes[r.Outcome]++
+			total++
+		}
+	}
+	buf := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(buf, "summary: %d hosts, %d succeeded, %d failed, %d skipped\n", total,
+		outcomes[OutcomeSuccess], outcomes[OutcomeFailed], outcomes[OutcomeSkipped])
+	fmt.Fprintf(buf, "  %-16s %-8s %-8s %s\n", "MANIFEST", "PROVIDER", "OUTCOME", "REASON")
+	for _, r := range reports {
+		if r != nil {
+			fmt.Fprintf(buf, "  %-16s %-8s %-8s %s\n", r.ManifestID, r.Provider, r.Outcome, r.reason())
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// AggregateError returns err with the failed hosts of reports and their
+// reasons, so a failed rollout names every failed host. Nil is returned when
+// err is nil.
+func AggregateError(err error, reports []*Report) error {
+	if err == nil {
+		return nil
+	}
+	failed := make([]string, 0)
+	for _, r := range reports {
+		if r != nil && r.Outcome == OutcomeFailed {
+			failed = append(failed, fmt.Sprintf("%s: %s", r.ManifestID, r.reason()))
+		}
+	}
+	if len(failed) == 0 {
+		return err
+	}
+	return errors.Errorf("%v, failed hosts: %s", err, strings.Join(failed, "; "))
+}
+
+// WriteReports writes reports as an indented json array to w. Nil reports,
+// for manifests that did not run, are skipped.
+func WriteReports(w io.Writer, reports []*Report) error {
+	out := make([]*Report, 0, len(reports))
+	for _, r := range reports {
+		if r != nil {
+			out = append(out, r)
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		return errors.Wrap(err, "error encoding reports")
+	}
+	return nil
+}
+
+// WriteReportsFile writes reports as json to the file at path
+func WriteReportsFile(path string, reports []*Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "error creating report %s", path)
+	}
+	if err := WriteReports(f, reports); err != nil {
+		_ = f.Close()
+		return err
+	}
+	// a failed close can lose buffered writes, the report would be truncated
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "error closing report %s", path)
+	}
+	return nil
+}
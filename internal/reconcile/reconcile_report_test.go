@@ -0,0 +1,76 @@
+package reconcile
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+)
+
+// TestWriteReports tests reports are written as a json array with outcomes
+func TestWriteReports(t *testing.T) {
+	m := &manifest.Manifest{ID: "b2267d6b23", Provider: manifest.ProviderBackendDocker}
+	ok := NewReport(m, Reconcile)
+	ok.AddStep("files", time.Now(), nil)
+	ok.AddFile(&files.FileChange{
+		Package:     "nginx",
+		File:        manifest.File{Path: "/etc/nginx/sites-available/default"},
+		Content:     true,
+		LocalSha256: "after",
+		Stat:        &files.Stat{Sha256: "before"},
+	})
+	ok.finish(nil)
+
+	failed := NewReport(m, Reconcile)
+	failed.finish(errors.New("error on provider backend reconcile"))
+
+	buf := bytes.NewBuffer([]byte{})
+	err := WriteReports(buf, []*Report{ok, nil, failed})
+	assert.NilError(t, err, "write reports")
+
+	var decoded []map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	assert.NilError(t, err, "unmarshal reports")
+	assert.Equal(t, len(decoded), 2)
+	assert.Equal(t, decoded[0]["outcome"], string(OutcomeSuccess))
+	assert.Equal(t, decoded[1]["outcome"], string(OutcomeFailed))
+	assert.Equal(t, decoded[1]["error"], "error on provider backend reconcile")
+
+	fileReports := decoded[0]["files"].([]interface{})
+	assert.Equal(t, len(fileReports), 1)
+	fileReport := fileReports[0].(map[string]interface{})
+	assert.Equal(t, fileReport["before"], "before")
+	assert.Equal(t, fileReport["after"], "after")
+	assert.Equal(t, fileReport["changed"], true)
+}
+
+// TestSummary tests the summary table and aggregated error name every
+// failed host with its reason
+func TestSummary(t *testing.T) {
+	ok := NewReport(&manifest.Manifest{ID: "b2267d6b23", Provider: manifest.ProviderBackendDocker}, Reconcile)
+	ok.finish(nil)
+	failed := NewReport(&manifest.Manifest{ID: "a36b603b66", Provider: manifest.ProviderBackendEC2}, Reconcile)
+	failed.finish(errors.New("unhealthy on a36b603b66: http\nout: connection refused"))
+	skipped := NewSkippedReport(&manifest.Manifest{ID: "53580639", Provider: manifest.ProviderBackendLinode},
+		Reconcile, "rollout stopped")
+	reports := []*Report{ok, failed, nil, skipped}
+
+	buf := bytes.NewBuffer([]byte{})
+	assert.NilError(t, WriteSummary(buf, reports))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Equal(t, len(lines), 5)
+	assert.Equal(t, lines[0], "summary: 3 hosts, 1 succeeded, 1 failed, 1 skipped")
+	assert.Equal(t, strings.Join(strings.Fields(lines[3]), " "), "a36b603b66 ec2 failed unhealthy on a36b603b66: http")
+	assert.Equal(t, strings.Join(strings.Fields(lines[4]), " "), "53580639 linode skipped rollout stopped")
+
+	assert.NilError(t, AggregateError(nil, reports))
+	err := AggregateError(errors.New("1 of 3 hosts failed"), reports)
+	assert.Error(t, err, "1 of 3 hosts failed, failed hosts: a36b603b66: unhealthy on a36b603b66: http")
+}
@@ -0,0 +1,113 @@
+package reconcile
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/reconcile/files"
+)
+
+// runIDRE matches run ids returned by NewRunID
+var runIDRE = regexp.MustCompile(`^\d{8}T\d{6}Z-[0-9a-f]{6}$`)
+
+// runIDKey is the context key of the run id
+type runIDKey struct{}
+
+// rollbackKey is the context key of the run id to roll back
+type rollbackKey struct{}
+
+// NewRunID returns a new run id. Run ids start with the time the run
+// started, in UTC, so they sort in the order runs started.
+func NewRunID() string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// ValidateRunID returns an error when runID is not a run id returned by
+// NewRunID. The run id is a directory of the backups on the target, a run id
+// like .. would roll back files outside of the backups.
+func ValidateRunID(runID string) error {
+	if !runIDRE.MatchString(runID) {
+		return errors.Errorf("invalid run id %q, must be like 20231016T193725Z-a1b2c3", runID)
+	}
+	return nil
+}
+
+// WithRunID returns a context with the run id, used to share one run id
+// between the manifests of a run. Run creates a run id when not set.
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunIDFromContext returns the run id of the context, empty when not set
+func RunIDFromContext(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
+
+// WithRollback returns a context with the run id the Rollback operation
+// restores files from
+func WithRollback(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, rollbackKey{}, runID)
+}
+
+// rollbackFromContext returns the run id to roll back, empty when not set
+func rollbackFromContext(ctx context.Context) string {
+	runID, _ := ctx.Value(rollbackKey{}).(string)
+	return runID
+}
+
+// Rollback restores the files backed up by the run with the run id, removes
+// files the run created, then runs the notifications of the restored files.
+func (p *ProviderReconciler) Rollback(_ context.Context, runID string) error {
+	if err := ValidateRunID(runID); err != nil {
+		return err
+	}
+	p.log.Infof("rollback run %s on %s", runID, p.manifest.ID)
+	start := time.Now()
+	fm := files.New(p.log, p.manifest, p.ssh).WithBackup(p.runID)
+	restored, err := fm.Rollback(runID)
+	changes := p.restoredChanges(restored)
+	for i := range changes {
+		p.report.AddFile(&changes[i])
+	}
+	p.report.AddStep("rollback", start, err)
+	if err != nil {
+		return errors.Wrapf(err, "error rolling back run %s on %s", runID, p.manifest.ID)
+	}
+
+	svc, err := p.serviceManager()
+	if err != nil {
+		return err
+	}
+	start = time.Now()
+	err = p.convergeServices(svc, changes)
+	p.report.AddStep("converge-services", start, err)
+	return err
+}
+
+// restoredChanges returns a change for each restored path, with the package
+// and notify of the file in the manifest so notifications run as if the
+// file was transferred
+func (p *ProviderReconciler) restoredChanges(restored []string) []files.FileChange {
+	changes := make([]files.FileChange, 0, len(restored))
+	for _, path := range restored {
+		change := files.FileChange{Content: true}
+		change.File.Path = path
+		for _, pkg := range p.manifest.Packages {
+			for _, f := range pkg.Files {
+				if f.Path == path {
+					change.Package, change.Kind, change.File = pkg.Name, pkg.Kind, f
+				}
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
@@ -0,0 +1,58 @@
+package reconcile
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+	"slack-reconcile-deployments/internal/manifest"
+)
+
+func TestRunID(t *testing.T) {
+	runID := NewRunID()
+	assert.Assert(t, regexp.MustCompile(`^\d{8}T\d{6}Z-[0-9a-f]{6}$`).MatchString(runID), runID)
+	assert.NilError(t, ValidateRunID(runID))
+	assert.Equal(t, RunIDFromContext(context.Background()), "")
+	assert.Equal(t, RunIDFromContext(WithRunID(context.Background(), runID)), runID)
+}
+
+// TestValidateRunID tests run ids that are not a directory of the backups
+// are rejected before the target is changed
+func TestValidateRunID(t *testing.T) {
+	for _, runID := range []string{"", "..", "../..", "20231016T193725Z-a1b2c3/../..", "/", "20231016T193725Z",
+		"20231016T193725Z-A1B2C3", " 20231016T193725Z-a1b2c3"} {
+		assert.ErrorContains(t, ValidateRunID(runID), "invalid run id", runID)
+	}
+	assert.NilError(t, ValidateRunID("20231016T193725Z-a1b2c3"))
+
+	// run fails before the backend runs, unknown provider is not reached
+	ctx := WithRollback(context.Background(), "..")
+	_, err := Run(ctx, logging.New(t.Name(), false), io.Discard, &manifest.Manifest{ID: "b2267d6b23"}, Rollback)
+	assert.ErrorContains(t, err, "invalid run id")
+}
+
+// TestRestoredChanges tests restored files notify like transferred files
+func TestRestoredChanges(t *testing.T) {
+	notify := []manifest.Notify{{Service: "nginx", Action: manifest.ServiceActionReload}}
+	m := &manifest.Manifest{
+		ID: "b2267d6b23",
+		Packages: []manifest.Package{
+			{Name: "nginx", Kind: manifest.PackageKindService, Files: []manifest.File{
+				{Path: "/etc/nginx/sites-available/default", Notify: notify},
+			}},
+		},
+	}
+	p := New(logging.New(t.Name(), false), m, nil)
+
+	changes := p.restoredChanges([]string{"/etc/nginx/sites-available/default", "/etc/old.conf"})
+	assert.Equal(t, len(changes), 2)
+	assert.Equal(t, changes[0].Package, "nginx")
+	assert.DeepEqual(t, changes[0].File.Notify, notify)
+	assert.Check(t, changes[0].Content)
+	assert.Equal(t, changes[1].Package, "")
+	assert.Equal(t, changes[1].File.Path, "/etc/old.conf")
+}
@@ -0,0 +1,323 @@
+package reconcile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/services"
+)
+
+// ServiceActionKind is an action run on a service to converge its state
+type ServiceActionKind string
+
+const (
+	// ServiceActionStart starts a stopped service desired running
+	ServiceActionStart = ServiceActionKind("start")
+	// ServiceActionStop stops a running service desired stopped
+	ServiceActionStop = ServiceActionKind("stop")
+	// ServiceActionRestart restarts a service after its files changed
+	ServiceActionRestart = ServiceActionKind("restart")
+	// ServiceActionReload reloads a service after its files changed
+	ServiceActionReload = ServiceActionKind("reload")
+	// ServiceActionEnable enables a service at boot
+	ServiceActionEnable = ServiceActionKind("enable")
+	// ServiceActionDisable disables a service at boot
+	ServiceActionDisable = ServiceActionKind("disable")
+	// ServiceActionCommand runs the command of a notified handler
+	ServiceActionCommand = ServiceActionKind("command")
+)
+
+// serviceDefinition is a service with the package it belongs to
+type serviceDefinition struct {
+	// pkg is the name of the package
+	pkg string
+	// svc is the service definition
+	svc manifest.Service
+}
+
+// serviceAction is an action to run on a service
+type serviceAction struct {
+	// name is the name of the service
+	name string
+	// action is the action to run
+	action ServiceActionKind
+	// reason is a human-readable reason for the action
+	reason string
+	// command is the command of a handler, for ServiceActionCommand
+	command string
+	// become is the user the command of a handler runs as, root when not set
+	become string
+}
+
+// notifications are the deduplicated notifications of changed files
+type notifications struct {
+	// services are the actions of notified services, restart wins over reload
+	services map[string]manifest.ServiceAction
+	// handlers are the names of notified handlers
+	handlers map[string]bool
+	// order is the order services and handlers were first notified
+	order []manifest.Notify
+	// files are the paths of the files that notified a service or handler
+	files map[string][]string
+	// packages are packages with changed files without notify, their
+	// services run their on_change action
+	packages map[string]bool
+}
+
+// newNotifications returns the notifications of files with changed content
+func newNotifications(changes []files.FileChange) *notifications {
+	n := &notifications{
+		services: make(map[string]manifest.ServiceAction),
+		handlers: make(map[string]bool),
+		files:    make(map[string][]string),
+		packages: make(map[string]bool),
+	}
+	for _, c := range changes {
+		if !c.Content {
+			continue
+		}
+		if len(c.File.Notify) == 0 {
+			n.packages[c.Package] = true
+			continue
+		}
+		for _, notify := range c.File.Notify {
+			key := notify.Service
+			if notify.Handler != "" {
+				key = "handler:" + notify.Handler
+				if !n.handlers[notify.Handler] {
+					n.order = append(n.order, notify)
+				}
+				n.handlers[notify.Handler] = true
+			} else {
+				if _, ok := n.services[notify.Service]; !ok {
+					n.order = append(n.order, notify)
+				}
+				n.services[notify.Service] = strongest(n.services[notify.Service], notify.NotifyAction())
+			}
+			if !slices.Contains(n.files[key], c.File.Path) {
+				n.files[key] = append(n.files[key], c.File.Path)
+			}
+		}
+	}
+	return n
+}
+
+// serviceAction returns the action for a service desired running from the
+// notifications, and the on_change action when files of its package changed
+func (n *notifications) serviceAction(d serviceDefinition) (manifest.ServiceAction, string) {
+	action, reasons := manifest.ServiceActionNone, make([]string, 0)
+	if notified, ok := n.services[d.svc.Name]; ok {
+		action = notified
+		reasons = append(reasons, n.reason(d.svc.Name))
+	}
+	if n.packages[d.pkg] && d.svc.ChangeAction() != manifest.ServiceActionNone {
+		action = strongest(action, d.svc.ChangeAction())
+		reasons = append(reasons, "files changed")
+	}
+	return action, strings.Join(reasons, ", ")
+}
+
+// reason returns the reason a service or handler key was notified
+func (n *notifications) reason(key string) string {
+	return fmt.Sprintf("notified by %s", strings.Join(n.files[key], ", "))
+}
+
+// strongest returns the action that covers both actions, a restart also
+// reloads the configuration
+func strongest(a, b manifest.ServiceAction) manifest.ServiceAction {
+	for _, action := range []manifest.ServiceAction{manifest.ServiceActionRestart, manifest.ServiceActionReload} {
+		if a == action || b == action {
+			return action
+		}
+	}
+	return manifest.ServiceActionNone
+}
+
+// serviceDefinitions returns the services of packages that are not removed.
+// A service defined by more than one package is only returned once.
+func (p *ProviderReconciler) serviceDefinitions() []serviceDefinition {
+	definitions := make([]serviceDefinition, 0)
+	seen := make(map[string]bool)
+	for _, pkg := range p.manifest.Packages {
+		if pkg.Removed() {
+			continue
+		}
+		for _, svc := range pkg.ServiceDefinitions() {
+			if seen[svc.Name] {
+				continue
+			}
+			seen[svc.Name] = true
+			definitions = append(definitions, serviceDefinition{pkg: pkg.Name, svc: svc})
+		}
+	}
+	return definitions
+}
+
+// serviceActions returns the actions to converge services: start or stop
+// services not in their desired state, restart or reload running services
+// notified by changed files or of packages with changed files, enable or
+// disable services at boot, and run the commands of notified handlers.
+// Notified services that are not defined are restarted or reloaded when running.
+func (p *ProviderReconciler) serviceActions(svc services.Manager,
+	changes []files.FileChange) ([]serviceAction, error) {
+	notified := newNotifications(changes)
+	actions := make([]serviceAction, 0)
+	defined := make(map[string]bool)
+	for _, d := range p.serviceDefinitions() {
+		defined[d.svc.Name] = true
+		status, err := svc.Status(d.svc.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting status of service %s", d.svc.Name)
+		}
+		name := d.svc.Name
+		add := func(action ServiceActionKind, reason string) {
+			actions = append(actions, serviceAction{name: name, action: action, reason: reason})
+		}
+		switch {
+		case d.svc.DesiredState() == manifest.ServiceStateStopped && status.Running():
+			add(ServiceActionStop, "desired stopped")
+		case d.svc.DesiredState() == manifest.ServiceStateRunning && !status.Running():
+			add(ServiceActionStart, fmt.Sprintf("desired running, is %s", status.State))
+		case d.svc.DesiredState() == manifest.ServiceStateRunning:
+			action, reason := notified.serviceAction(d)
+			switch action {
+			case manifest.ServiceActionRestart:
+				add(ServiceActionRestart, reason)
+			case manifest.ServiceActionReload:
+				add(ServiceActionReload, reason)
+			}
+		}
+		if d.svc.Enabled != nil && *d.svc.Enabled != status.Enabled {
+			if *d.svc.Enabled {
+				add(ServiceActionEnable, "desired enabled at boot")
+			} else {
+				add(ServiceActionDisable, "desired disabled at boot")
+			}
+		}
+	}
+
+	handlers := p.handlers()
+	for _, n := range notified.order {
+		if n.Handler != "" {
+			h := handlers[n.Handler]
+			actions = append(actions, serviceAction{name: n.Handler, action: ServiceActionCommand,
+				reason: notified.reason("handler:" + n.Handler), command: h.Command, become: h.Become})
+			continue
+		}
+		if defined[n.Service] {
+			continue
+		}
+		status, err := svc.Status(n.Service)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting status of service %s", n.Service)
+		}
+		if !status.Running() {
+			p.log.Infof("service %s is %s, not notified", n.Service, status.State)
+			continue
+		}
+		action := ServiceActionRestart
+		if notified.services[n.Service] == manifest.ServiceActionReload {
+			action = ServiceActionReload
+		}
+		actions = append(actions, serviceAction{name: n.Service, action: action, reason: notified.reason(n.Service)})
+	}
+	return actions, nil
+}
+
+// handlers returns the handlers of all packages by name
+func (p *ProviderReconciler) handlers() map[string]manifest.Handler {
+	handlers := make(map[string]manifest.Handler)
+	for _, pkg := range p.manifest.Packages {
+		for _, h := range pkg.Handlers {
+			handlers[h.Name] = h
+		}
+	}
+	return handlers
+}
+
+// runServiceAction runs one service action
+func (p *ProviderReconciler) runServiceAction(svc services.Manager, a serviceAction) error {
+	switch a.action {
+	case ServiceActionStart:
+		return svc.Start(a.name)
+	case ServiceActionStop:
+		return svc.Stop(a.name)
+	case ServiceActionRestart:
+		return svc.Restart(a.name)
+	case ServiceActionReload:
+		return svc.Reload(a.name)
+	case ServiceActionEnable:
+		return svc.Enable(a.name)
+	case ServiceActionDisable:
+		return svc.Disable(a.name)
+	case ServiceActionCommand:
+		client := p.ssh
+		if a.become != "" {
+			client = p.ssh.AsUser(a.become)
+		}
+		_, err := client.Streamf("%s", a.command)
+		return err
+	}
+	return errors.Errorf("unknown service action %s", a.action)
+}
+
+// convergeServices runs the service actions and records them in the report
+func (p *ProviderReconciler) convergeServices(svc services.Manager, changes []files.FileChange) error {
+	actions, err := p.serviceActions(svc, changes)
+	if err != nil {
+		return err
+	}
+	for _, a := range actions {
+		p.log.Infof("service %s %s, %s", a.action, a.name, a.reason)
+		if p.tx != nil && a.action != ServiceActionCommand {
+			if err := p.tx.recordService(svc, a.name); err != nil {
+				return err
+			}
+		}
+		if err := p.runServiceAction(svc, a); err != nil {
+			return errors.Wrapf(err, "error on service %s %s on %s", a.action, a.name, p.manifest.ID)
+		}
+		p.report.ServiceActions = append(p.report.ServiceActions,
+			ServiceActionReport{Name: a.name, Action: a.action, Reason: a.reason})
+		if a.action == ServiceActionRestart {
+			p.report.ServicesRestarted = append(p.report.ServicesRestarted, a.name)
+		}
+	}
+	return nil
+}
+
+// checkServices checks the status of services and records it in the
+// report. An error is returned when a service is not in its desired state.
+func (p *ProviderReconciler) checkServices(svc services.Manager) error {
+	failed := make([]string, 0)
+	for _, d := range p.serviceDefinitions() {
+		report := ServiceStatusReport{Name: d.svc.Name}
+		status, err := svc.Status(d.svc.Name)
+		if err != nil {
+			report.State = services.StateUnknown
+			report.Error = err.Error()
+			failed = append(failed, fmt.Sprintf("%s: %v", d.svc.Name, err))
+			p.report.ServiceStatus = append(p.report.ServiceStatus, report)
+			continue
+		}
+		report.State, report.Enabled, report.Output = status.State, status.Enabled, status.Output
+		if status.Running() != (d.svc.DesiredState() == manifest.ServiceStateRunning) {
+			report.Error = fmt.Sprintf("service %s is %s, desired %s",
+				d.svc.Name, status.State, d.svc.DesiredState())
+			failed = append(failed, fmt.Sprintf("%s is %s", d.svc.Name, status.State))
+		} else {
+			p.log.Infof("service %s is %s, enabled: %v", d.svc.Name, status.State, status.Enabled)
+		}
+		p.report.ServiceStatus = append(p.report.ServiceStatus, report)
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("services not in desired state on %s: %s",
+			p.manifest.ID, strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package reconcile
+
+import (
+	"fmt"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/services"
+)
+
+// fakeServices is a service manager with fixed statuses, recording the
+// actions run
+type fakeServices struct {
+	services.Manager
+	statuses map[string]services.Status
+	calls    []string
+}
+
+// Stop records the stop
+func (f *fakeServices) Stop(name string) error {
+	f.calls = append(f.calls, "stop "+name)
+	return nil
+}
+
+// Disable records the disable
+func (f *fakeServices) Disable(name string) error {
+	f.calls = append(f.calls, "disable "+name)
+	return nil
+}
+
+// Status returns the fixed status of a service, not-found when not set
+func (f *fakeServices) Status(name string) (*services.Status, error) {
+	status, ok := f.statuses[name]
+	if !ok {
+		return &services.Status{Name: name, State: services.StateNotFound}, nil
+	}
+	return &status, nil
+}
+
+// TestServiceActions tests services are converged to their desired state
+func TestServiceActions(t *testing.T) {
+	enabled, disabled := true, false
+	m := &manifest.Manifest{
+		ID: "b2267d6b23",
+		Packages: []manifest.Package{
+			{Name: "nginx", Kind: manifest.PackageKindService},
+			{Name: "php8.2-fpm", Kind: manifest.PackageKindService, Services: []manifest.Service{
+				{Name: "php8.2-fpm", OnChange: manifest.ServiceActionReload, Enabled: &enabled},
+			}},
+			{Name: "postgresql-15", Kind: manifest.PackageKindService, Services: []manifest.Service{
+				{Name: "postgresql", State: manifest.ServiceStateStopped, Enabled: &disabled},
+			}},
+			{Name: "redis-server", Kind: manifest.PackageKindService},
+			{Name: "nginx-extras", Services: []manifest.Service{{Name: "nginx"}}},
+			{Name: "memcached", Kind: manifest.PackageKindService, State: manifest.PackageStateAbsent},
+		},
+	}
+	svc := &fakeServices{statuses: map[string]services.Status{
+		"nginx":      {State: services.StateRunning, Enabled: true},
+		"php8.2-fpm": {State: services.StateRunning},
+		"postgresql": {State: services.StateRunning, Enabled: true},
+		"memcached":  {State: services.StateStopped},
+	}}
+	changes := []files.FileChange{
+		{Package: "nginx", Content: true},
+		{Package: "php8.2-fpm", Content: true},
+		{Package: "redis-server", Mode: true},
+	}
+	p := New(logging.New(t.Name(), false), m, nil)
+
+	assert.Equal(t, len(p.serviceDefinitions()), 4)
+	actions, err := p.serviceActions(svc, changes)
+	assert.NilError(t, err)
+	got := make([]string, 0, len(actions))
+	for _, a := range actions {
+		got = append(got, fmt.Sprintf("%s %s: %s", a.action, a.name, a.reason))
+	}
+	assert.DeepEqual(t, got, []string{
+		"restart nginx: files changed",
+		"reload php8.2-fpm: files changed",
+		"enable php8.2-fpm: desired enabled at boot",
+		"stop postgresql: desired stopped",
+		"disable postgresql: desired disabled at boot",
+		"start redis-server: desired running, is not-found",
+	})
+}
+
+// TestServiceActionsNotify tests notifications of changed files are
+// deduplicated, with restart winning over reload
+func TestServiceActionsNotify(t *testing.T) {
+	m := &manifest.Manifest{
+		ID: "b2267d6b23",
+		Packages: []manifest.Package{
+			{Name: "nginx", Kind: manifest.PackageKindService, Handlers: []manifest.Handler{
+				{Name: "nginx-test", Command: "nginx -t"},
+			}},
+			{Name: "php8.2-fpm", Kind: manifest.PackageKindService},
+			{Name: "redis-server", Kind: manifest.PackageKindService},
+		},
+	}
+	svc := &fakeServices{statuses: map[string]services.Status{
+		"nginx":        {State: services.StateRunning},
+		"php8.2-fpm":   {State: services.StateRunning},
+		"redis-server": {State: services.StateRunning},
+		"cron":         {State: services.StateRunning},
+	}}
+	reload := []manifest.Notify{{Service: "nginx", Action: manifest.ServiceActionReload}, {Handler: "nginx-test"}}
+	changes := []files.FileChange{
+		{Package: "nginx", Content: true, File: manifest.File{Path: "/etc/nginx/nginx.conf", Notify: reload}},
+		{Package: "nginx", Content: true, File: manifest.File{Path: "/etc/nginx/sites-enabled/default", Notify: reload}},
+		{Package: "nginx", Content: true, File: manifest.File{Path: "/etc/php/8.2/fpm/php.ini", Notify: []manifest.Notify{
+			{Service: "php8.2-fpm", Action: manifest.ServiceActionReload},
+			{Service: "cron"},
+		}}},
+		{Package: "php8.2-fpm", Content: true, File: manifest.File{Path: "/etc/php/8.2/fpm/pool.d/www.conf"}},
+		{Package: "redis-server", Mode: true, File: manifest.File{Path: "/etc/redis/redis.conf", Notify: reload}},
+	}
+	p := New(logging.New(t.Name(), false), m, nil)
+
+	actions, err := p.serviceActions(svc, changes)
+	assert.NilError(t, err)
+	got := make([]string, 0, len(actions))
+	for _, a := range actions {
+		got = append(got, fmt.Sprintf("%s %s: %s", a.action, a.name, a.reason))
+	}
+	assert.DeepEqual(t, got, []string{
+		"reload nginx: notified by /etc/nginx/nginx.conf, /etc/nginx/sites-enabled/default",
+		"restart php8.2-fpm: notified by /etc/php/8.2/fpm/php.ini, files changed",
+		"command nginx-test: notified by /etc/nginx/nginx.conf, /etc/nginx/sites-enabled/default",
+		"restart cron: notified by /etc/php/8.2/fpm/php.ini",
+	})
+	assert.Equal(t, actions[2].command, "nginx -t")
+}
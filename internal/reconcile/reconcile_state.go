@@ -0,0 +1,144 @@
+package reconcile
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/state"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// OrphansReport reports resources in the state ledger of the target that
+// are no longer declared by the manifest
+type OrphansReport struct {
+	// Removed are orphaned files created by reconcile, which were removed
+	Removed []string `json:"removed"`
+	// Files are orphaned files that existed before reconcile managed them, left in place
+	Files []string `json:"files"`
+	// Packages are orphaned packages, left installed
+	Packages []string `json:"packages"`
+}
+
+// readLedger reads the state ledger of the target
+func (p *ProviderReconciler) readLedger() error {
+	start := time.Now()
+	ledger, err := state.Read(p.ssh)
+	p.report.AddStep("read-state", start, err)
+	if err != nil {
+		return errors.Wrapf(err, "error reading state on %s", p.manifest.ID)
+	}
+	if ledger != nil && ledger.ManifestID != p.manifest.ID {
+		p.log.Warnf("state on %s was written by manifest %s", p.manifest.ID, ledger.ManifestID)
+	}
+	p.ledger = ledger
+	return nil
+}
+
+// ownedFiles returns the packages with only the files reconcile owns, so
+// removing packages does not remove files reconcile did not create
+func (p *ProviderReconciler) ownedFiles(pkgs []manifest.Package) []manifest.Package {
+	owned := make([]manifest.Package, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		pkgFiles := make([]manifest.File, 0, len(pkg.Files))
+		for _, f := range pkg.Files {
+			if p.ledger.Owns(f.Path) {
+				pkgFiles = append(pkgFiles, f)
+			} else {
+				p.log.Infof("not removing file %s, not created by reconcile", f.Path)
+			}
+		}
+		pkg.Files = pkgFiles
+		owned = append(owned, pkg)
+	}
+	return owned
+}
+
+// orphans returns the orphans of the ledger, nil when there are none
+func (p *ProviderReconciler) orphans() *OrphansReport {
+	orphanFiles, orphanPackages := p.ledger.Orphans(p.manifest)
+	if len(orphanFiles) == 0 && len(orphanPackages) == 0 {
+		return nil
+	}
+	orphans := &OrphansReport{Removed: []string{}, Files: []string{}, Packages: []string{}}
+	for _, f := range orphanFiles {
+		if f.Created {
+			orphans.Removed = append(orphans.Removed, f.Path)
+		} else {
+			orphans.Files = append(orphans.Files, f.Path)
+		}
+	}
+	for _, pkg := range orphanPackages {
+		orphans.Packages = append(orphans.Packages, pkg.Name)
+	}
+	return orphans
+}
+
+// removeOrphans removes orphaned files created by reconcile. Other orphaned
+// files and orphaned packages are only reported.
+func (p *ProviderReconciler) removeOrphans() error {
+	orphans := p.orphans()
+	if orphans == nil {
+		return nil
+	}
+	p.report.Orphans = orphans
+	start := time.Now()
+	// orphans are backed up like overwritten files, for rollback
+	fm := files.New(p.log, p.manifest, p.ssh).WithBackup(p.runID)
+	for _, path := range orphans.Removed {
+		f := &manifest.File{Path: path}
+		stat, err := fm.Stat(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = fm.Backup(f, stat)
+		}
+		if err == nil {
+			err = fm.RemoveOne(f)
+		}
+		if err != nil {
+			p.report.AddStep("remove-orphans", start, err)
+			return errors.Wrapf(err, "error removing orphaned file %s on %s", path, p.manifest.ID)
+		}
+		p.log.Infof("removed orphaned file %s, no longer declared", path)
+	}
+	for _, path := range orphans.Files {
+		p.log.Infof("orphaned file %s is no longer declared, left in place, it was not created by reconcile", path)
+	}
+	for _, name := range orphans.Packages {
+		p.log.Infof("orphaned package %s is no longer declared, left installed", name)
+	}
+	p.report.AddStep("remove-orphans", start, nil)
+	return nil
+}
+
+// writeLedger writes the state ledger of the target with the files compared
+// and transferred by the run
+func (p *ProviderReconciler) writeLedger(changes []files.FileChange) error {
+	start := time.Now()
+	err := state.Write(p.log, p.ssh, state.New(p.manifest, p.runID, changes, p.ledger))
+	p.report.AddStep("write-state", start, err)
+	if err != nil {
+		return errors.Wrapf(err, "error writing state on %s", p.manifest.ID)
+	}
+	if p.tx != nil {
+		p.tx.ledger = true
+	}
+	return nil
+}
+
+// restoreLedger restores the state ledger read at the start of the run,
+// the ledger is removed when the target had none
+func (p *ProviderReconciler) restoreLedger() error {
+	if p.ledger != nil {
+		return state.Write(p.log, p.ssh, p.ledger)
+	}
+	if out, err := p.ssh.Exec(ssh.Cmd("rm", "-f", state.Path)); err != nil {
+		return errors.Wrapf(err, "error removing state %s, out: %s", state.Path, out)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package reconcile
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/state"
+)
+
+// TestOwnedFiles tests only files created by reconcile are removed with packages
+func TestOwnedFiles(t *testing.T) {
+	m := &manifest.Manifest{
+		ID: "b2267d6b23",
+		Packages: []manifest.Package{
+			{Name: "php8.2-fpm", State: manifest.PackageStateAbsent, Files: []manifest.File{
+				{Path: "/etc/php/8.2/fpm/php.ini"},
+				{Path: "/var/www/html/info.php"},
+			}},
+		},
+	}
+	p := New(logging.New(t.Name(), false), m, nil)
+	assert.Equal(t, len(p.ownedFiles(m.Packages)[0].Files), 2, "without a ledger all files are owned")
+
+	p.ledger = &state.Ledger{Files: []state.File{
+		{Path: "/etc/php/8.2/fpm/php.ini", Package: "php8.2-fpm"},
+		{Path: "/var/www/html/info.php", Package: "php8.2-fpm", Created: true},
+		{Path: "/etc/nginx/conf.d/old.conf", Package: "nginx", Created: true},
+		{Path: "/etc/nginx/nginx.conf", Package: "nginx"},
+	}, Packages: []state.Package{{Name: "nginx"}, {Name: "php8.2-fpm"}}}
+	owned := p.ownedFiles(m.Packages)
+	assert.DeepEqual(t, owned[0].Files, []manifest.File{{Path: "/var/www/html/info.php"}})
+	assert.Equal(t, len(m.Packages[0].Files), 2, "manifest files are not changed")
+
+	assert.DeepEqual(t, p.orphans(), &OrphansReport{
+		Removed:  []string{"/etc/nginx/conf.d/old.conf"},
+		Files:    []string{"/etc/nginx/nginx.conf"},
+		Packages: []string{"nginx"},
+	})
+}
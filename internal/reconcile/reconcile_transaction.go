@@ -0,0 +1,182 @@
+package reconcile
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/reconcile/services"
+)
+
+// transactionalKey is the context key of transactional mode
+type transactionalKey struct{}
+
+// WithTransactional returns a context with transactional mode. In
+// transactional mode a failed Reconcile reverts the file changes of the run
+// and restores the state of services before returning the error.
+func WithTransactional(ctx context.Context, transactional bool) context.Context {
+	return context.WithValue(ctx, transactionalKey{}, transactional)
+}
+
+// transactionalFromContext returns true when transactional mode is set
+func transactionalFromContext(ctx context.Context) bool {
+	transactional, _ := ctx.Value(transactionalKey{}).(bool)
+	return transactional
+}
+
+// TransactionReport reports what a failed transactional run reverted
+type TransactionReport struct {
+	// Reverted is true when the mutations of the run were reverted
+	Reverted bool `json:"reverted"`
+	// Files are the paths of files restored from backup or removed
+	Files []string `json:"files"`
+	// Permissions are the paths of files with mode and ownership restored
+	Permissions []string `json:"permissions"`
+	// ServiceActions are the actions run to restore the state of services
+	ServiceActions []ServiceActionReport `json:"service_actions"`
+	// Error is the error reverting, the host is left partially reverted
+	Error string `json:"error,omitempty"`
+}
+
+// transaction records the mutations of a run, to revert them on failure
+type transaction struct {
+	// changes are the files compared and transferred by the run
+	changes []files.FileChange
+	// services are the status of services before the first action on them
+	services map[string]*services.Status
+	// order is the order services were first acted on
+	order []string
+	// ledger is true when the state ledger was written
+	ledger bool
+}
+
+// newTransaction creates a new transaction with no mutations
+func newTransaction() *transaction {
+	return &transaction{services: make(map[string]*services.Status)}
+}
+
+// recordService records the status of a service before an action on it
+func (t *transaction) recordService(svc services.Manager, name string) error {
+	if _, ok := t.services[name]; ok {
+		return nil
+	}
+	status, err := svc.Status(name)
+	if err != nil {
+		return errors.Wrapf(err, "error recording status of service %s", name)
+	}
+	t.services[name] = status
+	t.order = append(t.order, name)
+	return nil
+}
+
+// revert reverts the mutations recorded in the transaction: files with
+// changed content and removed orphans are restored from the backups of the
+// run, or removed when created by the run, permissions of other files and
+// the state ledger are restored, then services
+// are restored to their state before the run. Running services are restarted
+// to load the restored files. The reverted mutations are recorded in the report.
+func (p *ProviderReconciler) revert() error {
+	report := &TransactionReport{
+		Files:          []string{},
+		Permissions:    []string{},
+		ServiceActions: []ServiceActionReport{},
+	}
+	p.report.Transaction = report
+	start := time.Now()
+	err := p.revertTransaction(report)
+	p.report.AddStep("revert", start, err)
+	if err != nil {
+		report.Error = err.Error()
+		return err
+	}
+	report.Reverted = true
+	return nil
+}
+
+// revertTransaction reverts the transaction for revert
+func (p *ProviderReconciler) revertTransaction(report *TransactionReport) error {
+	// the file manager does not back up, the backups of the run are restored
+	fm := files.New(p.log, p.manifest, p.ssh)
+	// orphans removed by the run are in the backups of the run too
+	content := p.report.Orphans != nil && len(p.report.Orphans.Removed) > 0
+	for _, c := range p.tx.changes {
+		content = content || c.Content
+	}
+	if content {
+		restored, err := fm.Rollback(p.runID)
+		report.Files = append(report.Files, restored...)
+		if err != nil {
+			return errors.Wrapf(err, "error restoring files of run %s", p.runID)
+		}
+	}
+	for _, c := range p.tx.changes {
+		if c.Content || c.Stat == nil {
+			continue
+		}
+		if err := fm.RestorePermissions(c.File.Path, c.Stat); err != nil {
+			return err
+		}
+		report.Permissions = append(report.Permissions, c.File.Path)
+	}
+	if p.tx.ledger {
+		if err := p.restoreLedger(); err != nil {
+			return err
+		}
+	}
+
+	if len(p.tx.order) == 0 {
+		return nil
+	}
+	svc, err := p.serviceManager()
+	if err != nil {
+		return err
+	}
+	failed := make([]string, 0)
+	for _, name := range p.tx.order {
+		for _, a := range p.restoreActions(svc, name, p.tx.services[name]) {
+			p.log.Infof("revert service %s %s, %s", a.action, a.name, a.reason)
+			if err := p.runServiceAction(svc, a); err != nil {
+				failed = append(failed, errors.Wrapf(err, "%s %s", a.action, a.name).Error())
+				continue
+			}
+			report.ServiceActions = append(report.ServiceActions,
+				ServiceActionReport{Name: a.name, Action: a.action, Reason: a.reason})
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("error restoring services: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// restoreActions returns the actions to restore a service to its status
+// before the run. A service running before the run is restarted, so it runs
+// with the restored files.
+func (p *ProviderReconciler) restoreActions(svc services.Manager, name string,
+	before *services.Status) []serviceAction {
+	actions := make([]serviceAction, 0)
+	current, err := svc.Status(name)
+	if err != nil {
+		p.log.Warnf("unable to get status of service %s: %v", name, err)
+		current = &services.Status{Name: name, State: services.StateUnknown}
+	}
+	switch {
+	case before.Running():
+		actions = append(actions, serviceAction{name: name, action: ServiceActionRestart, reason: "revert, was running"})
+	case current.Running():
+		actions = append(actions, serviceAction{name: name, action: ServiceActionStop, reason: "revert, was " +
+			string(before.State)})
+	}
+	if current.Enabled != before.Enabled {
+		if before.Enabled {
+			actions = append(actions, serviceAction{name: name, action: ServiceActionEnable, reason: "revert, was enabled"})
+		} else {
+			actions = append(actions, serviceAction{name: name, action: ServiceActionDisable,
+				reason: "revert, was disabled"})
+		}
+	}
+	return actions
+}
@@ -0,0 +1,60 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/services"
+)
+
+// TestRestoreActions tests services are restored to their status before
+// the run
+func TestRestoreActions(t *testing.T) {
+	tests := []struct {
+		before  services.Status
+		current services.Status
+		want    []string
+	}{
+		{before: services.Status{State: services.StateRunning}, current: services.Status{State: services.StateFailed},
+			want: []string{"restart: revert, was running"}},
+		{before: services.Status{State: services.StateStopped}, current: services.Status{State: services.StateRunning},
+			want: []string{"stop: revert, was stopped"}},
+		{before: services.Status{State: services.StateStopped}, current: services.Status{State: services.StateStopped},
+			want: []string{}},
+		{before: services.Status{State: services.StateRunning, Enabled: true},
+			current: services.Status{State: services.StateRunning},
+			want:    []string{"restart: revert, was running", "enable: revert, was enabled"}},
+		{before: services.Status{State: services.StateStopped},
+			current: services.Status{State: services.StateStopped, Enabled: true},
+			want:    []string{"disable: revert, was disabled"}},
+	}
+	p := New(logging.New(t.Name(), false), &manifest.Manifest{ID: "b2267d6b23"}, nil)
+	for _, tt := range tests {
+		svc := &fakeServices{statuses: map[string]services.Status{"nginx": tt.current}}
+		got := make([]string, 0)
+		for _, a := range p.restoreActions(svc, "nginx", &tt.before) {
+			got = append(got, fmt.Sprintf("%s: %s", a.action, a.reason))
+		}
+		assert.DeepEqual(t, got, tt.want)
+	}
+}
+
+// TestTransactionRecordService tests the status of a service is recorded
+// once, before the first action on it
+func TestTransactionRecordService(t *testing.T) {
+	svc := &fakeServices{statuses: map[string]services.Status{"nginx": {State: services.StateRunning}}}
+	tx := newTransaction()
+	assert.NilError(t, tx.recordService(svc, "nginx"))
+	svc.statuses["nginx"] = services.Status{State: services.StateFailed}
+	assert.NilError(t, tx.recordService(svc, "nginx"))
+	assert.DeepEqual(t, tx.order, []string{"nginx"})
+	assert.Equal(t, tx.services["nginx"].State, services.StateRunning)
+
+	assert.Check(t, transactionalFromContext(WithTransactional(context.Background(), true)))
+	assert.Check(t, !transactionalFromContext(context.Background()))
+}
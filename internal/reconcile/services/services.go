@@ -0,0 +1,135 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	cryptossh "golang.org/x/crypto/ssh"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// State is the parsed state of a service
+type State string
+
+const (
+	// StateRunning the service is running
+	StateRunning = State("running")
+	// StateStopped the service is not running
+	StateStopped = State("stopped")
+	// StateFailed the service stopped with an error, or crashed
+	StateFailed = State("failed")
+	// StateNotFound there is no service with the name
+	StateNotFound = State("not-found")
+	// StateUnknown the state could not be determined from the status
+	StateUnknown = State("unknown")
+)
+
+// Status is the status of one service
+type Status struct {
+	// Name is the name of the service
+	Name string
+	// State is the parsed state of the service
+	State State
+	// Enabled is true when the service is started at boot
+	Enabled bool
+	// Output is the output of the status command
+	Output string
+}
+
+// Running returns true when the service is running
+func (s *Status) Running() bool {
+	return s.State == StateRunning
+}
+
+// Manager manages services on a remote system over ssh with the init system
+// of the system, like systemd, sysvinit or OpenRC.
+type Manager interface {
+	// Name returns the name of the service manager
+	Name() manifest.ServiceManager
+	// Start starts a service
+	Start(name string) error
+	// Stop stops a service
+	Stop(name string) error
+	// Restart stops and starts a service
+	Restart(name string) error
+	// Reload reloads the configuration of a service without stopping it
+	Reload(name string) error
+	// Status returns the parsed status of a service. A stopped or failed
+	// service is not an error, an error is returned when the status cannot be read.
+	Status(name string) (*Status, error)
+	// Enable enables a service to start at boot
+	Enable(name string) error
+	// Disable disables a service from starting at boot
+	Disable(name string) error
+}
+
+// New creates the service manager for a host. The service manager in the
+// manifest is used when set, otherwise it is detected on the target.
+func New(log *zap.SugaredLogger, m *manifest.Manifest, sshClient *ssh.Client) (Manager, error) {
+	name := m.ServiceManager
+	if name == "" {
+		// pid 1 is systemd when systemd is running, docker containers run
+		// sshd or a shell as pid 1, without systemd or OpenRC
+		out, err := sshClient.Execf(`cat /proc/1/comm; command -v rc-service || true`)
+		if err != nil {
+			return nil, errors.Wrap(err, "error detecting service manager")
+		}
+		name = Detect(out)
+	}
+	log.Infof("using service manager %s for %s", name, m.ID)
+	switch name {
+	case manifest.ServiceManagerSystemd:
+		return NewSystemd(log, sshClient), nil
+	case manifest.ServiceManagerSysvinit:
+		return NewSysvinit(log, sshClient), nil
+	case manifest.ServiceManagerOpenRC:
+		return NewOpenRC(log, sshClient), nil
+	}
+	return nil, errors.Errorf("unknown service manager %s", name)
+}
+
+// Detect returns the service manager from the name of pid 1, followed by the
+// path of rc-service when OpenRC is installed
+func Detect(out []byte) manifest.ServiceManager {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	switch {
+	case strings.TrimSpace(lines[0]) == "systemd":
+		return manifest.ServiceManagerSystemd
+	case len(lines) > 1 && strings.HasSuffix(strings.TrimSpace(lines[1]), "rc-service"):
+		return manifest.ServiceManagerOpenRC
+	}
+	return manifest.ServiceManagerSysvinit
+}
+
+// exitStatus returns the exit status of a command that did not exit cleanly
+func exitStatus(err error) (int, bool) {
+	var exitErr *cryptossh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
+
+// lsbState returns the state for the exit status of an LSB init script
+// status action, used by sysvinit and OpenRC
+//
+// 0 program is running
+// 1 program is dead and /var/run pid file exists
+// 2 program is dead and /var/lock lock file exists
+// 3 program is not running
+// 4 program or service status is unknown
+func lsbState(code int) State {
+	switch code {
+	case 0:
+		return StateRunning
+	case 1, 2:
+		return StateFailed
+	case 3:
+		return StateStopped
+	}
+	return StateUnknown
+}
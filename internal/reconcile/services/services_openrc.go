@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// verify openrc implements interface for service managers
+var _ Manager = &OpenRC{}
+
+// OpenRC manages services with rc-service and rc-update, for alpine
+type OpenRC struct {
+	// log is the logger
+	log *zap.SugaredLogger
+	// ssh client to invoke service commands on
+	ssh *ssh.Client
+}
+
+// NewOpenRC creates a new OpenRC service manager
+func NewOpenRC(log *zap.SugaredLogger, ssh *ssh.Client) *OpenRC {
+	return &OpenRC{log: log, ssh: ssh}
+}
+
+// Name returns openrc
+func (o *OpenRC) Name() manifest.ServiceManager {
+	return manifest.ServiceManagerOpenRC
+}
+
+// Start starts a service
+func (o *OpenRC) Start(name string) error {
+	return o.run(ssh.Cmd("rc-service", name, "start"))
+}
+
+// Stop stops a service
+func (o *OpenRC) Stop(name string) error {
+	return o.run(ssh.Cmd("rc-service", name, "stop"))
+}
+
+// Restart restarts a service
+func (o *OpenRC) Restart(name string) error {
+	return o.run(ssh.Cmd("rc-service", name, "restart"))
+}
+
+// Reload reloads a service
+func (o *OpenRC) Reload(name string) error {
+	return o.run(ssh.Cmd("rc-service", name, "reload"))
+}
+
+// Enable adds a service to the default runlevel
+func (o *OpenRC) Enable(name string) error {
+	return o.run(ssh.Cmd("rc-update", "add", name, "default"))
+}
+
+// Disable removes a service from the default runlevel
+func (o *OpenRC) Disable(name string) error {
+	return o.run(ssh.Cmd("rc-update", "del", name, "default"))
+}
+
+// Status returns the status of a service from the exit status of
+// rc-service status, and enabled from rc-update show default
+func (o *OpenRC) Status(name string) (*Status, error) {
+	status := &Status{Name: name}
+	out, err := o.ssh.Exec(ssh.Cmd("rc-service", name, "status"))
+	status.Output = string(out)
+	code, ok := exitStatus(err)
+	if err != nil && !ok {
+		return nil, errors.Wrapf(err, "error on rc-service %s status", name)
+	}
+	status.State = lsbState(code)
+	if strings.Contains(status.Output, "status: crashed") {
+		status.State = StateFailed
+	}
+
+	runlevel, err := o.ssh.Exec(ssh.Cmd("rc-update", "show", "default"))
+	if err != nil {
+		o.log.Infof("warning: unable to show default runlevel: %v", err)
+		return status, nil
+	}
+	status.Enabled = openRCEnabled(name, runlevel)
+	return status, nil
+}
+
+// run runs an OpenRC command for a service
+func (o *OpenRC) run(cmd *ssh.Command) error {
+	_, err := o.ssh.Stream(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "error on %s", cmd)
+	}
+	return nil
+}
+
+// openRCEnabled returns true when the service is in the output of rc-update show.
+//
+// example:
+//
+//	nginx | default
+//	sshd | default
+func openRCEnabled(name string, out []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		service, _, _ := strings.Cut(scanner.Text(), "|")
+		if strings.TrimSpace(service) == name {
+			return true
+		}
+	}
+	return false
+}
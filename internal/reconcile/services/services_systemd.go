@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// verify systemd implements interface for service managers
+var _ Manager = &Systemd{}
+
+// Systemd manages services with systemctl
+type Systemd struct {
+	// log is the logger
+	log *zap.SugaredLogger
+	// ssh client to invoke service commands on
+	ssh *ssh.Client
+}
+
+// NewSystemd creates a new systemd service manager
+func NewSystemd(log *zap.SugaredLogger, ssh *ssh.Client) *Systemd {
+	return &Systemd{log: log, ssh: ssh}
+}
+
+// Name returns systemd
+func (s *Systemd) Name() manifest.ServiceManager {
+	return manifest.ServiceManagerSystemd
+}
+
+// Start starts a service
+func (s *Systemd) Start(name string) error {
+	return s.systemctl("start", name)
+}
+
+// Stop stops a service
+func (s *Systemd) Stop(name string) error {
+	return s.systemctl("stop", name)
+}
+
+// Restart restarts a service
+func (s *Systemd) Restart(name string) error {
+	return s.systemctl("restart", name)
+}
+
+// Reload reloads a service
+func (s *Systemd) Reload(name string) error {
+	return s.systemctl("reload", name)
+}
+
+// Enable enables a service at boot
+func (s *Systemd) Enable(name string) error {
+	return s.systemctl("enable", name)
+}
+
+// Disable disables a service at boot
+func (s *Systemd) Disable(name string) error {
+	return s.systemctl("disable", name)
+}
+
+// Status returns the status of a service from systemctl show, which exits 0
+// for stopped, failed and unknown services
+func (s *Systemd) Status(name string) (*Status, error) {
+	out, err := s.ssh.Exec(ssh.Cmd("systemctl", "show", "-p", "LoadState,ActiveState,SubState,UnitFileState", name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error on systemctl show %s", name)
+	}
+	return parseSystemdShow(name, out), nil
+}
+
+// systemctl runs a systemctl action for a service
+func (s *Systemd) systemctl(action, name string) error {
+	_, err := s.ssh.Stream(ssh.Cmd("systemctl", action, name))
+	if err != nil {
+		return errors.Wrapf(err, "error on systemctl %s %s", action, name)
+	}
+	return nil
+}
+
+// parseSystemdShow parses the properties from systemctl show.
+//
+// example:
+//
+//	LoadState=loaded
+//	ActiveState=active
+//	SubState=running
+//	UnitFileState=enabled
+func parseSystemdShow(name string, out []byte) *Status {
+	properties := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "="); ok {
+			properties[key] = value
+		}
+	}
+	status := &Status{
+		Name:    name,
+		State:   StateUnknown,
+		Enabled: properties["UnitFileState"] == "enabled",
+		Output:  string(out),
+	}
+	switch {
+	case properties["LoadState"] == "not-found":
+		status.State = StateNotFound
+	case properties["ActiveState"] == "active" || properties["ActiveState"] == "reloading":
+		status.State = StateRunning
+	case properties["ActiveState"] == "failed":
+		status.State = StateFailed
+	case properties["ActiveState"] == "inactive":
+		status.State = StateStopped
+	}
+	return status
+}
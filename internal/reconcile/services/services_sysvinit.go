@@ -0,0 +1,111 @@
+package services
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// verify sysvinit implements interface for service managers
+var _ Manager = &Sysvinit{}
+
+// Sysvinit manages services with init scripts and the service command. Docker
+// containers do not run systemd, so sysvinit is used in docker.
+type Sysvinit struct {
+	// log is the logger
+	log *zap.SugaredLogger
+	// ssh client to invoke service commands on
+	ssh *ssh.Client
+}
+
+// NewSysvinit creates a new sysvinit service manager
+func NewSysvinit(log *zap.SugaredLogger, ssh *ssh.Client) *Sysvinit {
+	return &Sysvinit{log: log, ssh: ssh}
+}
+
+// Name returns sysvinit
+func (s *Sysvinit) Name() manifest.ServiceManager {
+	return manifest.ServiceManagerSysvinit
+}
+
+// Start starts a service
+func (s *Sysvinit) Start(name string) error {
+	return s.service("start", name)
+}
+
+// Stop stops a service
+func (s *Sysvinit) Stop(name string) error {
+	return s.service("stop", name)
+}
+
+// Restart restarts a service
+func (s *Sysvinit) Restart(name string) error {
+	return s.service("restart", name)
+}
+
+// Reload reloads a service
+func (s *Sysvinit) Reload(name string) error {
+	return s.service("reload", name)
+}
+
+// Enable enables a service at boot with update-rc.d on debian, chkconfig otherwise
+func (s *Sysvinit) Enable(name string) error {
+	return s.rc(name, `update-rc.d "$1" defaults && update-rc.d "$1" enable`, `chkconfig "$1" on`)
+}
+
+// Disable disables a service at boot with update-rc.d on debian, chkconfig otherwise
+func (s *Sysvinit) Disable(name string) error {
+	return s.rc(name, `update-rc.d "$1" disable`, `chkconfig "$1" off`)
+}
+
+// Status returns the status of a service from the exit status of service
+// status, and enabled from the start links in /etc/rc3.d
+func (s *Sysvinit) Status(name string) (*Status, error) {
+	status := &Status{Name: name}
+	out, err := s.ssh.Exec(ssh.Cmd("service", name, "status"))
+	status.Output = string(out)
+	code, ok := exitStatus(err)
+	if err != nil && !ok {
+		return nil, errors.Wrapf(err, "error on service %s status", name)
+	}
+	status.State = lsbState(code)
+
+	links, err := s.ssh.Exec(ssh.Cmd("ls", "/etc/rc3.d"))
+	if err != nil {
+		s.log.Infof("warning: unable to list /etc/rc3.d: %v", err)
+		return status, nil
+	}
+	status.Enabled = sysvinitEnabled(name, links)
+	return status, nil
+}
+
+// service runs a service action with the service command
+func (s *Sysvinit) service(action, name string) error {
+	_, err := s.ssh.Stream(ssh.Cmd("service", name, action).Env("DEBIAN_FRONTEND", "noninteractive"))
+	if err != nil {
+		return errors.Wrapf(err, "error on service %s %s", name, action)
+	}
+	return nil
+}
+
+// rc runs the debian script when update-rc.d exists, otherwise the chkconfig
+// script. The scripts are constant and get the name of the service as $1.
+func (s *Sysvinit) rc(name, debian, chkconfig string) error {
+	script := `if command -v update-rc.d >/dev/null; then ` + debian + `; else ` + chkconfig + `; fi`
+	_, err := s.ssh.Stream(ssh.Script(script, name))
+	if err != nil {
+		return errors.Wrapf(err, "error on update-rc.d or chkconfig for service %s", name)
+	}
+	return nil
+}
+
+// sysvinitEnabled returns true when there is a start link, like S01nginx,
+// for the service in the output of ls
+func sysvinitEnabled(name string, ls []byte) bool {
+	re := regexp.MustCompile(`(?m)^S[0-9]{2}` + regexp.QuoteMeta(name) + `$`)
+	return re.Match(ls)
+}
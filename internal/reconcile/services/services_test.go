@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+)
+
+// TestDetect tests detecting the service manager from pid 1 and rc-service
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		out  string
+		want manifest.ServiceManager
+	}{
+		{out: "systemd\n", want: manifest.ServiceManagerSystemd},
+		{out: "init\n/sbin/rc-service\n", want: manifest.ServiceManagerOpenRC},
+		{out: "sshd\n", want: manifest.ServiceManagerSysvinit},
+		{out: "", want: manifest.ServiceManagerSysvinit},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, Detect([]byte(tt.out)), tt.want, tt.out)
+	}
+}
+
+// TestParseSystemdShow tests parsing systemctl show properties
+func TestParseSystemdShow(t *testing.T) {
+	tests := []struct {
+		out     string
+		state   State
+		enabled bool
+	}{
+		{out: "LoadState=loaded\nActiveState=active\nSubState=running\nUnitFileState=enabled\n",
+			state: StateRunning, enabled: true},
+		{out: "LoadState=loaded\nActiveState=inactive\nSubState=dead\nUnitFileState=disabled\n",
+			state: StateStopped},
+		{out: "LoadState=loaded\nActiveState=failed\nSubState=failed\nUnitFileState=enabled\n",
+			state: StateFailed, enabled: true},
+		{out: "LoadState=not-found\nActiveState=inactive\nSubState=dead\nUnitFileState=\n",
+			state: StateNotFound},
+		{out: "LoadState=loaded\nActiveState=activating\n", state: StateUnknown},
+	}
+	for _, tt := range tests {
+		status := parseSystemdShow("nginx", []byte(tt.out))
+		assert.Equal(t, status.State, tt.state, tt.out)
+		assert.Equal(t, status.Enabled, tt.enabled, tt.out)
+	}
+}
+
+// TestLsbState tests init script status exit codes
+func TestLsbState(t *testing.T) {
+	assert.Equal(t, lsbState(0), StateRunning)
+	assert.Equal(t, lsbState(1), StateFailed)
+	assert.Equal(t, lsbState(3), StateStopped)
+	assert.Equal(t, lsbState(4), StateUnknown)
+}
+
+// TestEnabled tests enabled at boot for sysvinit and OpenRC
+func TestEnabled(t *testing.T) {
+	ls := []byte("README\nK01php8.2-fpm\nS01nginx\nS01ssh\n")
+	assert.Check(t, sysvinitEnabled("nginx", ls))
+	assert.Check(t, !sysvinitEnabled("php8.2-fpm", ls))
+	assert.Check(t, !sysvinitEnabled("ngin", ls))
+
+	show := []byte("                 nginx | default\n                  sshd | default\n")
+	assert.Check(t, openRCEnabled("nginx", show))
+	assert.Check(t, !openRCEnabled("php-fpm82", show))
+}
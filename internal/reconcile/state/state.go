@@ -0,0 +1,175 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+	"slack-reconcile-deployments/internal/ssh"
+)
+
+// Path is the path of the state ledger on the target
+const Path = "/var/lib/reconcile-deployments/state.json"
+
+// Ledger is the state of the resources managed by reconcile on a target,
+// written after each reconcile. Later runs diff the manifest against the
+// ledger to find resources that are no longer declared.
+type Ledger struct {
+	// ManifestID is the id of the manifest of the last run
+	ManifestID string `json:"manifest_id"`
+	// RunID is the id of the last run
+	RunID string `json:"run_id"`
+	// UpdatedAt is when the ledger was written
+	UpdatedAt time.Time `json:"updated_at"`
+	// Files are the managed files
+	Files []File `json:"files"`
+	// Packages are the managed packages
+	Packages []Package `json:"packages"`
+}
+
+// File is a file managed by reconcile
+type File struct {
+	// Path is the path on the target
+	Path string `json:"path"`
+	// Package is the name of the package the file belongs to
+	Package string `json:"package"`
+	// Sha256 is the sha256 of the rendered file, as a hex string
+	Sha256 string `json:"sha256"`
+	// Created is true when the file did not exist before reconcile managed it
+	Created bool `json:"created"`
+}
+
+// Package is a package managed by reconcile
+type Package struct {
+	// Name is the name of the package
+	Name string `json:"name"`
+	// Version is the desired version of the package
+	Version string `json:"version,omitempty"`
+	// State is the desired state of the package
+	State manifest.PackageState `json:"state"`
+}
+
+// Read reads the ledger from the target, nil is returned when the target
+// has no ledger
+func Read(sshClient *ssh.Client) (*Ledger, error) {
+	out, err := sshClient.Exec(ssh.Script(`cat "$1" 2>/dev/null || true`, Path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading state %s", Path)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+	var l Ledger
+	if err := json.Unmarshal(out, &l); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshalling state %s", Path)
+	}
+	return &l, nil
+}
+
+// Write writes the ledger to the target, the ledger is copied to /tmp and
+// moved, so a failed copy does not leave a partial ledger. The copy is made
+// as the ssh user, who cannot write to the directory of Path, the move is
+// escalated.
+func Write(log *zap.SugaredLogger, sshClient *ssh.Client, l *Ledger) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error marshalling state")
+	}
+	tmp := path.Join("/", "tmp", fmt.Sprintf("reconcile-deployments-state.%s.json", l.RunID))
+	if err := ssh.NewSecureCopyClient(log, sshClient).Copy(bytes.NewReader(b), tmp); err != nil {
+		return errors.Wrapf(err, "error copying state to %s", tmp)
+	}
+	if out, err := sshClient.Exec(ssh.Script(`mkdir -p "$1" && mv "$2" "$3"`, path.Dir(Path), tmp, Path)); err != nil {
+		return errors.Wrapf(err, "error moving state to %s, out: %s", Path, out)
+	}
+	log.Infof("wrote state %s, %d files, %d packages", Path, len(l.Files), len(l.Packages))
+	return nil
+}
+
+// New creates the ledger for the manifest after a run, from the files
+// compared and transferred by the run. A file stays created when the
+// previous ledger has it as created.
+func New(m *manifest.Manifest, runID string, changes []files.FileChange, previous *Ledger) *Ledger {
+	l := &Ledger{
+		ManifestID: m.ID,
+		RunID:      runID,
+		UpdatedAt:  time.Now().UTC(),
+		Files:      []File{},
+		Packages:   []Package{},
+	}
+	for _, pkg := range m.Packages {
+		if pkg.Removed() {
+			continue
+		}
+		l.Packages = append(l.Packages, Package{Name: pkg.Name, Version: pkg.Version, State: pkg.DesiredState()})
+	}
+	for _, c := range changes {
+		f := File{Path: c.File.Path, Package: c.Package, Sha256: c.LocalSha256, Created: c.Create}
+		if before, ok := previous.File(c.File.Path); ok && before.Created {
+			f.Created = true
+		}
+		l.Files = append(l.Files, f)
+	}
+	return l
+}
+
+// File returns the file with the path from the ledger
+func (l *Ledger) File(path string) (File, bool) {
+	if l == nil {
+		return File{}, false
+	}
+	for _, f := range l.Files {
+		if f.Path == path {
+			return f, true
+		}
+	}
+	return File{}, false
+}
+
+// Owns returns true when reconcile owns the file at path: the file was
+// created by reconcile. Without a ledger, from runs before ledgers were
+// written, all files are owned.
+func (l *Ledger) Owns(path string) bool {
+	if l == nil {
+		return true
+	}
+	f, ok := l.File(path)
+	return ok && f.Created
+}
+
+// Orphans returns the files and packages in the ledger that are no longer
+// declared by the manifest. Files of removed packages are not orphans, they
+// are removed with the package.
+func (l *Ledger) Orphans(m *manifest.Manifest) ([]File, []Package) {
+	if l == nil {
+		return nil, nil
+	}
+	declaredFiles := make(map[string]bool)
+	declaredPackages := make(map[string]bool)
+	for _, pkg := range m.Packages {
+		declaredPackages[pkg.Name] = true
+		for _, f := range pkg.Files {
+			declaredFiles[f.Path] = true
+		}
+	}
+	orphanFiles := make([]File, 0)
+	for _, f := range l.Files {
+		if !declaredFiles[f.Path] {
+			orphanFiles = append(orphanFiles, f)
+		}
+	}
+	orphanPackages := make([]Package, 0)
+	for _, pkg := range l.Packages {
+		if !declaredPackages[pkg.Name] {
+			orphanPackages = append(orphanPackages, pkg)
+		}
+	}
+	return orphanFiles, orphanPackages
+}
@@ -0,0 +1,71 @@
+package state
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/manifest"
+	"slack-reconcile-deployments/internal/reconcile/files"
+)
+
+// TestNew tests files stay created when the previous ledger has them created
+func TestNew(t *testing.T) {
+	m := &manifest.Manifest{
+		ID: "b2267d6b23",
+		Packages: []manifest.Package{
+			{Name: "nginx", Version: "latest"},
+			{Name: "php8.2-fpm", Version: "8.2"},
+			{Name: "memcached", State: manifest.PackageStateAbsent},
+		},
+	}
+	previous := &Ledger{Files: []File{{Path: "/var/www/html/info.php", Created: true}}}
+	changes := []files.FileChange{
+		{Package: "nginx", File: manifest.File{Path: "/etc/nginx/sites-available/default"},
+			LocalSha256: "a", Stat: &files.Stat{}},
+		{Package: "php8.2-fpm", File: manifest.File{Path: "/var/www/html/info.php"}, LocalSha256: "b"},
+		{Package: "php8.2-fpm", File: manifest.File{Path: "/var/www/html/index.php"}, LocalSha256: "c", Create: true},
+	}
+
+	l := New(m, "20231016T193725Z-a1b2c3", changes, previous)
+	assert.Equal(t, l.ManifestID, "b2267d6b23")
+	assert.Equal(t, l.RunID, "20231016T193725Z-a1b2c3")
+	assert.DeepEqual(t, l.Files, []File{
+		{Path: "/etc/nginx/sites-available/default", Package: "nginx", Sha256: "a"},
+		{Path: "/var/www/html/info.php", Package: "php8.2-fpm", Sha256: "b", Created: true},
+		{Path: "/var/www/html/index.php", Package: "php8.2-fpm", Sha256: "c", Created: true},
+	})
+	assert.DeepEqual(t, l.Packages, []Package{
+		{Name: "nginx", Version: "latest", State: manifest.PackageStateLatest},
+		{Name: "php8.2-fpm", Version: "8.2", State: manifest.PackageStatePresent},
+	})
+}
+
+// TestOrphans tests files and packages no longer declared are orphans
+func TestOrphans(t *testing.T) {
+	l := &Ledger{
+		Files: []File{
+			{Path: "/etc/nginx/sites-available/default", Package: "nginx"},
+			{Path: "/etc/nginx/conf.d/old.conf", Package: "nginx", Created: true},
+			{Path: "/var/www/html/info.php", Package: "php8.2-fpm", Created: true},
+		},
+		Packages: []Package{{Name: "nginx"}, {Name: "php8.2-fpm"}},
+	}
+	m := &manifest.Manifest{
+		Packages: []manifest.Package{
+			{Name: "nginx", Files: []manifest.File{{Path: "/etc/nginx/sites-available/default"}}},
+		},
+	}
+	orphanFiles, orphanPackages := l.Orphans(m)
+	assert.DeepEqual(t, orphanFiles, []File{l.Files[1], l.Files[2]})
+	assert.DeepEqual(t, orphanPackages, []Package{{Name: "php8.2-fpm"}})
+
+	assert.Check(t, !l.Owns("/etc/nginx/sites-available/default"))
+	assert.Check(t, l.Owns("/etc/nginx/conf.d/old.conf"))
+	assert.Check(t, !l.Owns("/etc/hosts"))
+
+	var none *Ledger
+	assert.Check(t, none.Owns("/etc/hosts"), "without a ledger all files are owned")
+	orphanFiles, orphanPackages = none.Orphans(m)
+	assert.Equal(t, len(orphanFiles)+len(orphanPackages), 0)
+}
@@ -0,0 +1,245 @@
+package rollout
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// OnError is the policy of a rollout when a host fails
+type OnError string
+
+const (
+	// OnErrorContinue hosts in flight keep running when a host fails, the
+	// rollout stops after the batch when failures exceed max failures
+	OnErrorContinue = OnError("continue")
+	// OnErrorFailFast hosts in flight are cancelled through their context
+	// as soon as failures exceed max failures
+	OnErrorFailFast = OnError("fail-fast")
+)
+
+// ParseOnError parses an on error policy, continue when empty
+func ParseOnError(s string) (OnError, error) {
+	switch OnError(s) {
+	case "", OnErrorContinue:
+		return OnErrorContinue, nil
+	case OnErrorFailFast:
+		return OnErrorFailFast, nil
+	default:
+		return "", errors.Errorf("invalid on error policy %s, must be %s or %s", s, OnErrorContinue, OnErrorFailFast)
+	}
+}
+
+// Options are the options of a rolling rollout
+type Options struct {
+	// BatchSize is the number of hosts reconciled in each batch, all hosts
+	// are one batch when zero
+	BatchSize int
+	// Concurrency is the number of hosts of a batch reconciled concurrently
+	Concurrency int
+	// MaxFailures is the number of failed hosts tolerated, the rollout stops
+	// after the batch when failures exceed it
+	MaxFailures int
+	// Pause is the pause between batches
+	Pause time.Duration
+	// OnError is the policy when a host fails
+	OnError OnError
+}
+
+// Result is the result of a rollout
+type Result struct {
+	// Failed are the errors of failed hosts, by index
+	Failed map[int]error
+	// Skipped are the indexes of hosts not reconciled because the rollout stopped
+	Skipped []int
+	// Stopped is true when the rollout stopped before all hosts were reconciled
+	Stopped bool
+}
+
+// Stage is a stage of a rollout, the hosts of a stage are reconciled only
+// after all stages before it succeeded
+type Stage struct {
+	// Name is the name of the stage
+	Name string
+	// Hosts are the indexes of the hosts of the stage
+	Hosts []int
+	// Options are the options of the rollout of the stage
+	Options Options
+}
+
+// ParseBatchSize parses a batch size of a number of hosts, like 2, or a
+// percentage of total hosts, like 25%. A percentage is rounded up, so a
+// batch has at least one host. An empty batch size is all hosts.
+func ParseBatchSize(s string, total int) (int, error) {
+	if s == "" {
+		return total, nil
+	}
+	if percent, ok := strings.CutSuffix(s, "%"); ok {
+		p, err := strconv.Atoi(percent)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, errors.Errorf("invalid batch size %s, percentage must be 1%% to 100%%", s)
+		}
+		return (total*p + 99) / 100, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, errors.Errorf("invalid batch size %s, must be a positive number or a percentage", s)
+	}
+	return n, nil
+}
+
+// Batches splits n hosts into batches of size hosts, by index
+func Batches(n, size int) [][]int {
+	if size <= 0 || size > n {
+		size = n
+	}
+	batches := make([][]int, 0)
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		batch := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			batch = append(batch, i)
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// Run runs fn for n hosts, by index, in batches. A host is healthy when fn
+// returns nil, fn runs health checks of the host. After each batch the
+// rollout stops when failures exceed the max failures, the hosts of later
+// batches are skipped. With fail-fast, hosts in flight are cancelled and
+// hosts of the batch not yet started are skipped as soon as failures exceed
+// the max failures. An error is returned when any host failed.
+func Run(ctx context.Context, log *zap.SugaredLogger, n int, opts Options,
+	fn func(ctx context.Context, i int) error) (*Result, error) {
+	result := &Result{Failed: make(map[int]error)}
+	batches := Batches(n, opts.BatchSize)
+	// batchCtx is cancelled to fail fast, the context of hosts in flight
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// failFast is true after hosts in flight were cancelled
+	failFast := false
+	var mu sync.Mutex
+	for b, batch := range batches {
+		if b > 0 && opts.Pause > 0 {
+			log.Infof("pausing %v before batch %d of %d", opts.Pause, b+1, len(batches))
+			select {
+			case <-time.After(opts.Pause):
+			case <-ctx.Done():
+				return result, skip(result, batches[b:], ctx.Err())
+			}
+		}
+		log.Infof("rolling out batch %d of %d, %d hosts", b+1, len(batches), len(batch))
+		errgrp := errgroup.Group{}
+		if opts.Concurrency > 0 {
+			errgrp.SetLimit(opts.Concurrency)
+		}
+		// cancelled are the hosts of the batch not started after fail-fast
+		cancelled := make([]int, 0)
+		for _, i := range batch {
+			i := i
+			errgrp.Go(func() error {
+				if batchCtx.Err() != nil {
+					mu.Lock()
+					cancelled = append(cancelled, i)
+					mu.Unlock()
+					return nil
+				}
+				err := fn(batchCtx, i)
+				if err == nil {
+					return nil
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if failFast {
+					err = errors.Wrap(err, "cancelled by fail-fast")
+				}
+				result.Failed[i] = err
+				if opts.OnError == OnErrorFailFast && !failFast && len(result.Failed) > opts.MaxFailures {
+					log.Infof("failing fast, %d failed hosts exceed max failures %d, cancelling hosts in flight",
+						len(result.Failed), opts.MaxFailures)
+					failFast = true
+					cancel()
+				}
+				return nil
+			})
+		}
+		_ = errgrp.Wait()
+		sort.Ints(cancelled)
+		if len(result.Failed) > opts.MaxFailures {
+			err := errors.Errorf("rollout stopped after batch %d of %d, %d failed hosts exceed max failures %d",
+				b+1, len(batches), len(result.Failed), opts.MaxFailures)
+			return result, skip(result, append([][]int{cancelled}, batches[b+1:]...), err)
+		}
+		// hosts are not started when the rollout was cancelled
+		if len(cancelled) > 0 {
+			return result, skip(result, append([][]int{cancelled}, batches[b+1:]...), batchCtx.Err())
+		}
+	}
+	if len(result.Failed) > 0 {
+		return result, errors.Errorf("%d of %d hosts failed", len(result.Failed), n)
+	}
+	return result, nil
+}
+
+// RunStages runs fn for the hosts of the stages, by index, in order. The
+// hosts of each stage are rolled out with Run and the options of the stage.
+// The next stage starts only after the previous stage succeeded, when a host
+// of a stage failed, even within its max failures, the hosts of later stages
+// are skipped. An error is returned when any host failed.
+func RunStages(ctx context.Context, log *zap.SugaredLogger, stages []Stage,
+	fn func(ctx context.Context, i int) error) (*Result, error) {
+	result := &Result{Failed: make(map[int]error)}
+	n := 0
+	for _, stage := range stages {
+		n += len(stage.Hosts)
+	}
+	for s, stage := range stages {
+		log.Infof("starting stage %d of %d, %s, %d hosts", s+1, len(stages), stage.Name, len(stage.Hosts))
+		hosts := stage.Hosts
+		stageResult, err := Run(ctx, log, len(hosts), stage.Options, func(ctx context.Context, i int) error {
+			return fn(ctx, hosts[i])
+		})
+		for i, err := range stageResult.Failed {
+			result.Failed[hosts[i]] = err
+		}
+		for _, i := range stageResult.Skipped {
+			result.Skipped = append(result.Skipped, hosts[i])
+		}
+		if stageResult.Stopped || len(stageResult.Failed) > 0 {
+			later := make([][]int, 0, len(stages)-s-1)
+			for _, next := range stages[s+1:] {
+				later = append(later, next.Hosts)
+			}
+			return result, skip(result, later, errors.Wrapf(err, "stage %s failed", stage.Name))
+		}
+	}
+	if len(result.Failed) > 0 {
+		return result, errors.Errorf("%d of %d hosts failed", len(result.Failed), n)
+	}
+	return result, nil
+}
+
+// skip records the hosts of the batches as skipped, stops the rollout and
+// returns err
+func skip(result *Result, batches [][]int, err error) error {
+	result.Stopped = true
+	for _, batch := range batches {
+		result.Skipped = append(result.Skipped, batch...)
+	}
+	if len(result.Skipped) > 0 {
+		return errors.Wrapf(err, "%d hosts skipped", len(result.Skipped))
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package rollout
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+)
+
+func TestParseBatchSize(t *testing.T) {
+	tests := []struct {
+		s     string
+		total int
+		want  int
+		err   string
+	}{
+		{s: "", total: 7, want: 7},
+		{s: "2", total: 7, want: 2},
+		{s: "25%", total: 7, want: 2},
+		{s: "100%", total: 7, want: 7},
+		{s: "1%", total: 3, want: 1},
+		{s: "0", total: 7, err: "invalid batch size 0"},
+		{s: "abc", total: 7, err: "invalid batch size abc"},
+		{s: "150%", total: 7, err: "percentage must be 1% to 100%"},
+	}
+	for _, tt := range tests {
+		got, err := ParseBatchSize(tt.s, tt.total)
+		if tt.err != "" {
+			assert.ErrorContains(t, err, tt.err, tt.s)
+			continue
+		}
+		assert.NilError(t, err, tt.s)
+		assert.Equal(t, got, tt.want, tt.s)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	assert.DeepEqual(t, Batches(5, 2), [][]int{{0, 1}, {2, 3}, {4}})
+	assert.DeepEqual(t, Batches(3, 0), [][]int{{0, 1, 2}})
+	assert.DeepEqual(t, Batches(3, 5), [][]int{{0, 1, 2}})
+	assert.DeepEqual(t, Batches(0, 2), [][]int{})
+}
+
+// TestRunMaxFailures tests the rollout stops after the batch exceeding the
+// max failures, and later hosts are skipped
+func TestRunMaxFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxFailures int
+		failed      []int
+		skipped     []int
+		err         string
+	}{
+		{name: "no failures", failed: []int{}},
+		{name: "stop", failed: []int{2}, skipped: []int{4, 5}, err: "rollout stopped after batch 2 of 3"},
+		{name: "within budget", maxFailures: 1, failed: []int{2}, err: "1 of 6 hosts failed"},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		ran := make([]int, 0)
+		fail := map[int]bool{}
+		for _, i := range tt.failed {
+			fail[i] = true
+		}
+		opts := Options{BatchSize: 2, Concurrency: 2, MaxFailures: tt.maxFailures}
+		result, err := Run(context.Background(), logging.New(t.Name(), true), 6, opts,
+			func(_ context.Context, i int) error {
+				mu.Lock()
+				ran = append(ran, i)
+				mu.Unlock()
+				if fail[i] {
+					return errors.Errorf("host %d unhealthy", i)
+				}
+				return nil
+			})
+		if tt.err == "" {
+			assert.NilError(t, err, tt.name)
+		} else {
+			assert.ErrorContains(t, err, tt.err, tt.name)
+		}
+		assert.Equal(t, len(result.Failed), len(tt.failed), tt.name)
+		assert.DeepEqual(t, result.Skipped, tt.skipped)
+		assert.Equal(t, len(ran), 6-len(tt.skipped), tt.name)
+	}
+}
+
+// TestRunStages tests a stage starts only after the previous stage
+// succeeded, and hosts of later stages are skipped when a stage stops
+func TestRunStages(t *testing.T) {
+	stages := []Stage{
+		{Name: "php", Hosts: []int{1, 2}, Options: Options{Concurrency: 2}},
+		{Name: "nginx", Hosts: []int{0}, Options: Options{Concurrency: 1}},
+	}
+	var mu sync.Mutex
+	ran := make([]int, 0)
+	run := func(fail int) func(context.Context, int) error {
+		return func(_ context.Context, i int) error {
+			mu.Lock()
+			ran = append(ran, i)
+			mu.Unlock()
+			if i == fail {
+				return errors.Errorf("host %d unhealthy", i)
+			}
+			return nil
+		}
+	}
+
+	result, err := RunStages(context.Background(), logging.New(t.Name(), true), stages, run(-1))
+	assert.NilError(t, err)
+	assert.Equal(t, len(ran), 3)
+	// nginx of the second stage runs after the php backends
+	assert.Equal(t, ran[2], 0)
+	assert.Check(t, !result.Stopped)
+
+	ran = ran[:0]
+	result, err = RunStages(context.Background(), logging.New(t.Name(), true), stages, run(2))
+	assert.ErrorContains(t, err, "stage php failed")
+	assert.Equal(t, len(ran), 2)
+	assert.ErrorContains(t, result.Failed[2], "host 2 unhealthy")
+	assert.DeepEqual(t, result.Skipped, []int{0})
+	assert.Check(t, result.Stopped)
+
+	// a failure within max failures completes the stage, later stages do not start
+	ran = ran[:0]
+	stages[0].Options.MaxFailures = 1
+	result, err = RunStages(context.Background(), logging.New(t.Name(), true), stages, run(2))
+	assert.ErrorContains(t, err, "stage php failed")
+	assert.ErrorContains(t, err, "1 of 2 hosts failed")
+	assert.Equal(t, len(ran), 2)
+	assert.DeepEqual(t, result.Skipped, []int{0})
+	assert.Check(t, result.Stopped)
+}
+
+// TestRunFailFast tests fail-fast cancels hosts in flight and skips hosts not
+// started, while continue runs hosts in flight to completion
+func TestRunFailFast(t *testing.T) {
+	tests := []struct {
+		onError   OnError
+		cancelled bool
+		skipped   []int
+	}{
+		{onError: OnErrorContinue, cancelled: false},
+		{onError: OnErrorFailFast, cancelled: true, skipped: []int{2, 3}},
+	}
+	for _, tt := range tests {
+		// host 0 fails once host 1 is in flight, host 1 runs until cancelled
+		inFlight := make(chan struct{})
+		opts := Options{Concurrency: 2, OnError: tt.onError}
+		result, err := Run(context.Background(), logging.New(t.Name(), true), 4, opts,
+			func(ctx context.Context, i int) error {
+				switch i {
+				case 0:
+					<-inFlight
+					return errors.New("host 0 unhealthy")
+				case 1:
+					close(inFlight)
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(100 * time.Millisecond):
+						return nil
+					}
+				}
+				return nil
+			})
+		assert.ErrorContains(t, err, "rollout stopped after batch 1 of 1", tt.onError)
+		_, cancelled := result.Failed[1]
+		assert.Equal(t, cancelled, tt.cancelled, tt.onError)
+		if tt.cancelled {
+			assert.ErrorContains(t, result.Failed[1], "cancelled by fail-fast")
+		}
+		assert.DeepEqual(t, result.Skipped, tt.skipped)
+	}
+}
+
+func TestParseOnError(t *testing.T) {
+	onError, err := ParseOnError("")
+	assert.NilError(t, err)
+	assert.Equal(t, onError, OnErrorContinue)
+	onError, err = ParseOnError("fail-fast")
+	assert.NilError(t, err)
+	assert.Equal(t, onError, OnErrorFailFast)
+	_, err = ParseOnError("retry")
+	assert.ErrorContains(t, err, "invalid on error policy retry")
+}
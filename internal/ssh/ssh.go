@@ -1,7 +1,10 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"path"
 	"time"
 
@@ -17,10 +20,18 @@ import (
 
 // Client is an ssh client
 type Client struct {
-	log     *zap.SugaredLogger
-	client  *ssh.Client
-	host    string
-	useSudo bool
+	log    *zap.SugaredLogger
+	client *ssh.Client
+	host   string
+	// escalation is how commands are run as root, or as another user
+	escalation Escalation
+	// ctx bounds commands run with Execf and copies, a command in flight is
+	// killed when ctx is done
+	ctx context.Context
+	// output is where lines of streamed commands are written, nil to only log them
+	output io.Writer
+	// prefix is the prefix of lines written to output
+	prefix string
 }
 
 // New creates a new ssh session.
@@ -41,7 +52,10 @@ type Client struct {
 // connect client to sshd ignoring known hosts:
 //
 // ssh -o StrictHostKeyChecking=no root@127.0.0.1
-func New(log *zap.SugaredLogger, allowInsecureHostKey bool,
+//
+// Dialing is retried until ctx is done, and commands run with Execf are
+// bound to ctx.
+func New(ctx context.Context, log *zap.SugaredLogger, allowInsecureHostKey bool,
 	privateKey []byte, username, password, host string) (*Client, error) {
 	log.Infof("dialing %s@%s", username, host)
 	var err error
@@ -82,49 +96,86 @@ func New(log *zap.SugaredLogger, allowInsecureHostKey bool,
 
 	var client *ssh.Client
 	if err := backoff.Retry(func() error {
-		client, err = ssh.Dial("tcp", host, config)
+		client, err = dial(ctx, host, config)
 		if err != nil {
 			log.Infof("error dialing %s@%s, retrying %+v", username, host, err)
 			return errors.Wrap(err, "failed to dial")
 		}
 		return nil
-	}, backoff.WithMaxRetries(backoff.NewConstantBackOff(3*time.Second), 30)); err != nil {
+	}, backoff.WithContext(backoff.WithMaxRetries(backoff.NewConstantBackOff(3*time.Second), 30), ctx)); err != nil {
 		return nil, errors.Wrap(err, "retries exhausted, ssh, failed to dial")
 	}
 
 	log.Infof("server version %s, client version %s", client.ServerVersion(), client.ClientVersion())
 
 	return &Client{
-		log:     log,
-		host:    host,
-		client:  client,
-		useSudo: username != "root",
+		log:        log,
+		host:       host,
+		client:     client,
+		escalation: defaultEscalation(username),
+		ctx:        ctx,
 	}, nil
 
 }
 
-// Execf executes a command on the ssh session
-func (c *Client) Execf(cmd string, args ...interface{}) ([]byte, error) {
-	session, err := c.client.NewSession()
+// dial dials host and runs the ssh handshake, both are abandoned when ctx is done
+func dial(ctx context.Context, host string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create session")
+		return nil, err
 	}
-	defer func() {
-		_ = session.Close()
-	}()
-
-	// replace format spec with args
-	cmd = fmt.Sprintf(cmd, args...)
-	if c.useSudo {
-		cmd = fmt.Sprintf("sudo %s", cmd)
+	// the handshake has no context, it is bound to the deadline of ctx and
+	// interrupted when ctx is done
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
 	}
-	c.log.Infof("exec %s", cmd)
-	buf, err := session.CombinedOutput(cmd) // cmd is ignored by fixedOutputHandler
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, host, config)
 	if err != nil {
-		return nil, errors.Wrapf(err, "remote command did not exit cleanly: %s", buf)
+		_ = conn.Close()
+		return nil, err
 	}
+	_ = conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// WithContext returns a copy of the client sharing the connection, with
+// commands bound to ctx. Use it to clean up after the context of the client
+// is done.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	cc := *c
+	cc.ctx = ctx
+	return &cc
+}
+
+// Execf executes a command on the ssh session, bound to the context of the
+// client. The combined output is returned with the error when the command
+// does not exit cleanly.
+func (c *Client) Execf(cmd string, args ...interface{}) ([]byte, error) {
+	return c.ExecContext(c.ctx, cmd, args...)
+}
+
+// ExecContext executes a command on the ssh session. The command is not
+// started when ctx is done, and a command in flight is killed and its
+// session closed when ctx is done. The combined output is returned with the
+// error when the command does not exit cleanly.
+func (c *Client) ExecContext(ctx context.Context, cmd string, args ...interface{}) ([]byte, error) {
+	result, err := c.run(ctx, fmt.Sprintf(cmd, args...), nil)
+	return result.Combined, err
+}
 
-	return buf, err
+// Host returns the host:port the client is connected to
+func (c *Client) Host() string {
+	return c.host
 }
 
 // Close closes the ssh client and session
@@ -0,0 +1,130 @@
+package ssh
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// safeWordRE matches words passed to a POSIX shell as is, without quoting
+var safeWordRE = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// envNameRE matches names of environment variables
+var envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Command is a remote command, a program and its arguments. Arguments are
+// quoted for POSIX shells, so the program receives each argument as is, a
+// path with spaces or ; is one argument and never interpreted by the shell.
+type Command struct {
+	// env are environment variables of the program, name=value
+	env []string
+	// program is the program to run
+	program string
+	// args are the arguments of the program
+	args []string
+}
+
+// Cmd returns the command running program with args
+func Cmd(program string, args ...string) *Command {
+	return &Command{program: program, args: args}
+}
+
+// Script returns the command running script with sh -c, args are the
+// positional parameters of the script, $1, $2... Use it for commands that
+// need the shell, like redirects or &&, the script must be constant and
+// values are only passed as args.
+//
+// Example:
+//
+//	Script(`mkdir -p "$1" && cp -p "$2" "$3"`, dir, src, dst)
+func Script(script string, args ...string) *Command {
+	return Cmd("sh", append([]string{"-c", script, "sh"}, args...)...)
+}
+
+// Env sets the environment variable name of the program to value
+func (c *Command) Env(name, value string) *Command {
+	c.env = append(c.env, name+"="+value)
+	return c
+}
+
+// Validate returns an error when the command cannot be represented safely,
+// arguments with a NUL byte cannot be passed to a program
+func (c *Command) Validate() error {
+	if c.program == "" {
+		return errors.New("command has no program")
+	}
+	for _, env := range c.env {
+		name, _, _ := strings.Cut(env, "=")
+		if !envNameRE.MatchString(name) {
+			return errors.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	for _, word := range append(append([]string{c.program}, c.env...), c.args...) {
+		if strings.ContainsRune(word, 0) {
+			return errors.Errorf("argument %q of %s contains a NUL byte", word, c.program)
+		}
+	}
+	return nil
+}
+
+// String returns the command line with the program and arguments quoted for
+// POSIX shells
+func (c *Command) String() string {
+	words := make([]string, 0, len(c.env)+1+len(c.args))
+	for _, env := range c.env {
+		name, value, _ := strings.Cut(env, "=")
+		words = append(words, name+"="+Quote(value))
+	}
+	words = append(words, Quote(c.program))
+	for _, arg := range c.args {
+		words = append(words, Quote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// Quote quotes s for POSIX shells, s is returned as is when it has no
+// characters special to the shell. Single quotes preserve every character
+// except the single quote, which ends the quoting, is escaped with a
+// backslash and starts the quoting again.
+//
+// Example:
+//
+//	Quote("/etc/my app.conf") returns '/etc/my app.conf'
+//	Quote("it's") returns 'it'\''s'
+func Quote(s string) string {
+	if safeWordRE.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Exec executes cmd on the ssh session, bound to the context of the client.
+// The combined output is returned with the error when the command does not
+// exit cleanly.
+func (c *Client) Exec(cmd *Command) ([]byte, error) {
+	result, err := c.Run(cmd)
+	if result == nil {
+		return nil, err
+	}
+	return result.Combined, err
+}
+
+// Run executes cmd on the ssh session like Exec, without streaming. The
+// result is returned with the error when the command does not exit
+// cleanly, use it to parse stdout without lines of stderr.
+func (c *Client) Run(cmd *Command) (*Result, error) {
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return c.run(c.ctx, cmd.String(), nil)
+}
+
+// Stream executes cmd on the ssh session like Exec, streaming the lines of
+// output as they are written, see StreamContext
+func (c *Client) Stream(cmd *Command) (*Result, error) {
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return c.StreamContext(c.ctx, nil, "%s", cmd.String())
+}
@@ -0,0 +1,49 @@
+package ssh
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestQuote tests words are quoted for the shell only when needed
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{word: "/etc/nginx/nginx.conf", want: "/etc/nginx/nginx.conf"},
+		{word: "nginx=1.24.0-1", want: "nginx=1.24.0-1"},
+		{word: "~root", want: "'~root'"},
+		{word: "", want: "''"},
+		{word: "/etc/my app.conf", want: "'/etc/my app.conf'"},
+		{word: "a; rm -rf /", want: "'a; rm -rf /'"},
+		{word: "it's", want: `'it'\''s'`},
+		{word: "$(id)", want: "'$(id)'"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, Quote(tt.word), tt.want, tt.word)
+	}
+}
+
+// TestCommand tests every argument of a command reaches the program as is
+// when run by a POSIX shell
+func TestCommand(t *testing.T) {
+	args := []string{"/etc/my app.conf", "a; touch /tmp/pwned", "it's", "$(id)", "`id`", "*", "", "line\nbreak"}
+	cmd := Cmd("printf", append([]string{`%s\n`}, args...)...).Env("LC_ALL", "C")
+	assert.NilError(t, cmd.Validate())
+	assert.Assert(t, strings.HasPrefix(cmd.String(), `LC_ALL=C printf '%s\n' '/etc/my app.conf' `), cmd.String())
+
+	out, err := exec.Command("sh", "-c", cmd.String()).Output()
+	assert.NilError(t, err, cmd.String())
+	assert.Equal(t, string(out), strings.Join(args, "\n")+"\n")
+
+	script := Script(`mkdir -p "$1" && echo "$2"`, "/tmp/my dir", "it's")
+	assert.Equal(t, script.String(), `sh -c 'mkdir -p "$1" && echo "$2"' sh '/tmp/my dir' 'it'\''s'`)
+
+	assert.ErrorContains(t, Cmd("rm", "-f", "/tmp/a\x00b").Validate(), "NUL byte")
+	assert.ErrorContains(t, Cmd("apt-get").Env("A B", "1").Validate(), "invalid environment variable name")
+	assert.ErrorContains(t, Cmd("").Validate(), "command has no program")
+}
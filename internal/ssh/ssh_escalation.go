@@ -0,0 +1,150 @@
+package ssh
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// EscalationMethod is how commands are run with the privileges of another
+// user, root unless a user is set
+type EscalationMethod string
+
+const (
+	// EscalationNone runs commands as the ssh user, commands run as another
+	// user with su, which needs no password when the ssh user is root. The
+	// password is not used.
+	EscalationNone = EscalationMethod("none")
+	// EscalationSudo runs commands with sudo
+	EscalationSudo = EscalationMethod("sudo")
+	// EscalationDoas runs commands with doas, doas reads a password only from
+	// a terminal, so doas must permit the ssh user with nopass or persist
+	EscalationDoas = EscalationMethod("doas")
+	// EscalationSu runs commands with su, su reads a password only from a
+	// terminal, so su must not need a password, like when the ssh user is root
+	EscalationSu = EscalationMethod("su")
+)
+
+// Escalation is the privilege escalation of commands run on the target
+type Escalation struct {
+	// Method is the escalation method, the method of the client is kept
+	// when not set
+	Method EscalationMethod
+	// Password is written to stdin of sudo, never logged. Escalation must not
+	// prompt for a password when not set.
+	Password string
+	// User is the user commands run as, root when not set
+	User string
+}
+
+// ParseEscalationMethod parses an escalation method, none, sudo, doas or su
+func ParseEscalationMethod(s string) (EscalationMethod, error) {
+	switch method := EscalationMethod(s); method {
+	case EscalationNone, EscalationSudo, EscalationDoas, EscalationSu:
+		return method, nil
+	}
+	return "", errors.Errorf("invalid escalation method %s, must be none, sudo, doas or su", s)
+}
+
+// Validate returns an error when the escalation cannot be used
+func (e Escalation) Validate() error {
+	if e.Method != "" {
+		if _, err := ParseEscalationMethod(string(e.Method)); err != nil {
+			return err
+		}
+	}
+	if e.Method == EscalationDoas && e.Password != "" {
+		return errors.New("doas cannot read a password from stdin, permit the ssh user with nopass or persist")
+	}
+	if e.Method == EscalationSu && e.Password != "" {
+		return errors.New("su cannot read a password from stdin, use sudo or ssh as root")
+	}
+	return nil
+}
+
+// String returns the method and user of the escalation, without the password
+func (e Escalation) String() string {
+	user := e.User
+	if user == "" {
+		user = "root"
+	}
+	return fmt.Sprintf("%s as %s", e.Method, user)
+}
+
+// defaultEscalation returns the escalation of username, root runs commands
+// as is and other users with sudo without a password
+func defaultEscalation(username string) Escalation {
+	if username == "root" {
+		return Escalation{Method: EscalationNone}
+	}
+	return Escalation{Method: EscalationSudo}
+}
+
+// wrap returns cmd run with the escalation, and stdin of the escalation,
+// the password followed by a newline for sudo. Only sudo -S reads the
+// password from stdin, other methods would pass it to cmd. Commands are run
+// by sh -c, so all of a compound command like a && b is escalated.
+func (e Escalation) wrap(cmd string) (string, []byte) {
+	var stdin []byte
+	var escalated *Command
+	switch e.Method {
+	case EscalationSudo:
+		// -n fails instead of prompting when a password is needed and not
+		// set, -k ignores cached credentials so sudo always reads the
+		// password from stdin, -S, instead of passing it to cmd
+		escalated = Cmd("sudo", "-n")
+		if e.Password != "" {
+			escalated = Cmd("sudo", "-S", "-k", "-p", "")
+			stdin = []byte(e.Password + "\n")
+		}
+		if e.User != "" {
+			escalated.args = append(escalated.args, "-u", e.User)
+		}
+		escalated.args = append(escalated.args, "--", "sh", "-c", cmd)
+	case EscalationDoas:
+		escalated = Cmd("doas", "-n")
+		if e.User != "" {
+			escalated.args = append(escalated.args, "-u", e.User)
+		}
+		escalated.args = append(escalated.args, "sh", "-c", cmd)
+	case EscalationSu:
+		escalated = su(cmd, e.User)
+	default:
+		if e.User == "" {
+			return cmd, nil
+		}
+		escalated = su(cmd, e.User)
+	}
+	return escalated.String(), stdin
+}
+
+// su returns the command running cmd as user with su, root when user is not
+// set. The shell is set, so users with a nologin shell, like www-data, can
+// run commands.
+func su(cmd, user string) *Command {
+	if user == "" {
+		user = "root"
+	}
+	return Cmd("su", "-s", "/bin/sh", "-c", cmd, user)
+}
+
+// WithEscalation returns a copy of the client sharing the connection, with
+// commands run with escalation. The method of the client is kept when the
+// method of escalation is not set.
+func (c *Client) WithEscalation(escalation Escalation) *Client {
+	cc := *c
+	if escalation.Method == "" {
+		escalation.Method = c.escalation.Method
+	}
+	cc.escalation = escalation
+	return &cc
+}
+
+// AsUser returns a copy of the client sharing the connection, with commands
+// run as user with the escalation method of the client. Use it to run
+// commands as a service user, like deploying files as www-data.
+func (c *Client) AsUser(user string) *Client {
+	cc := *c
+	cc.escalation.User = user
+	return &cc
+}
@@ -0,0 +1,61 @@
+package ssh
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// TestEscalationWrap tests commands are wrapped with the escalation method,
+// as the user, and the password is only written to stdin of sudo
+func TestEscalationWrap(t *testing.T) {
+	tests := []struct {
+		escalation Escalation
+		want       string
+		stdin      string
+	}{
+		{escalation: Escalation{Method: EscalationNone}, want: "rm -f /tmp/a && ls"},
+		{escalation: Escalation{Method: EscalationNone, Password: "s3cret", User: "www-data"},
+			want: "su -s /bin/sh -c 'rm -f /tmp/a && ls' www-data"},
+		{escalation: Escalation{Method: EscalationSudo}, want: "sudo -n -- sh -c 'rm -f /tmp/a && ls'"},
+		{escalation: Escalation{Method: EscalationSudo, Password: "s3cret", User: "www-data"},
+			want: "sudo -S -k -p '' -u www-data -- sh -c 'rm -f /tmp/a && ls'", stdin: "s3cret\n"},
+		{escalation: Escalation{Method: EscalationDoas, User: "www-data"},
+			want: "doas -n -u www-data sh -c 'rm -f /tmp/a && ls'"},
+		{escalation: Escalation{Method: EscalationSudo, Password: "it's"},
+			want: "sudo -S -k -p '' -- sh -c 'rm -f /tmp/a && ls'", stdin: "it's\n"},
+		{escalation: Escalation{Method: EscalationSu},
+			want: "su -s /bin/sh -c 'rm -f /tmp/a && ls' root"},
+	}
+	for _, tt := range tests {
+		cmd, stdin := tt.escalation.wrap("rm -f /tmp/a && ls")
+		assert.Equal(t, cmd, tt.want, tt.escalation.String())
+		assert.Equal(t, string(stdin), tt.stdin, tt.escalation.String())
+	}
+}
+
+// TestEscalation tests parsing and validating escalations, and the default
+// escalation of the ssh user
+func TestEscalation(t *testing.T) {
+	method, err := ParseEscalationMethod("doas")
+	assert.NilError(t, err)
+	assert.Equal(t, method, EscalationDoas)
+	_, err = ParseEscalationMethod("pkexec")
+	assert.ErrorContains(t, err, "invalid escalation method pkexec")
+
+	assert.NilError(t, Escalation{}.Validate())
+	assert.NilError(t, Escalation{Method: EscalationSudo, Password: "s3cret"}.Validate())
+	assert.ErrorContains(t, Escalation{Method: EscalationDoas, Password: "s3cret"}.Validate(),
+		"doas cannot read a password from stdin")
+	assert.ErrorContains(t, Escalation{Method: EscalationSu, Password: "s3cret"}.Validate(),
+		"su cannot read a password from stdin")
+	assert.Equal(t, Escalation{Method: EscalationSudo, Password: "s3cret"}.String(), "sudo as root")
+
+	assert.Equal(t, defaultEscalation("root").Method, EscalationNone)
+	assert.Equal(t, defaultEscalation("admin").Method, EscalationSudo)
+
+	client := (&Client{escalation: defaultEscalation("admin")}).WithEscalation(Escalation{Password: "s3cret"})
+	assert.Equal(t, client.escalation.Method, EscalationSudo)
+	assert.Equal(t, client.AsUser("www-data").escalation.User, "www-data")
+	assert.Equal(t, client.escalation.User, "")
+}
@@ -2,6 +2,7 @@ package ssh
 
 import (
 	"bufio"
+	"context"
 	"io"
 	"os"
 	"path"
@@ -18,6 +19,8 @@ type SecureCopyClient struct {
 	log *zap.SugaredLogger
 	// ssh client to invoke package commands on
 	ssh *ssh.Client
+	// ctx bounds copies, a copy in flight is abandoned when ctx is done
+	ctx context.Context
 }
 
 // NewSecureCopyClient creates a new secure copy client.
@@ -26,6 +29,7 @@ func NewSecureCopyClient(log *zap.SugaredLogger, client *Client) *SecureCopyClie
 	return &SecureCopyClient{
 		log: log,
 		ssh: client.client,
+		ctx: client.ctx,
 	}
 }
 
@@ -36,14 +40,29 @@ func NewSecureCopyClient(log *zap.SugaredLogger, client *Client) *SecureCopyClie
 // exists errors.
 //
 // MaxPacket size is 1<<15(32kb) so we don't set that option.
+//
+// The sftp session is closed when the context of the client is done,
+// failing the copy in flight.
 func (s *SecureCopyClient) Copy(data io.Reader, filepath string) error {
+	if err := s.ctx.Err(); err != nil {
+		return errors.Wrapf(err, "not copying %s", filepath)
+	}
 	c, err := sftp.NewClient(s.ssh)
 	if err != nil {
 		return errors.Wrap(err, "unable to start sftp from ssh client")
 	}
+	done := make(chan struct{})
 	defer func() {
+		close(done)
 		_ = c.Close()
 	}()
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			_ = c.Close()
+		case <-done:
+		}
+	}()
 
 	// reset the reader back to the beginning, to ensure we're copying from beginning
 	if seeker, ok := data.(io.Seeker); ok {
@@ -95,6 +114,9 @@ func (s *SecureCopyClient) Copy(data io.Reader, filepath string) error {
 			}
 			return errors.Wrapf(err, "error reading from reader while copying %s", filepath)
 		}
+		if err := s.ctx.Err(); err != nil {
+			return errors.Wrapf(err, "copy of %s cancelled", filepath)
+		}
 
 		if err != nil {
 			return errors.Wrapf(err, "error writing to remote file while copying %s", filepath)
@@ -2,6 +2,7 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"testing"
 
@@ -12,7 +13,7 @@ import (
 
 func testDockerSSH(t *testing.T, f *testhelpers.DockerTestFixtures) {
 	// using localhost:port here for now, the docker container is exporting and rebinding ports for ssh/22
-	client, err := New(f.Log, true, f.PrivateKey,
+	client, err := New(context.Background(), f.Log, true, f.PrivateKey,
 		"root", "", fmt.Sprintf("%s:%d", "127.0.0.1", f.SSHPort))
 	if err != nil {
 		fmt.Printf("error creating ssh client %+v", err)
@@ -25,7 +26,7 @@ func testDockerSSH(t *testing.T, f *testhelpers.DockerTestFixtures) {
 }
 
 func testSecureCopyClient(t *testing.T, f *testhelpers.DockerTestFixtures) {
-	client, err := New(f.Log, true, f.PrivateKey,
+	client, err := New(context.Background(), f.Log, true, f.PrivateKey,
 		"root", "", fmt.Sprintf("%s:%d", "127.0.0.1", f.SSHPort))
 	if err != nil {
 		fmt.Printf("error creating ssh client %+v", err)
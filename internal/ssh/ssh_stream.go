@@ -0,0 +1,190 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Stream is an output stream of a remote command
+type Stream string
+
+const (
+	// StreamStdout is the standard output of a remote command
+	StreamStdout = Stream("stdout")
+	// StreamStderr is the standard error of a remote command
+	StreamStderr = Stream("stderr")
+)
+
+// Line is a line of output of a remote command, without the line ending
+type Line struct {
+	// Stream is the stream the line was written to
+	Stream Stream
+	// Text is the text of the line
+	Text string
+}
+
+// Result is the result of a remote command
+type Result struct {
+	// Command is the command that was run
+	Command string
+	// Stdout is the standard output of the command
+	Stdout []byte
+	// Stderr is the standard error of the command
+	Stderr []byte
+	// Combined is stdout and stderr, interleaved in the order output arrived
+	Combined []byte
+	// ExitStatus is the exit status of the command, -1 when the command did
+	// not exit, like when it was killed by a signal or cancelled
+	ExitStatus int
+	// Signal is the signal that killed the command, empty when it exited
+	Signal string
+}
+
+// WithOutput returns a copy of the client sharing the connection, writing
+// the lines of streamed commands to w as they arrive, prefixed with prefix,
+// like the id of the manifest. Lines of stderr are marked stderr.
+func (c *Client) WithOutput(w io.Writer, prefix string) *Client {
+	cc := *c
+	cc.output = w
+	cc.prefix = prefix
+	return &cc
+}
+
+// Streamf executes a command on the ssh session bound to the context of the
+// client, like Execf. Each line of output is logged, and written to the
+// output of the client, as it arrives. Use it for long running commands,
+// like installing packages, so progress is visible.
+func (c *Client) Streamf(cmd string, args ...interface{}) (*Result, error) {
+	return c.StreamContext(c.ctx, nil, cmd, args...)
+}
+
+// StreamContext executes a command on the ssh session like ExecContext.
+// Each line of output is logged, written to the output of the client and
+// passed to fn, when not nil, as it arrives. Lines are passed to fn one at a
+// time. The result is returned with the error when the command does not
+// exit cleanly.
+func (c *Client) StreamContext(ctx context.Context, fn func(Line), cmd string,
+	args ...interface{}) (*Result, error) {
+	return c.run(ctx, fmt.Sprintf(cmd, args...), func(line Line) {
+		if line.Stream == StreamStderr {
+			c.log.Infof("ssh 2> %s", line.Text)
+		} else {
+			c.log.Infof("ssh> %s", line.Text)
+		}
+		if c.output != nil {
+			if line.Stream == StreamStderr {
+				_, _ = fmt.Fprintf(c.output, "[%s] stderr: %s\n", c.prefix, line.Text)
+			} else {
+				_, _ = fmt.Fprintf(c.output, "[%s] %s\n", c.prefix, line.Text)
+			}
+		}
+		if fn != nil {
+			fn(line)
+		}
+	})
+}
+
+// run runs cmd, passing each line of output to fn as it arrives when fn is
+// not nil. The command is not started when ctx is done, and a command in
+// flight is killed and its session closed when ctx is done.
+func (c *Client) run(ctx context.Context, cmd string, fn func(Line)) (*Result, error) {
+	result := &Result{Command: cmd, ExitStatus: -1}
+	if err := ctx.Err(); err != nil {
+		return result, errors.Wrapf(err, "not running remote command %s", cmd)
+	}
+	session, err := c.client.NewSession()
+	if err != nil {
+		return result, errors.Wrap(err, "failed to create session")
+	}
+	defer func() {
+		_ = session.Close()
+	}()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return result, errors.Wrap(err, "failed to get stdout of session")
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return result, errors.Wrap(err, "failed to get stderr of session")
+	}
+
+	cmd, stdin := c.escalation.wrap(cmd)
+	if stdin != nil {
+		session.Stdin = bytes.NewReader(stdin)
+	}
+	c.log.Infof("exec %s", cmd)
+	if err := session.Start(cmd); err != nil {
+		return result, errors.Wrapf(err, "failed to start remote command %s", cmd)
+	}
+
+	// mu serializes output of stdout and stderr, closed is true once the
+	// result was returned on cancel, later output is dropped
+	var mu sync.Mutex
+	closed := false
+	read := func(r io.Reader, stream Stream, buf *[]byte) {
+		reader := bufio.NewReader(r)
+		for {
+			chunk, err := reader.ReadBytes('\n')
+			if len(chunk) > 0 {
+				mu.Lock()
+				if !closed {
+					*buf = append(*buf, chunk...)
+					result.Combined = append(result.Combined, chunk...)
+					if fn != nil {
+						fn(Line{Stream: stream, Text: string(bytes.TrimRight(chunk, "\r\n"))})
+					}
+				}
+				mu.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		read(stdout, StreamStdout, &result.Stdout)
+	}()
+	go func() {
+		defer readers.Done()
+		read(stderr, StreamStderr, &result.Stderr)
+	}()
+	done := make(chan error, 1)
+	go func() {
+		readers.Wait()
+		done <- session.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			result.ExitStatus = 0
+			return result, nil
+		}
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitStatus = exitErr.ExitStatus()
+			result.Signal = exitErr.Signal()
+		}
+		return result, errors.Wrapf(err, "remote command did not exit cleanly: %s", result.Combined)
+	case <-ctx.Done():
+		// closing the session releases the wait on the command, the signal
+		// kills the command on servers supporting signals
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		cancelled := *result
+		return &cancelled, errors.Wrapf(ctx.Err(), "remote command cancelled: %s", cmd)
+	}
+}
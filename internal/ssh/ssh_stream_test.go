@@ -0,0 +1,106 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+)
+
+// serveExec serves ssh sessions on listener, an exec request writes a line
+// to stdout and stderr and exits with status 3
+func serveExec(t *testing.T, listener net.Listener) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NilError(t, err)
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	assert.NilError(t, err)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChannel := range chans {
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					for req := range requests {
+						_ = req.Reply(req.Type == "exec", nil)
+						if req.Type != "exec" {
+							continue
+						}
+						_, _ = channel.Write([]byte("installing nginx\n"))
+						_, _ = channel.Stderr().Write([]byte("warning: no tty\n"))
+						_, _ = channel.Write([]byte("done"))
+						status := make([]byte, 4)
+						binary.BigEndian.PutUint32(status, 3)
+						_, _ = channel.SendRequest("exit-status", false, status)
+						_ = channel.Close()
+					}
+				}()
+			}
+		}()
+	}
+}
+
+// TestStreamContext tests lines of stdout and stderr are streamed separately
+// as they arrive, and the exit status is returned in the result
+func TestStreamContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	go serveExec(t, listener)
+
+	client, err := New(context.Background(), logging.New(t.Name(), true), true, nil, "root", "",
+		listener.Addr().String())
+	assert.NilError(t, err)
+	defer client.Close()
+
+	output := bytes.NewBuffer([]byte{})
+	lines := make([]Line, 0)
+	result, err := client.WithOutput(output, "b2267d6b23").StreamContext(context.Background(),
+		func(line Line) {
+			lines = append(lines, line)
+		}, "apt-get install -y %s", "nginx")
+	assert.ErrorContains(t, err, "remote command did not exit cleanly")
+	assert.Equal(t, result.ExitStatus, 3)
+	assert.Equal(t, result.Command, "apt-get install -y nginx")
+	assert.Equal(t, string(result.Stdout), "installing nginx\ndone")
+	assert.Equal(t, string(result.Stderr), "warning: no tty\n")
+	assert.Equal(t, len(result.Combined), len(result.Stdout)+len(result.Stderr))
+	assert.Equal(t, len(lines), 3)
+	assert.Assert(t, bytes.Contains(output.Bytes(), []byte("[b2267d6b23] installing nginx\n")), output.String())
+	assert.Assert(t, bytes.Contains(output.Bytes(), []byte("[b2267d6b23] stderr: warning: no tty\n")),
+		output.String())
+	for _, line := range lines {
+		if line.Stream == StreamStderr {
+			assert.Equal(t, line.Text, "warning: no tty")
+		}
+	}
+
+	// execf returns the combined output, without streaming
+	output.Reset()
+	out, err := client.WithOutput(output, "b2267d6b23").Execf("apt-get install -y nginx")
+	assert.ErrorContains(t, err, "remote command did not exit cleanly")
+	assert.Equal(t, len(out), len(result.Combined))
+	assert.Equal(t, output.Len(), 0)
+}
@@ -0,0 +1,48 @@
+package ssh
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gotest.tools/v3/assert"
+
+	"slack-reconcile-deployments/internal/logging"
+)
+
+// TestDialContext tests dialing a host that accepts connections but never
+// completes the handshake stops when the context is done
+func TestDialContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// hold the connection open without an ssh handshake
+			defer func() {
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = dial(ctx, listener.Addr().String(), &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	assert.Assert(t, err != nil)
+	assert.Assert(t, time.Since(start) < 5*time.Second, time.Since(start))
+
+	// retries stop when the context is done
+	start = time.Now()
+	_, err = New(ctx, logging.New(t.Name(), true), true, nil, "root", "", listener.Addr().String())
+	assert.ErrorContains(t, err, "failed to dial")
+	assert.Assert(t, time.Since(start) < 5*time.Second, time.Since(start))
+}
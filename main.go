@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"syscall"
 
 	"github.com/urfave/cli/v2"
 
+	"slack-reconcile-deployments/cmd/cleanup"
+	"slack-reconcile-deployments/cmd/drift"
 	"slack-reconcile-deployments/cmd/generate"
 	"slack-reconcile-deployments/cmd/reconcile"
+	"slack-reconcile-deployments/cmd/rollback"
 	"slack-reconcile-deployments/cmd/verify"
+	"slack-reconcile-deployments/internal/interrupt"
 )
 
 func main() {
@@ -19,10 +25,19 @@ func main() {
 			reconcile.New(),
 			generate.New(),
 			verify.New(),
+			drift.New(),
+			rollback.New(),
+			cleanup.New(),
 		},
 		Flags: []cli.Flag{},
 	}
-	if err := app.Run(os.Args); err != nil {
+	// the first signal cancels runs in flight, which close their backends and
+	// release their locks, a second signal exits without cleanup
+	ctx, stop := interrupt.NotifyContext(context.Background(), interrupt.DefaultGracePeriod,
+		os.Interrupt, syscall.SIGTERM)
+	err := app.RunContext(ctx, os.Args)
+	stop()
+	if err != nil {
 		log.Fatal(err)
 	}
 }